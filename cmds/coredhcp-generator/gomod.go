@@ -0,0 +1,157 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// replacedVersion is the version required for a replaced module which has no
+// version pin, as `go mod edit -replace` does
+const replacedVersion = "v0.0.0-00010101000000-000000000000"
+
+// pluginSpec is a plugin to include, as specified on the command line or in
+// the plugin list file:
+//
+//  import/path
+//  module/path@version[/package/path] [=> replacement]
+//
+// The module providing the plugin is only known when a version separates it
+// from the package path, or when it is replaced, in which case the module path
+// defaults to the import path. Otherwise `go mod tidy` looks it up.
+type pluginSpec struct {
+	importPath string
+	module     string
+	version    string
+	replace    string
+}
+
+func parsePlugin(line string) (*pluginSpec, error) {
+	var spec pluginSpec
+	if i := strings.Index(line, "=>"); i >= 0 {
+		spec.replace = strings.TrimSpace(line[i+2:])
+		if spec.replace == "" {
+			return nil, errors.New("empty replacement")
+		}
+		line = strings.TrimSpace(line[:i])
+	}
+	if i := strings.IndexByte(line, '@'); i >= 0 {
+		spec.module = line[:i]
+		spec.version = line[i+1:]
+		spec.importPath = spec.module
+		if j := strings.IndexByte(spec.version, '/'); j >= 0 {
+			spec.importPath += spec.version[j:]
+			spec.version = spec.version[:j]
+		}
+		if !strings.HasPrefix(spec.version, "v") {
+			return nil, fmt.Errorf("invalid version '%s', want eg. v1.2.3", spec.version)
+		}
+	} else {
+		spec.importPath = line
+		if spec.replace != "" {
+			spec.module = line
+		}
+	}
+	if spec.importPath == "" || strings.ContainsAny(spec.importPath, " \t") {
+		return nil, fmt.Errorf("invalid import path '%s'", spec.importPath)
+	}
+	if spec.module == "" && strings.HasPrefix(spec.importPath, importBase) {
+		// builtin plugins are versioned with the -coredhcp-* flags
+		spec.module = coredhcpModule
+	}
+	return &spec, nil
+}
+
+// module is a module required by the generated go.mod
+type module struct {
+	version string
+	replace string
+}
+
+// moduleSet collects the modules of the plugins, checking that they don't
+// ask for different versions of the same module
+type moduleSet map[string]*module
+
+func newModuleSet() moduleSet {
+	return make(moduleSet)
+}
+
+// add records the version and the replacement of a module. Either can be
+// empty, and the module path too if it is unknown.
+func (ms moduleSet) add(path, version, replace string) error {
+	if path == "" {
+		return nil
+	}
+	if isLocalPath(replace) {
+		// go.mod resolves relative paths from its own directory
+		abs, err := filepath.Abs(replace)
+		if err != nil {
+			return err
+		}
+		replace = abs
+	}
+	m, ok := ms[path]
+	if !ok {
+		m = &module{}
+		ms[path] = m
+	}
+	if version != "" {
+		if m.version != "" && m.version != version {
+			return fmt.Errorf("module %s required at both %s and %s", path, m.version, version)
+		}
+		m.version = version
+	}
+	if replace != "" {
+		if m.replace != "" && m.replace != replace {
+			return fmt.Errorf("module %s replaced by both %s and %s", path, m.replace, replace)
+		}
+		m.replace = replace
+	}
+	return nil
+}
+
+// isLocalPath tells whether a replacement is a directory rather than a module
+func isLocalPath(replace string) bool {
+	return replace == "." || replace == ".." || filepath.IsAbs(replace) ||
+		strings.HasPrefix(replace, "./") || strings.HasPrefix(replace, "../")
+}
+
+// goMod returns the content of the go.mod of the generated module. Modules
+// without version nor replacement are left to `go mod tidy`.
+func (ms moduleSet) goMod(name string) []byte {
+	var paths []string
+	for path, m := range ms {
+		if m.version != "" || m.replace != "" {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// This is a generated file, created by coredhcp-generator\n\nmodule %s\n\ngo 1.13\n", name)
+	if len(paths) == 0 {
+		return b.Bytes()
+	}
+	b.WriteString("\nrequire (\n")
+	for _, path := range paths {
+		version := ms[path].version
+		if version == "" {
+			version = replacedVersion
+		}
+		fmt.Fprintf(&b, "\t%s %s\n", path, version)
+	}
+	b.WriteString(")\n")
+	for _, path := range paths {
+		if r := ms[path].replace; r != "" {
+			fmt.Fprintf(&b, "\nreplace %s => %s\n", path, strings.Replace(r, "@", " ", 1))
+		}
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,94 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePlugin(t *testing.T) {
+	for line, want := range map[string]pluginSpec{
+		"github.com/coredhcp/coredhcp/plugins/dns": {
+			importPath: "github.com/coredhcp/coredhcp/plugins/dns",
+			module:     coredhcpModule,
+		},
+		"github.com/example/redis": {
+			importPath: "github.com/example/redis",
+		},
+		"github.com/example/plugins@v1.2.0/redis": {
+			importPath: "github.com/example/plugins/redis",
+			module:     "github.com/example/plugins",
+			version:    "v1.2.0",
+		},
+		"github.com/example/redis@v0.1.0": {
+			importPath: "github.com/example/redis",
+			module:     "github.com/example/redis",
+			version:    "v0.1.0",
+		},
+		"github.com/example/redis => ../redis": {
+			importPath: "github.com/example/redis",
+			module:     "github.com/example/redis",
+			replace:    "../redis",
+		},
+		"github.com/example/plugins@v1.2.0/redis => github.com/fork/plugins@v1.2.1": {
+			importPath: "github.com/example/plugins/redis",
+			module:     "github.com/example/plugins",
+			version:    "v1.2.0",
+			replace:    "github.com/fork/plugins@v1.2.1",
+		},
+	} {
+		spec, err := parsePlugin(line)
+		if assert.NoError(t, err, line) {
+			assert.Equal(t, want, *spec, line)
+		}
+	}
+	for _, line := range []string{
+		"github.com/example/redis@latest",
+		"github.com/example/redis@",
+		"github.com/example/redis =>",
+		"@v1.0.0",
+	} {
+		_, err := parsePlugin(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestGoMod(t *testing.T) {
+	ms := newModuleSet()
+	require.NoError(t, ms.add(coredhcpModule, "", "/src/coredhcp"))
+	require.NoError(t, ms.add("github.com/example/plugins", "v1.2.0", ""))
+	require.NoError(t, ms.add("github.com/example/plugins", "", "github.com/fork/plugins@v1.2.1"))
+	require.NoError(t, ms.add("github.com/example/plugins", "v1.2.0", ""))
+	require.NoError(t, ms.add("github.com/example/unversioned", "", ""))
+	require.NoError(t, ms.add("", "", ""))
+	assert.Error(t, ms.add("github.com/example/plugins", "v1.3.0", ""))
+	assert.Error(t, ms.add(coredhcpModule, "", "/src/other"))
+
+	assert.Equal(t, `// This is a generated file, created by coredhcp-generator
+
+module coredhcp
+
+go 1.13
+
+require (
+	github.com/coredhcp/coredhcp v0.0.0-00010101000000-000000000000
+	github.com/example/plugins v1.2.0
+)
+
+replace github.com/coredhcp/coredhcp => /src/coredhcp
+
+replace github.com/example/plugins => github.com/fork/plugins v1.2.1
+`, string(ms.goMod("coredhcp")))
+
+	rel := newModuleSet()
+	require.NoError(t, rel.add("github.com/example/redis", "", "../redis"))
+	abs, err := filepath.Abs("../redis")
+	require.NoError(t, err)
+	assert.Equal(t, abs, rel["github.com/example/redis"].replace)
+}
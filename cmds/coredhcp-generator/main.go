@@ -7,26 +7,31 @@ package main
 import (
 	"bufio"
 	"fmt"
-	"html/template"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"sort"
 	"strings"
+	"text/template"
 
 	flag "github.com/spf13/pflag"
 )
 
 const (
 	defaultTemplateFile = "coredhcp.go.template"
-	importBase          = "github.com/coredhcp/coredhcp/"
+	coredhcpModule      = "github.com/coredhcp/coredhcp"
+	importBase          = coredhcpModule + "/"
 )
 
 var (
 	flagTemplate = flag.StringP("template", "t", defaultTemplateFile, "Template file name")
 	flagOutfile  = flag.StringP("outfile", "o", "", "Output file path")
 	flagFromFile = flag.StringP("from", "f", "", "Optional file name to get the plugin list from, one import path per line")
+	flagGoMod    = flag.Bool("gomod", true, "Also write a go.mod file in the output directory")
+	flagModule   = flag.String("module", "coredhcp", "Module path of the generated go.mod")
+	flagVersion  = flag.String("coredhcp-version", "", "Version of coredhcp to require in the generated go.mod. Default: resolved by 'go mod tidy'")
+	flagReplace  = flag.String("coredhcp-replace", "", "Replace coredhcp with this path or module@version in the generated go.mod, eg. a local checkout")
 )
 
 var funcMap = template.FuncMap{
@@ -47,7 +52,11 @@ func usage() {
 	flag.PrintDefaults()
 	fmt.Fprintln(flag.CommandLine.Output(), `  plugin
 	Plugin name to include, as go import path.
-	Short names can be used for builtin coredhcp plugins (eg "serverid")`)
+	Short names can be used for builtin coredhcp plugins (eg "serverid")
+	A version can be pinned after the module path, and the module replaced
+	as in go.mod, eg:
+	  github.com/example/plugins@v1.2.0/redis
+	  github.com/example/plugins/redis => ../plugins`)
 }
 
 func main() {
@@ -63,12 +72,26 @@ func main() {
 		log.Fatalf("Template parsing failed: %v", err)
 	}
 	plugins := make(map[string]bool)
+	modules := newModuleSet()
+	if err := modules.add(coredhcpModule, *flagVersion, *flagReplace); err != nil {
+		log.Fatalf("Invalid coredhcp version: %v", err)
+	}
+	addPlugin := func(line string) {
+		spec, err := parsePlugin(line)
+		if err != nil {
+			log.Fatalf("Invalid plugin '%s': %v", line, err)
+		}
+		if err := modules.add(spec.module, spec.version, spec.replace); err != nil {
+			log.Fatalf("Invalid plugin '%s': %v", line, err)
+		}
+		plugins[spec.importPath] = true
+	}
 	for _, pl := range flag.Args() {
 		pl := strings.TrimSpace(pl)
 		if pl == "" {
 			continue
 		}
-		if !strings.ContainsRune(pl, '/') {
+		if !strings.ContainsAny(pl, "/@") {
 			// A bare name was specified, not a full import path.
 			// Coredhcp plugins aren't in the standard library, and it's unlikely someone
 			// would put them at the base of $GOPATH/src.
@@ -78,7 +101,7 @@ func main() {
 			// XXX: we could also look into github.com/coredhcp/plugins
 			pl = importBase + pl
 		}
-		plugins[pl] = true
+		addPlugin(pl)
 	}
 	if *flagFromFile != "" {
 		// additional plugin names from a text file, one line per plugin import
@@ -95,10 +118,10 @@ func main() {
 		sc := bufio.NewScanner(fd)
 		for sc.Scan() {
 			pl := strings.TrimSpace(sc.Text())
-			if pl == "" {
+			if pl == "" || strings.HasPrefix(pl, "#") {
 				continue
 			}
-			plugins[pl] = true
+			addPlugin(pl)
 		}
 		if err := sc.Err(); err != nil {
 			log.Fatalf("Error reading file '%s': %v", *flagFromFile, err)
@@ -122,7 +145,7 @@ func main() {
 		log.Printf("% 3d) %s", idx, pl)
 		idx++
 	}
-	outFD, err := os.OpenFile(outfile, os.O_CREATE|os.O_WRONLY, 0644)
+	outFD, err := os.OpenFile(outfile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		log.Fatalf("Failed to create output file '%s': %v", outfile, err)
 	}
@@ -140,6 +163,15 @@ func main() {
 	if err := t.Execute(outFD, pluginList); err != nil {
 		log.Fatalf("Template execution failed: %v", err)
 	}
-	log.Printf("Generated file '%s'. You can build it by running 'go build' in the output directory.", outfile)
+	if !*flagGoMod {
+		log.Printf("Generated file '%s'. You can build it by running 'go build' in the output directory.", outfile)
+		fmt.Println(path.Dir(outfile))
+		return
+	}
+	gomod := path.Join(path.Dir(outfile), "go.mod")
+	if err := ioutil.WriteFile(gomod, modules.goMod(*flagModule), 0644); err != nil {
+		log.Fatalf("Failed to write '%s': %v", gomod, err)
+	}
+	log.Printf("Generated files '%s' and '%s'. You can build them by running 'go mod tidy && go build' in the output directory.", outfile, gomod)
 	fmt.Println(path.Dir(outfile))
 }
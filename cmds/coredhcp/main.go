@@ -8,16 +8,21 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
-	"time"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/events"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/server"
 
 	"github.com/coredhcp/coredhcp/plugins"
+	pl_class "github.com/coredhcp/coredhcp/plugins/class"
 	pl_dns "github.com/coredhcp/coredhcp/plugins/dns"
+	pl_execute "github.com/coredhcp/coredhcp/plugins/execute"
+	pl_external "github.com/coredhcp/coredhcp/plugins/external"
 	pl_file "github.com/coredhcp/coredhcp/plugins/file"
 	pl_leasetime "github.com/coredhcp/coredhcp/plugins/leasetime"
 	pl_mtu "github.com/coredhcp/coredhcp/plugins/mtu"
@@ -26,11 +31,12 @@ import (
 	pl_prefix "github.com/coredhcp/coredhcp/plugins/prefix"
 	pl_range "github.com/coredhcp/coredhcp/plugins/range"
 	pl_router "github.com/coredhcp/coredhcp/plugins/router"
+	pl_script "github.com/coredhcp/coredhcp/plugins/script"
 	pl_searchdomains "github.com/coredhcp/coredhcp/plugins/searchdomains"
 	pl_serverid "github.com/coredhcp/coredhcp/plugins/serverid"
+	"github.com/coredhcp/coredhcp/plugins/shared"
 	pl_sleep "github.com/coredhcp/coredhcp/plugins/sleep"
 	pl_staticroute "github.com/coredhcp/coredhcp/plugins/staticroute"
-	pl_execute "github.com/coredhcp/coredhcp/plugins/execute"
 	pl_tiny_subnets "github.com/coredhcp/coredhcp/plugins/tiny_subnets"
 
 	"github.com/sirupsen/logrus"
@@ -38,20 +44,29 @@ import (
 )
 
 var (
-	flagLogFile     = flag.StringP("logfile", "l", "", "Name of the log file to append to. Default: stdout/stderr only")
-	flagLogNoStdout = flag.BoolP("nostdout", "N", false, "Disable logging to stdout/stderr")
-	flagLogLevel    = flag.StringP("loglevel", "L", "info", fmt.Sprintf("Log level. One of %v", getLogLevels()))
-	flagConfig      = flag.StringP("conf", "c", "", "Use this configuration file instead of the default location")
-	flagPlugins     = flag.BoolP("plugins", "P", false, "list plugins")
+	flagLogFile       = flag.StringP("logfile", "l", "", "Name of the log file to append to. Default: stdout/stderr only. The file is reopened on SIGUSR1")
+	flagLogFileFormat = flag.String("logfile-format", logger.FormatLogfmt, fmt.Sprintf("Format of the log file. One of %v", logger.Formats))
+	flagLogNoStdout   = flag.BoolP("nostdout", "N", false, "Disable logging to stdout/stderr")
+	flagLogLevel      = flag.StringP("loglevel", "L", "info", fmt.Sprintf("Log level. One of %v", getLogLevels()))
+	flagLogFormat     = flag.StringP("logformat", "F", logger.FormatText, fmt.Sprintf("Format of the logs on stdout/stderr. One of %v", logger.Formats))
+	flagSyslog        = flag.Bool("syslog", false, "Also log to syslog")
+	flagSyslogSocket  = flag.String("syslog-socket", "", "Unix socket of the syslog daemon. Default: the system default")
+	flagSyslogFormat  = flag.String("syslog-format", logger.FormatLogfmt, fmt.Sprintf("Format of the messages sent to syslog. One of %v", logger.Formats))
+	flagJournald      = flag.Bool("journald", false, "Also log to systemd-journald, with structured fields")
+	flagConfig        = flag.StringP("conf", "c", "", "Use this configuration file instead of the default location")
+	flagPlugins       = flag.BoolP("plugins", "P", false, "list plugins and their arguments")
+	flagCheckConfig   = flag.Bool("check-config", false, "Check the configuration file and the plugin arguments, then exit")
+	flagDumpConfig    = flag.String("dump-config", "", "Print the configuration merged from all the included files, in yaml (the default) or json, then exit")
+	flagLoadPlugins   = flag.StringSlice("load-plugin", nil, "Go plugin (.so) to load in addition to the builtin plugins, if built with -tags shared. Can be repeated")
 )
 
-var logLevels = map[string]func(*logrus.Logger){
-	"none":    func(l *logrus.Logger) { l.SetOutput(ioutil.Discard) },
-	"debug":   func(l *logrus.Logger) { l.SetLevel(logrus.DebugLevel) },
-	"info":    func(l *logrus.Logger) { l.SetLevel(logrus.InfoLevel) },
-	"warning": func(l *logrus.Logger) { l.SetLevel(logrus.WarnLevel) },
-	"error":   func(l *logrus.Logger) { l.SetLevel(logrus.ErrorLevel) },
-	"fatal":   func(l *logrus.Logger) { l.SetLevel(logrus.FatalLevel) },
+var logLevels = map[string]func(*logrus.Entry){
+	"none":    func(l *logrus.Entry) { logger.WithNoStdOutErr(l) },
+	"debug":   func(*logrus.Entry) { logger.SetLevel(logrus.DebugLevel) },
+	"info":    func(*logrus.Entry) { logger.SetLevel(logrus.InfoLevel) },
+	"warning": func(*logrus.Entry) { logger.SetLevel(logrus.WarnLevel) },
+	"error":   func(*logrus.Entry) { logger.SetLevel(logrus.ErrorLevel) },
+	"fatal":   func(*logrus.Entry) { logger.SetLevel(logrus.FatalLevel) },
 }
 
 func getLogLevels() []string {
@@ -63,7 +78,10 @@ func getLogLevels() []string {
 }
 
 var desiredPlugins = []*plugins.Plugin{
+	&pl_class.Plugin,
 	&pl_dns.Plugin,
+	&pl_execute.Plugin,
+	&pl_external.Plugin,
 	&pl_file.Plugin,
 	&pl_leasetime.Plugin,
 	&pl_mtu.Plugin,
@@ -72,43 +90,146 @@ var desiredPlugins = []*plugins.Plugin{
 	&pl_prefix.Plugin,
 	&pl_range.Plugin,
 	&pl_router.Plugin,
+	&pl_script.Plugin,
 	&pl_searchdomains.Plugin,
 	&pl_serverid.Plugin,
 	&pl_sleep.Plugin,
 	&pl_staticroute.Plugin,
-	&pl_execute.Plugin,
 	&pl_tiny_subnets.Plugin,
 }
 
+// loadPlugins adds the plugins loaded from the --load-plugin files to the
+// builtin plugins
+func loadPlugins() error {
+	for _, path := range *flagLoadPlugins {
+		plugin, err := shared.Load(path)
+		if err != nil {
+			return fmt.Errorf("failed to load plugin '%s': %w", path, err)
+		}
+		desiredPlugins = append(desiredPlugins, plugin)
+	}
+	return nil
+}
+
+// checkConfig loads the configuration and sets up the plugins in dry-run mode,
+// reporting all the errors found. It returns the exit status.
+func checkConfig() int {
+	// only show problems
+	logger.SetLevel(logrus.WarnLevel)
+	for _, plugin := range desiredPlugins {
+		if err := plugins.RegisterPlugin(plugin); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to register plugin '%s': %v\n", plugin.Name, err)
+			return 1
+		}
+	}
+	conf, err := config.Load(*flagConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		return 1
+	}
+	errs := plugins.CheckPlugins(conf)
+	for _, sink := range conf.Events {
+		if _, ok := events.SinkTypes[sink.Type]; !ok {
+			errs = append(errs, fmt.Errorf("events: unknown sink type '%s'", sink.Type))
+		}
+	}
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "%d error(s) found\n", len(errs))
+		return 1
+	}
+	fmt.Println("Configuration OK")
+	return 0
+}
+
+// dumpConfig prints the merged configuration. It returns the exit status.
+func dumpConfig(format string) int {
+	logger.SetLevel(logrus.WarnLevel)
+	conf, err := config.Load(*flagConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		return 1
+	}
+	data, err := conf.Dump(format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	// keep stdout parseable
+	fmt.Fprintf(os.Stderr, "Merged from: %s\n", strings.Join(conf.Files(), ", "))
+	os.Stdout.Write(data)
+	return 0
+}
+
 func main() {
+	flag.Lookup("dump-config").NoOptDefVal = "yaml"
 	flag.Parse()
 
+	if err := loadPlugins(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	if *flagPlugins {
 		for _, p := range desiredPlugins {
-			fmt.Println(p.Name)
+			fmt.Println(p.Usage())
 		}
 		os.Exit(0)
 	}
+	if *flagCheckConfig {
+		os.Exit(checkConfig())
+	}
+	if *flagDumpConfig != "" {
+		os.Exit(dumpConfig(*flagDumpConfig))
+	}
 
 	log := logger.GetLogger("main")
 	fn, ok := logLevels[*flagLogLevel]
 	if !ok {
 		log.Fatalf("Invalid log level '%s'. Valid log levels are %v", *flagLogLevel, getLogLevels())
 	}
-	fn(log.Logger)
+	fn(log)
 	log.Infof("Setting log level to '%s'", *flagLogLevel)
+	if err := logger.SetFormat(log, *flagLogFormat); err != nil {
+		log.Fatalf("Invalid log format: %v", err)
+	}
 	if *flagLogFile != "" {
 		log.Infof("Logging to file %s", *flagLogFile)
-		logger.WithFile(log, *flagLogFile)
+		if err := logger.WithFile(log, *flagLogFile, *flagLogFileFormat); err != nil {
+			log.Fatalf("Failed to set up log file: %v", err)
+		}
+		reopen := make(chan os.Signal, 1)
+		signal.Notify(reopen, syscall.SIGUSR1)
+		go func() {
+			for range reopen {
+				if err := logger.ReopenFiles(); err != nil {
+					log.Errorf("Failed to reopen log files: %v", err)
+				}
+			}
+		}()
+	}
+	if *flagSyslog {
+		log.Infof("Logging to syslog")
+		if err := logger.WithSyslog(log, *flagSyslogSocket, *flagSyslogFormat); err != nil {
+			log.Fatalf("Failed to set up syslog logging: %v", err)
+		}
+	}
+	if *flagJournald {
+		log.Infof("Logging to journald")
+		if err := logger.WithJournald(log, ""); err != nil {
+			log.Fatalf("Failed to set up journald logging: %v", err)
+		}
 	}
 	if *flagLogNoStdout {
 		log.Infof("Disabling logging to stdout/stderr")
 		logger.WithNoStdOutErr(log)
 	}
-	config, err := config.Load(*flagConfig)
+	conf, err := config.Load(*flagConfig)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	logger.SetPrefixLevels(conf.Logging)
 	// register plugins
 	for _, plugin := range desiredPlugins {
 		if err := plugins.RegisterPlugin(plugin); err != nil {
@@ -117,12 +238,37 @@ func main() {
 	}
 
 	// start server
-	srv, err := server.Start(config)
+	srv, err := server.Start(conf)
 	if err != nil {
 		log.Fatal(err)
 	}
+	// SIGHUP reloads the per-prefix log levels from the configuration file,
+	// and the data of the plugins
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			newConf, err := config.Load(*flagConfig)
+			if err != nil {
+				log.Errorf("Failed to reload configuration, keeping the current log levels: %v", err)
+			} else {
+				log.Infof("Reloaded %d per-prefix log levels", len(newConf.Logging))
+				logger.SetPrefixLevels(newConf.Logging)
+			}
+			for _, err := range srv.Reload() {
+				log.Errorf("Failed to reload plugin: %v", err)
+			}
+		}
+	}()
+	// SIGINT and SIGTERM stop the server, closing the plugins
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-stop
+		log.Infof("Received %v, shutting down", sig)
+		srv.Close()
+	}()
 	if err := srv.Wait(); err != nil {
 		log.Print(err)
 	}
-	time.Sleep(time.Second)
 }
@@ -14,6 +14,8 @@ import (
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/mitchellh/mapstructure"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cast"
 	"github.com/spf13/viper"
 )
@@ -32,11 +34,26 @@ type Config struct {
 	v       *viper.Viper
 	Server6 *ServerConfig
 	Server4 *ServerConfig
+	// Logging maps logger prefixes (eg. "plugins/range") to the level of
+	// messages they should log
+	Logging map[string]logrus.Level
+	// Events lists the sinks lease events are sent to
+	Events []EventSinkConfig
+	// Health is the address the HTTP health check endpoint listens on, or
+	// empty if it is disabled
+	Health string
+	// files lists the configuration files read, including the included ones
+	files []string
+	// pluginFiles holds which file the plugins of each server come from
+	pluginFiles map[protocolVersion]string
 }
 
 // New returns a new initialized instance of a Config object
 func New() *Config {
-	return &Config{v: viper.New()}
+	return &Config{
+		v:           viper.New(),
+		pluginFiles: make(map[protocolVersion]string),
+	}
 }
 
 // ServerConfig holds a server configuration that is specific to either the
@@ -49,26 +66,103 @@ type ServerConfig struct {
 // PluginConfig holds the configuration of a plugin
 type PluginConfig struct {
 	Name string
+	// Args are the plugin arguments, as strings. A string value is split on
+	// whitespace, each item of a list is one argument, and a map has no
+	// string arguments.
+	Args []string
+	// Value is the plugin configuration as decoded from the configuration
+	// file: a string, a number, a list or a map. Plugins taking structured
+	// arguments can decode it with Decode.
+	Value interface{}
+	// When is the `when` key of the plugin item, an expression (see the
+	// match package) the request must match for the plugin to run. The
+	// plugin always runs when it is empty.
+	When string
+	// Condition is the `condition` key of the plugin item, if any. Like
+	// When, it restricts the requests the plugin runs for.
+	Condition *Condition
+	// File and Line locate the plugin in the configuration, for error
+	// messages. Line is 0 when unknown.
+	File string
+	Line int
+}
+
+// Condition restricts the requests a plugin runs for, without writing an
+// expression. All the fields that are set must match, each with any of its
+// values, eg:
+//
+//  - nbp: http://10.0.0.1/boot.ipxe
+//    condition:
+//      interface: eth1
+//      giaddr: [10.1.0.0/16, 10.2.0.0/16]
+//      message_type: [DISCOVER, REQUEST]
+//      requested: 67
+type Condition struct {
+	// Interface are names of the interface the request is received on
+	Interface []string `mapstructure:"interface"`
+	// GIAddr are networks, in CIDR notation, the relay address is in
+	GIAddr []string `mapstructure:"giaddr"`
+	// MessageType are types of the request, eg. DISCOVER or SOLICIT
+	MessageType []string `mapstructure:"message_type"`
+	// Requested are codes of options the client requests
+	Requested []int `mapstructure:"requested"`
+}
+
+// IsMap returns whether the plugin was configured with a map, rather than
+// with a string or a list of arguments
+func (pc *PluginConfig) IsMap() bool {
+	_, ok := pc.Value.(map[string]interface{})
+	return ok
+}
+
+// Decode decodes the plugin configuration into out, which must be a pointer to
+// a struct whose fields are matched to the configuration keys by their
+// `mapstructure` tag. Strings are converted to the type of the fields
+// where needed, including to time.Duration, net.IP and net.IPNet. Keys
+// not matching any field are an error, to catch typos.
+func (pc *PluginConfig) Decode(out interface{}) error {
+	if err := decode(pc.Value, out); err != nil {
+		return fmt.Errorf("%s: %w", pc.Name, err)
+	}
+	return nil
+}
+
+// decode decodes a value of the configuration into out, see
+// PluginConfig.Decode
+func decode(in, out interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToIPHookFunc(),
+			mapstructure.StringToIPNetHookFunc(),
+		),
+		ErrorUnused:      true,
+		WeaklyTypedInput: true,
+		Result:           out,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(in)
+}
+
+// EventSinkConfig holds the configuration of a lease event sink
+type EventSinkConfig struct {
+	Type string
 	Args []string
 }
 
 // Load reads a configuration file and returns a Config object, or an error if
-// any.
+// any. The file can be in YAML, JSON or TOML format, chosen by its extension,
+// and include other files (see readFile).
 func Load(pathOverride string) (*Config, error) {
 	log.Print("Loading configuration")
 	c := New()
-	c.v.SetConfigType("yml")
-	if pathOverride != "" {
-		c.v.SetConfigFile(pathOverride)
-	} else {
-		c.v.SetConfigName("config")
-		c.v.AddConfigPath(".")
-		c.v.AddConfigPath("$XDG_CONFIG_HOME/coredhcp/")
-		c.v.AddConfigPath("$HOME/.coredhcp/")
-		c.v.AddConfigPath("/etc/coredhcp/")
+	filename, err := findConfigFile(pathOverride)
+	if err != nil {
+		return nil, err
 	}
-
-	if err := c.v.ReadInConfig(); err != nil {
+	if err := c.readFile(filename, nil); err != nil {
 		return nil, err
 	}
 	if err := c.parseConfig(protocolV6); err != nil {
@@ -80,9 +174,45 @@ func Load(pathOverride string) (*Config, error) {
 	if c.Server6 == nil && c.Server4 == nil {
 		return nil, ConfigErrorFromString("need at least one valid config for DHCPv6 or DHCPv4")
 	}
+	c.setPluginPositions()
+	if err := c.parseLogging(); err != nil {
+		return nil, err
+	}
+	if err := c.parseEvents(); err != nil {
+		return nil, err
+	}
+	if err := c.parseHealth(); err != nil {
+		return nil, err
+	}
 	return c, nil
 }
 
+// parseLogging reads the optional `logging` section, mapping logger prefixes
+// to log levels, eg:
+//
+//  logging:
+//    plugins/range: debug
+//    server: warning
+func (c *Config) parseLogging() error {
+	c.Logging = make(map[string]logrus.Level)
+	section := c.v.Get("logging")
+	if section == nil {
+		return nil
+	}
+	levels, err := cast.ToStringMapStringE(section)
+	if err != nil {
+		return ConfigErrorFromString("logging: not a map of logger prefixes to levels")
+	}
+	for prefix, name := range levels {
+		level, err := logger.ParseLevel(name)
+		if err != nil {
+			return ConfigErrorFromString("logging: %s: %v", prefix, err)
+		}
+		c.Logging[prefix] = level
+	}
+	return nil
+}
+
 func protoVersionCheck(v protocolVersion) error {
 	if v != protocolV6 && v != protocolV4 {
 		return fmt.Errorf("invalid protocol version: %d", v)
@@ -90,33 +220,144 @@ func protoVersionCheck(v protocolVersion) error {
 	return nil
 }
 
+// whenKey is the key of a plugin item holding the guard of the plugin, eg:
+//
+//  - nbp: http://10.0.0.1/boot.ipxe
+//    when: vendor_class startswith "PXEClient"
+const whenKey = "when"
+
+// conditionKey is the key of a plugin item holding its Condition
+const conditionKey = "condition"
+
 func parsePlugins(pluginList []interface{}) ([]PluginConfig, error) {
 	plugins := make([]PluginConfig, 0, len(pluginList))
 	for idx, val := range pluginList {
-		conf := cast.ToStringMap(val)
-		if conf == nil {
+		item := cast.ToStringMap(val)
+		if item == nil {
 			return nil, ConfigErrorFromString("dhcpv6: plugin #%d is not a string map", idx)
 		}
+		// besides the plugin, an item can have `when` and `condition` keys.
+		// Copy the item rather than deleting them from the configuration.
+		conf := make(map[string]interface{}, len(item))
+		var (
+			when string
+			cond *Condition
+		)
+		for k, v := range item {
+			switch k {
+			case whenKey:
+				w, err := cast.ToStringE(v)
+				if err != nil || w == "" {
+					return nil, ConfigErrorFromString("plugin #%d: %s must be a non-empty expression", idx, whenKey)
+				}
+				when = w
+			case conditionKey:
+				cond = &Condition{}
+				if err := decode(v, cond); err != nil {
+					return nil, ConfigErrorFromString("plugin #%d: %s: %v", idx, conditionKey, err)
+				}
+			default:
+				conf[k] = v
+			}
+		}
 		// make sure that only one item is specified, since it's a
 		// map name -> args
 		if len(conf) != 1 {
 			return nil, ConfigErrorFromString("dhcpv6: exactly one plugin per item can be specified")
 		}
-		var (
-			name string
-			args []string
-		)
 		// only one item, as enforced above, so read just that
-		for k, v := range conf {
-			name = k
-			args = strings.Fields(cast.ToString(v))
+		for name, v := range conf {
+			pc, err := parsePluginValue(name, v)
+			if err != nil {
+				return nil, err
+			}
+			pc.When = when
+			pc.Condition = cond
+			plugins = append(plugins, *pc)
 			break
 		}
-		plugins = append(plugins, PluginConfig{Name: name, Args: args})
 	}
 	return plugins, nil
 }
 
+// parsePluginValue builds the configuration of a plugin from its value in the
+// configuration file, which can be a string of whitespace-separated
+// arguments, a list of arguments, or a map of structured arguments.
+// References to environment variables, ${VAR}, are expanded in all of them.
+func parsePluginValue(name string, v interface{}) (*PluginConfig, error) {
+	v, err := expandEnvValue(v)
+	if err != nil {
+		return nil, ConfigErrorFromString("plugin %s: %v", name, err)
+	}
+	pc := PluginConfig{Name: name, Value: v}
+	switch value := v.(type) {
+	case nil:
+	case []interface{}:
+		for _, item := range value {
+			arg, err := cast.ToStringE(item)
+			if err != nil {
+				return nil, ConfigErrorFromString("plugin %s: list arguments must be scalars, got %v", name, item)
+			}
+			pc.Args = append(pc.Args, arg)
+		}
+	case map[interface{}]interface{}, map[string]interface{}:
+		m, err := cast.ToStringMapE(value)
+		if err != nil {
+			return nil, ConfigErrorFromString("plugin %s: %v", name, err)
+		}
+		pc.Value = m
+	default:
+		pc.Args = strings.Fields(cast.ToString(v))
+	}
+	return &pc, nil
+}
+
+// parseEvents reads the optional `events` section, a list of maps matching an
+// event sink type to its arguments, like the plugins sections, eg:
+//
+//  events:
+//    - jsonlines: /var/lib/coredhcp/events.jsonl
+//    - webhook: https://inventory.example.com/dhcp 5 10s
+func (c *Config) parseEvents() error {
+	section := c.v.Get("events")
+	if section == nil {
+		return nil
+	}
+	sinkList, err := cast.ToSliceE(section)
+	if err != nil {
+		return ConfigErrorFromString("events: not a list of event sinks")
+	}
+	for idx, val := range sinkList {
+		conf, err := cast.ToStringMapE(val)
+		if err != nil || len(conf) != 1 {
+			return ConfigErrorFromString("events: sink #%d must be a map with exactly one sink type", idx)
+		}
+		for typ, args := range conf {
+			c.Events = append(c.Events, EventSinkConfig{
+				Type: typ,
+				Args: strings.Fields(cast.ToString(args)),
+			})
+		}
+	}
+	return nil
+}
+
+// parseHealth reads the optional `health` setting, the address of the HTTP
+// health check endpoint, eg:
+//
+//  health: 127.0.0.1:8067
+func (c *Config) parseHealth() error {
+	if !c.v.IsSet("health") {
+		return nil
+	}
+	addr := c.v.GetString("health")
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return ConfigErrorFromString("health: invalid listen address %q: %v", addr, err)
+	}
+	c.Health = addr
+	return nil
+}
+
 // BUG(Natolumin): listen specifications of the form `[ip6]%iface:port` or
 // `[ip6]%iface` are not supported, even though they are the default format of
 // the `ss` utility in linux. Use `[ip6%iface]:port` instead
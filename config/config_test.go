@@ -4,7 +4,13 @@
 
 package config
 
-import "testing"
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
 
 func TestSplitHostPort(t *testing.T) {
 	testcases := []struct {
@@ -50,3 +56,173 @@ func TestSplitHostPort(t *testing.T) {
 		}
 	}
 }
+
+func TestParseLogging(t *testing.T) {
+	c := New()
+	c.v.Set("logging", map[string]interface{}{
+		"plugins/range": "debug",
+		"server":        "warning",
+	})
+	if err := c.parseLogging(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Logging["plugins/range"] != logrus.DebugLevel || c.Logging["server"] != logrus.WarnLevel {
+		t.Errorf("Unexpected levels: %v", c.Logging)
+	}
+
+	c = New()
+	c.v.Set("logging", map[string]interface{}{"server": "loud"})
+	if err := c.parseLogging(); err == nil {
+		t.Error("Invalid log level was accepted")
+	}
+}
+
+func TestParseEvents(t *testing.T) {
+	c := New()
+	c.v.Set("events", []interface{}{
+		map[string]interface{}{"jsonlines": "/tmp/events.jsonl"},
+		map[string]interface{}{"webhook": "http://localhost/hook 5 10s"},
+	})
+	if err := c.parseEvents(); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Events) != 2 {
+		t.Fatalf("Expected 2 sinks, got %d", len(c.Events))
+	}
+	if c.Events[1].Type != "webhook" || len(c.Events[1].Args) != 3 {
+		t.Errorf("Unexpected webhook sink config: %+v", c.Events[1])
+	}
+
+	c = New()
+	c.v.Set("events", []interface{}{"jsonlines"})
+	if err := c.parseEvents(); err == nil {
+		t.Error("Sink without arguments map was accepted")
+	}
+}
+
+func TestParseHealth(t *testing.T) {
+	c := New()
+	c.v.Set("health", "127.0.0.1:8067")
+	if err := c.parseHealth(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Health != "127.0.0.1:8067" {
+		t.Errorf("Unexpected health address: %q", c.Health)
+	}
+
+	c = New()
+	c.v.Set("health", "localhost")
+	if err := c.parseHealth(); err == nil {
+		t.Error("Health address without a port was accepted")
+	}
+}
+
+func TestParsePluginValue(t *testing.T) {
+	pc, err := parsePluginValue("range", "leases.txt 10.0.0.1 10.0.0.100 60s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pc.Args) != 4 || pc.IsMap() {
+		t.Errorf("Unexpected string arguments: %v", pc.Args)
+	}
+
+	pc, err = parsePluginValue("nbp", []interface{}{"http://example.com/nbp file", 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pc.Args) != 2 || pc.Args[0] != "http://example.com/nbp file" || pc.Args[1] != "42" {
+		t.Errorf("Unexpected list arguments: %v", pc.Args)
+	}
+
+	pc, err = parsePluginValue("range", map[string]interface{}{
+		"file":       "leases.txt",
+		"start":      "10.0.0.1",
+		"lease_time": "1m",
+		"options":    []interface{}{1, 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pc.IsMap() || len(pc.Args) != 0 {
+		t.Fatalf("Map arguments not recognized: %+v", pc)
+	}
+	var conf struct {
+		File      string        `mapstructure:"file"`
+		Start     net.IP        `mapstructure:"start"`
+		LeaseTime time.Duration `mapstructure:"lease_time"`
+		Options   []int         `mapstructure:"options"`
+	}
+	if err := pc.Decode(&conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.File != "leases.txt" || !conf.Start.Equal(net.IPv4(10, 0, 0, 1)) || conf.LeaseTime != time.Minute || len(conf.Options) != 2 {
+		t.Errorf("Unexpected decoded configuration: %+v", conf)
+	}
+
+	pc.Value.(map[string]interface{})["typo"] = "x"
+	if err := pc.Decode(&conf); err == nil {
+		t.Error("Unknown key was accepted")
+	}
+}
+
+func TestParsePluginsGuards(t *testing.T) {
+	item := map[string]interface{}{"nbp": "http://10.0.0.1/boot.ipxe", "when": `arch == 7`}
+	plugins, err := parsePlugins([]interface{}{item, map[string]interface{}{"dns": "8.8.8.8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plugins) != 2 || plugins[0].Name != "nbp" || plugins[0].When != "arch == 7" || plugins[1].When != "" {
+		t.Errorf("Unexpected plugins: %+v", plugins)
+	}
+	if _, ok := item["when"]; !ok {
+		t.Error("The configuration was modified")
+	}
+
+	plugins, err = parsePlugins([]interface{}{map[string]interface{}{
+		"nbp":       "http://10.0.0.1/boot.ipxe",
+		"condition": map[string]interface{}{"interface": "eth1", "giaddr": []interface{}{"10.1.0.0/16", "10.2.0.0/16"}, "requested": 67},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cond := plugins[0].Condition
+	if cond == nil || len(cond.Interface) != 1 || len(cond.GIAddr) != 2 || len(cond.Requested) != 1 || cond.Requested[0] != 67 {
+		t.Errorf("Unexpected condition: %+v", cond)
+	}
+
+	for _, item := range []map[string]interface{}{
+		{"when": "arch == 7"},
+		{"nbp": "x", "condition": "eth1"},
+		{"nbp": "x", "condition": map[string]interface{}{"interfaces": "eth1"}},
+		{"nbp": "x", "dns": "y", "when": "arch == 7"},
+		{"nbp": "x", "when": ""},
+	} {
+		if _, err := parsePlugins([]interface{}{item}); err == nil {
+			t.Errorf("Invalid plugin item accepted: %v", item)
+		}
+	}
+}
+
+func TestPluginLines(t *testing.T) {
+	data := []byte(`
+server6:
+  plugins:
+    - server_id: LL 00:de:ad:be:ef:00
+Server4:
+  listen: ["%eth0"]
+  plugins:
+    - server_id: 10.10.10.1
+
+    - range:
+        file: leases.txt
+`)
+	if lines := pluginLines(data, protocolV6); len(lines) != 1 || lines[0] != 4 {
+		t.Errorf("Unexpected DHCPv6 plugin lines: %v", lines)
+	}
+	if lines := pluginLines(data, protocolV4); len(lines) != 2 || lines[0] != 8 || lines[1] != 10 {
+		t.Errorf("Unexpected DHCPv4 plugin lines: %v", lines)
+	}
+	if lines := pluginLines([]byte("{"), protocolV4); lines != nil {
+		t.Errorf("Got lines from an invalid document: %v", lines)
+	}
+}
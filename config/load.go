@@ -0,0 +1,210 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// maxIncludeDepth bounds the nesting of includes
+const maxIncludeDepth = 8
+
+// configTypes maps the extensions of the supported configuration files to
+// their format. Files with any other extension are read as YAML.
+var configTypes = map[string]string{
+	".yml":  "yaml",
+	".yaml": "yaml",
+	".json": "json",
+	".toml": "toml",
+}
+
+func configType(filename string) string {
+	if typ, ok := configTypes[strings.ToLower(filepath.Ext(filename))]; ok {
+		return typ
+	}
+	return "yaml"
+}
+
+// findConfigFile returns the configuration file to read: pathOverride if
+// set, otherwise the first config.{yml,yaml,json,toml} found in the default
+// locations
+func findConfigFile(pathOverride string) (string, error) {
+	if pathOverride != "" {
+		return pathOverride, nil
+	}
+	v := viper.New()
+	v.SetConfigName("config")
+	v.AddConfigPath(".")
+	v.AddConfigPath("$XDG_CONFIG_HOME/coredhcp/")
+	v.AddConfigPath("$HOME/.coredhcp/")
+	v.AddConfigPath("/etc/coredhcp/")
+	if err := v.ReadInConfig(); err != nil {
+		return "", err
+	}
+	return v.ConfigFileUsed(), nil
+}
+
+// readFile reads a configuration file and the files it includes into the
+// configuration. The files listed in the `include` section (a path or a
+// list of paths and globs, relative to the including file) are merged in
+// order, then the including file is merged over them: its values take
+// precedence. Maps are merged key by key, while lists, like the plugins of a
+// server, are replaced as a whole.
+// stack holds the files including this one, to detect include loops.
+func (c *Config) readFile(filename string, stack []string) error {
+	if len(stack) >= maxIncludeDepth {
+		return ConfigErrorFromString("%s: includes nested too deeply", filename)
+	}
+	for _, f := range stack {
+		if f == filename {
+			return ConfigErrorFromString("%s: include loop: %s", filename, strings.Join(append(stack, filename), " -> "))
+		}
+	}
+	v := viper.New()
+	v.SetConfigFile(filename)
+	v.SetConfigType(configType(filename))
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+	c.files = append(c.files, filename)
+
+	if include := v.Get("include"); include != nil {
+		patterns, err := cast.ToStringSliceE(include)
+		if err != nil {
+			return ConfigErrorFromString("%s: include: not a path or a list of paths", filename)
+		}
+		dir := filepath.Dir(filename)
+		for _, pattern := range patterns {
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(dir, pattern)
+			}
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return ConfigErrorFromString("%s: include: %v", filename, err)
+			}
+			if len(matches) == 0 && !hasGlobMeta(pattern) {
+				return ConfigErrorFromString("%s: include: %s not found", filename, pattern)
+			}
+			for _, match := range matches {
+				if err := c.readFile(match, append(stack, filename)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	settings := v.AllSettings()
+	delete(settings, "include")
+	for _, ver := range []protocolVersion{protocolV6, protocolV4} {
+		if v.IsSet(fmt.Sprintf("server%d.plugins", ver)) {
+			c.pluginFiles[ver] = filename
+		}
+	}
+	return c.v.MergeConfigMap(settings)
+}
+
+func hasGlobMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+// envRegexp matches ${VAR} and ${VAR:-default}
+var envRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv substitutes ${VAR} with the value of the VAR environment
+// variable. ${VAR:-default} is replaced with default if VAR is unset or
+// empty. Referencing an unset variable without a default is an error, so that
+// a missing variable doesn't silently produce a broken argument.
+func expandEnv(s string) (string, error) {
+	var err error
+	expanded := envRegexp.ReplaceAllStringFunc(s, func(ref string) string {
+		m := envRegexp.FindStringSubmatch(ref)
+		if val := os.Getenv(m[1]); val != "" {
+			return val
+		}
+		if m[2] != "" {
+			return m[3]
+		}
+		if _, ok := os.LookupEnv(m[1]); !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", m[1])
+		}
+		return ""
+	})
+	return expanded, err
+}
+
+// expandEnvValue applies expandEnv to all the strings of a configuration
+// value
+func expandEnvValue(v interface{}) (interface{}, error) {
+	switch value := v.(type) {
+	case string:
+		return expandEnv(value)
+	case []interface{}:
+		ret := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			expanded, err := expandEnvValue(item)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, expanded)
+		}
+		return ret, nil
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			expanded, err := expandEnvValue(item)
+			if err != nil {
+				return nil, err
+			}
+			ret[k] = expanded
+		}
+		return ret, nil
+	}
+	return v, nil
+}
+
+// Dump returns the configuration, merged from all the included files and with
+// the environment variables of the plugin arguments expanded, in the given
+// format: "yaml" or "json". This is meant for debugging.
+func (c *Config) Dump(format string) ([]byte, error) {
+	settings := c.v.AllSettings()
+	for _, sc := range []struct {
+		key  string
+		conf *ServerConfig
+	}{{"server6", c.Server6}, {"server4", c.Server4}} {
+		if sc.conf == nil {
+			continue
+		}
+		server, ok := settings[sc.key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		plugins := make([]interface{}, 0, len(sc.conf.Plugins))
+		for _, pc := range sc.conf.Plugins {
+			plugins = append(plugins, map[string]interface{}{pc.Name: pc.Value})
+		}
+		server["plugins"] = plugins
+	}
+	switch format {
+	case "yaml", "yml":
+		return yaml.Marshal(settings)
+	case "json":
+		return json.MarshalIndent(settings, "", "  ")
+	}
+	return nil, fmt.Errorf("unsupported dump format '%s', want yaml or json", format)
+}
+
+// Files returns the configuration files that were read, in order
+func (c *Config) Files() []string {
+	return c.files
+}
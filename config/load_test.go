@@ -0,0 +1,146 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestLoadIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.Setenv("COREDHCP_TEST_ROUTER", "10.0.0.1"))
+	defer os.Unsetenv("COREDHCP_TEST_ROUTER")
+
+	writeFiles(t, dir, map[string]string{
+		"config.yml": `
+include:
+  - site.d/*.yml
+  - v6.json
+logging:
+  server: warning
+server4:
+  plugins:
+    - server_id: 10.0.0.1
+    - router: ${COREDHCP_TEST_ROUTER}
+    - dns: ${COREDHCP_TEST_UNSET:-8.8.8.8}
+`,
+		"site.d/10-listen.yml": `
+server4:
+  listen: ["%eth0"]
+logging:
+  server: debug
+  plugins: info
+`,
+		"site.d/20-ignored.yml": `
+server4:
+  plugins:
+    - router: 192.0.2.1
+`,
+		"v6.json": `{"server6": {"plugins": [{"server_id": "LL 00:de:ad:be:ef:00"}]}}`,
+	})
+
+	conf, err := Load(filepath.Join(dir, "config.yml"))
+	require.NoError(t, err)
+	assert.Len(t, conf.Files(), 4)
+
+	// plugins come from the including file, which overrides the includes
+	require.NotNil(t, conf.Server4)
+	require.Len(t, conf.Server4.Plugins, 3)
+	assert.Equal(t, []string{"10.0.0.1"}, conf.Server4.Plugins[1].Args)
+	assert.Equal(t, []string{"8.8.8.8"}, conf.Server4.Plugins[2].Args)
+	assert.Equal(t, filepath.Join(dir, "config.yml"), conf.Server4.Plugins[1].File)
+	assert.Equal(t, 10, conf.Server4.Plugins[1].Line)
+	// while maps are merged
+	assert.Equal(t, "eth0", conf.Server4.Addresses[0].Zone)
+	assert.Len(t, conf.Logging, 2)
+
+	require.NotNil(t, conf.Server6)
+	assert.Equal(t, filepath.Join(dir, "v6.json"), conf.Server6.Plugins[0].File)
+	assert.Equal(t, 1, conf.Server6.Plugins[0].Line)
+
+	dump, err := conf.Dump("json")
+	require.NoError(t, err)
+	var dumped map[string]interface{}
+	require.NoError(t, json.Unmarshal(dump, &dumped))
+	assert.NotContains(t, dumped, "include")
+	assert.Contains(t, string(dump), `"router": "10.0.0.1"`)
+	_, err = conf.Dump("yaml")
+	assert.NoError(t, err)
+}
+
+func TestLoadTOML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"config.toml": `
+[server4]
+plugins = [
+  { server_id = "10.0.0.1" },
+  { range = { file = "leases.txt", start = "10.0.0.10", end = "10.0.0.20", lease_time = "1h" } },
+]
+`,
+	})
+	conf, err := Load(filepath.Join(dir, "config.toml"))
+	require.NoError(t, err)
+	require.Len(t, conf.Server4.Plugins, 2)
+	assert.True(t, conf.Server4.Plugins[1].IsMap())
+	assert.Equal(t, 0, conf.Server4.Plugins[1].Line)
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"loop.yml":    "include: loop2.yml\nserver4: {plugins: [{server_id: 10.0.0.1}]}\n",
+		"loop2.yml":   "include: loop.yml\n",
+		"missing.yml": "include: nothere.yml\nserver4: {plugins: [{server_id: 10.0.0.1}]}\n",
+		"env.yml":     "server4: {plugins: [{router: '${COREDHCP_TEST_UNSET}'}]}\n",
+		"noglob.yml":  "include: conf.d/*.yml\nserver4: {plugins: [{server_id: 10.0.0.1}]}\n",
+	})
+	for _, name := range []string{"loop.yml", "missing.yml", "env.yml"} {
+		_, err := Load(filepath.Join(dir, name))
+		assert.Error(t, err, name)
+	}
+	// globs may match nothing
+	_, err = Load(filepath.Join(dir, "noglob.yml"))
+	assert.NoError(t, err)
+}
+
+func TestExpandEnv(t *testing.T) {
+	require.NoError(t, os.Setenv("COREDHCP_TEST_VAR", "value"))
+	defer os.Unsetenv("COREDHCP_TEST_VAR")
+	for in, out := range map[string]string{
+		"${COREDHCP_TEST_VAR}":              "value",
+		"a-${COREDHCP_TEST_VAR}-b":          "a-value-b",
+		"${COREDHCP_TEST_UNSET:-default}":   "default",
+		"${COREDHCP_TEST_VAR:-default}":     "value",
+		"$COREDHCP_TEST_VAR stays as it is": "$COREDHCP_TEST_VAR stays as it is",
+	} {
+		expanded, err := expandEnv(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, out, expanded, in)
+	}
+	_, err := expandEnv("${COREDHCP_TEST_UNSET}")
+	assert.Error(t, err)
+}
@@ -0,0 +1,82 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Position returns the location of the plugin in the configuration file, as
+// "file:line", or an empty string if it is unknown
+func (pc *PluginConfig) Position() string {
+	if pc.File == "" || pc.Line == 0 {
+		return pc.File
+	}
+	return fmt.Sprintf("%s:%d", pc.File, pc.Line)
+}
+
+// setPluginPositions records in which file and on which line every plugin
+// was configured, so that errors can point to them. Viper doesn't keep track
+// of positions, so the file is parsed again. This is best-effort: positions
+// are left unset if the file can't be parsed that way. JSON files are valid
+// YAML, but TOML files aren't, so plugins configured in TOML have no line.
+func (c *Config) setPluginPositions() {
+	for _, sc := range []struct {
+		ver  protocolVersion
+		conf *ServerConfig
+	}{{protocolV6, c.Server6}, {protocolV4, c.Server4}} {
+		filename := c.pluginFiles[sc.ver]
+		if sc.conf == nil || filename == "" {
+			continue
+		}
+		var lines []int
+		if data, err := ioutil.ReadFile(filename); err == nil && configType(filename) != "toml" {
+			lines = pluginLines(data, sc.ver)
+		}
+		for i := range sc.conf.Plugins {
+			sc.conf.Plugins[i].File = filename
+			if i < len(lines) {
+				sc.conf.Plugins[i].Line = lines[i]
+			}
+		}
+	}
+}
+
+// pluginLines returns the line of every item of the plugins list of a server
+// section in a YAML document
+func pluginLines(data []byte, ver protocolVersion) []int {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+		return nil
+	}
+	server := mappingValue(doc.Content[0], fmt.Sprintf("server%d", ver))
+	plugins := mappingValue(server, "plugins")
+	if plugins == nil || plugins.Kind != yaml.SequenceNode {
+		return nil
+	}
+	lines := make([]int, 0, len(plugins.Content))
+	for _, item := range plugins.Content {
+		lines = append(lines, item.Line)
+	}
+	return lines
+}
+
+// mappingValue returns the value of a key of a YAML mapping, which viper
+// matches case-insensitively
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if strings.EqualFold(node.Content[i].Value, key) {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
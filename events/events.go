@@ -0,0 +1,235 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package events implements a bus carrying lease events (a lease being
+// granted, renewed, released, declined or expiring) from the plugins managing leases to
+// any number of sinks, such as a webhook or a JSON-lines file.
+//
+// Plugins publish events with Publish. Delivery to the sinks is asynchronous:
+// each sink has its own queue, so that a slow sink doesn't delay the handling
+// of DHCP requests nor the other sinks. When a sink's queue is full, new
+// events are dropped for that sink.
+package events
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var log = logger.GetLogger("events")
+
+// Type is the type of a lease event
+type Type string
+
+// Lease event types
+const (
+	// Granted is published when a client obtains a lease
+	Granted Type = "granted"
+	// Renewed is published when a client extends a lease it already had
+	Renewed Type = "renewed"
+	// Released is published when a client gives a lease back
+	Released Type = "released"
+	// Declined is published when a client reports that the address of its
+	// lease is already in use, and the address is quarantined
+	Declined Type = "declined"
+	// Expired is published when a lease is reclaimed after it expired
+	Expired Type = "expired"
+)
+
+// Event describes a change of state of a lease
+type Event struct {
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+	// Plugin is the name of the plugin that published the event
+	Plugin string `json:"plugin"`
+	// MAC is the client hardware address, if known
+	MAC string `json:"mac,omitempty"`
+	// DUID is the DHCPv6 client identifier, for DHCPv6 leases
+	DUID string `json:"duid,omitempty"`
+	// IP is the leased address, for address leases
+	IP net.IP `json:"ip,omitempty"`
+	// Prefix is the leased prefix, for delegated prefixes
+	Prefix string `json:"prefix,omitempty"`
+	// Hostname is the host name sent by the client, if any
+	Hostname string `json:"hostname,omitempty"`
+	// Interface is the name of the interface the request was received on
+	Interface string `json:"interface,omitempty"`
+	// Expiry is the time when the lease expires. It is zero for leases
+	// without expiry, eg. static leases
+	Expiry time.Time `json:"expiry,omitempty"`
+}
+
+// event has the fields of Event, without its MarshalJSON method
+type event Event
+
+// MarshalJSON encodes an event, leaving out the expiry of leases without one
+// (omitempty has no effect on a time.Time)
+func (ev Event) MarshalJSON() ([]byte, error) {
+	var expiry *time.Time
+	if !ev.Expiry.IsZero() {
+		expiry = &ev.Expiry
+	}
+	return json.Marshal(struct {
+		event
+		Expiry *time.Time `json:"expiry,omitempty"`
+	}{event(ev), expiry})
+}
+
+// Sink is a destination for events
+type Sink interface {
+	// Send delivers an event. It is called from a single goroutine per sink,
+	// so implementations don't need to be safe for concurrent use.
+	Send(ev *Event) error
+	// Close releases the resources held by the sink. No Send call happens
+	// after Close.
+	Close() error
+}
+
+// SinkFunc adapts a function to the Sink interface, with a no-op Close
+type SinkFunc func(ev *Event) error
+
+// Send calls f(ev)
+func (f SinkFunc) Send(ev *Event) error {
+	return f(ev)
+}
+
+// Close does nothing
+func (f SinkFunc) Close() error {
+	return nil
+}
+
+// QueueSize is the number of events that can be waiting for delivery to a
+// sink before new events get dropped
+const QueueSize = 1024
+
+type subscription struct {
+	name  string
+	sink  Sink
+	queue chan *Event
+	done  chan struct{}
+}
+
+func (s *subscription) run() {
+	defer close(s.done)
+	for ev := range s.queue {
+		if err := s.sink.Send(ev); err != nil {
+			log.Warningf("sink %s: failed to deliver %s event: %v", s.name, ev.Type, err)
+		}
+	}
+	if err := s.sink.Close(); err != nil {
+		log.Warningf("sink %s: failed to close: %v", s.name, err)
+	}
+}
+
+// Bus dispatches published events to all the subscribed sinks
+type Bus struct {
+	sync.RWMutex
+	subs []*subscription
+}
+
+// NewBus returns a bus without any sink
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe adds a sink to the bus. name is only used in logs.
+func (b *Bus) Subscribe(name string, sink Sink) {
+	sub := &subscription{
+		name:  name,
+		sink:  sink,
+		queue: make(chan *Event, QueueSize),
+		done:  make(chan struct{}),
+	}
+	go sub.run()
+	b.Lock()
+	b.subs = append(b.subs, sub)
+	b.Unlock()
+}
+
+// Publish queues an event for delivery to all the sinks. It never blocks.
+func (b *Bus) Publish(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	b.RLock()
+	defer b.RUnlock()
+	for _, sub := range b.subs {
+		// each sink gets its own copy, so they can't interfere
+		evCopy := ev
+		select {
+		case sub.queue <- &evCopy:
+		default:
+			log.Warningf("sink %s: queue full, dropping %s event", sub.name, ev.Type)
+		}
+	}
+}
+
+// Close unsubscribes all the sinks, and waits until they have delivered the
+// queued events and are closed.
+func (b *Bus) Close() {
+	b.Lock()
+	subs := b.subs
+	b.subs = nil
+	b.Unlock()
+	for _, sub := range subs {
+		close(sub.queue)
+	}
+	for _, sub := range subs {
+		<-sub.done
+	}
+}
+
+// defaultBus is the bus plugins publish to
+var defaultBus = NewBus()
+
+// Publish queues an event for delivery on the default bus
+func Publish(ev Event) {
+	defaultBus.Publish(ev)
+}
+
+// Subscribe adds a sink to the default bus
+func Subscribe(name string, sink Sink) {
+	defaultBus.Subscribe(name, sink)
+}
+
+// Close closes all the sinks of the default bus
+func Close() {
+	defaultBus.Close()
+}
+
+// TypeFromRequest4 returns the type of event corresponding to a DHCPv4
+// request being acknowledged: clients renewing or rebinding a lease set
+// ciaddr, while clients obtaining a new lease don't.
+func TypeFromRequest4(req *dhcpv4.DHCPv4) Type {
+	if req.ClientIPAddr != nil && !req.ClientIPAddr.IsUnspecified() {
+		return Renewed
+	}
+	return Granted
+}
+
+// TypeFromMessage6 returns the type of event corresponding to a DHCPv6
+// message being answered with a Reply, and false if no lease event
+// corresponds to that message (eg. a Solicit only gets an Advertise).
+func TypeFromMessage6(msg *dhcpv6.Message) (Type, bool) {
+	switch msg.MessageType {
+	case dhcpv6.MessageTypeSolicit:
+		// only solicits with rapid commit get a lease
+		return Granted, msg.GetOneOption(dhcpv6.OptionRapidCommit) != nil
+	case dhcpv6.MessageTypeRequest:
+		return Granted, true
+	case dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRebind:
+		return Renewed, true
+	case dhcpv6.MessageTypeRelease:
+		return Released, true
+	case dhcpv6.MessageTypeDecline:
+		return Declined, true
+	}
+	return "", false
+}
@@ -0,0 +1,188 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testEvent = Event{
+	Type:      Granted,
+	Plugin:    "range",
+	MAC:       "00:11:22:33:44:55",
+	IP:        net.IPv4(192, 0, 2, 10),
+	Hostname:  "host",
+	Interface: "eth0",
+	Expiry:    time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+}
+
+func TestBusDelivery(t *testing.T) {
+	bus := NewBus()
+	received := make(chan *Event, 2)
+	bus.Subscribe("a", SinkFunc(func(ev *Event) error {
+		received <- ev
+		return nil
+	}))
+	bus.Subscribe("b", SinkFunc(func(ev *Event) error {
+		received <- ev
+		return nil
+	}))
+	bus.Publish(testEvent)
+	bus.Close()
+
+	require.Len(t, received, 2)
+	ev1, ev2 := <-received, <-received
+	assert.Equal(t, "00:11:22:33:44:55", ev1.MAC)
+	assert.False(t, ev1.Time.IsZero(), "publish time should be set")
+	assert.True(t, ev1 != ev2, "sinks should get their own copy of the event")
+
+	// publishing after close is a no-op
+	bus.Publish(testEvent)
+}
+
+func TestJSONLinesSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "events.jsonl")
+
+	sink, err := NewSink("jsonlines", filename)
+	require.NoError(t, err)
+	ev := testEvent
+	require.NoError(t, sink.Send(&ev))
+	ev.Type = Renewed
+	require.NoError(t, sink.Send(&ev))
+	require.NoError(t, sink.Close())
+
+	data, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	var decoded Event
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &decoded))
+	assert.Equal(t, Renewed, decoded.Type)
+	assert.True(t, decoded.IP.Equal(testEvent.IP))
+	assert.True(t, decoded.Expiry.Equal(testEvent.Expiry))
+}
+
+func TestJSONLinesSinkStaticLease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "events.jsonl")
+
+	sink, err := NewSink("jsonlines", filename)
+	require.NoError(t, err)
+	// static leases, eg. of the file plugin, have no expiry
+	ev := testEvent
+	ev.Plugin = "file"
+	ev.Expiry = time.Time{}
+	require.NoError(t, sink.Send(&ev))
+	require.NoError(t, sink.Close())
+
+	data, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &fields))
+	assert.NotContains(t, fields, "expiry")
+	assert.Equal(t, "file", fields["plugin"])
+	assert.Equal(t, "00:11:22:33:44:55", fields["mac"])
+}
+
+func TestWebhookRetries(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var ev Event
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil || ev.MAC != testEvent.MAC {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	sink, err := NewWebhookSink(srv.URL, 2, time.Second)
+	require.NoError(t, err)
+	sink.Backoff = time.Millisecond
+	ev := testEvent
+	assert.NoError(t, sink.Send(&ev))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	atomic.StoreInt32(&calls, 0)
+	sink.Retries = 1
+	assert.Error(t, sink.Send(&ev), "should give up after the configured retries")
+
+	_, err = NewSink("webhook", "ftp://example.com")
+	assert.Error(t, err)
+}
+
+func TestSocketSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.sock")
+
+	sink, err := NewSocketSink(path)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	defer conn.Close()
+	// wait for the connection to be accepted
+	require.Eventually(t, func() bool {
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		return len(sink.conns) == 1
+	}, time.Second, time.Millisecond)
+
+	ev := testEvent
+	require.NoError(t, sink.Send(&ev))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	var decoded Event
+	require.NoError(t, json.Unmarshal([]byte(line), &decoded))
+	assert.Equal(t, testEvent.Hostname, decoded.Hostname)
+}
+
+func TestTypeFromRequest(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0, 1, 2, 3, 4, 5})
+	require.NoError(t, err)
+	assert.Equal(t, Granted, TypeFromRequest4(req))
+	req.ClientIPAddr = net.IPv4(192, 0, 2, 1)
+	assert.Equal(t, Renewed, TypeFromRequest4(req))
+
+	msg, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	msg.MessageType = dhcpv6.MessageTypeSolicit
+	_, ok := TypeFromMessage6(msg)
+	assert.False(t, ok)
+	msg.MessageType = dhcpv6.MessageTypeRebind
+	typ, ok := TypeFromMessage6(msg)
+	assert.True(t, ok)
+	assert.Equal(t, Renewed, typ)
+	msg.MessageType = dhcpv6.MessageTypeDecline
+	typ, ok = TypeFromMessage6(msg)
+	assert.True(t, ok)
+	assert.Equal(t, Declined, typ)
+}
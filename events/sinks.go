@@ -0,0 +1,74 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// SinkFactory creates a sink from its configuration arguments
+type SinkFactory func(args ...string) (Sink, error)
+
+// SinkTypes maps the sink types that can be used in the `events` section of
+// the configuration to their factory
+var SinkTypes = map[string]SinkFactory{
+	"webhook":   newWebhookSinkFromArgs,
+	"jsonlines": newJSONLinesSinkFromArgs,
+	"socket":    newSocketSinkFromArgs,
+}
+
+// NewSink creates a sink of the given type
+func NewSink(typ string, args ...string) (Sink, error) {
+	factory, ok := SinkTypes[typ]
+	if !ok {
+		types := make([]string, 0, len(SinkTypes))
+		for t := range SinkTypes {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		return nil, fmt.Errorf("unknown event sink type '%s', valid types are %v", typ, types)
+	}
+	return factory(args...)
+}
+
+// JSONLinesSink appends every event to a file, as one JSON object per line
+type JSONLinesSink struct {
+	f *os.File
+}
+
+// NewJSONLinesSink opens (or creates) the given file for appending events
+func NewJSONLinesSink(filename string) (*JSONLinesSink, error) {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open event file %s: %w", filename, err)
+	}
+	return &JSONLinesSink{f: f}, nil
+}
+
+func newJSONLinesSinkFromArgs(args ...string) (Sink, error) {
+	if len(args) != 1 {
+		return nil, errors.New("jsonlines: want exactly one argument, the file name")
+	}
+	return NewJSONLinesSink(args[0])
+}
+
+// Send appends ev to the file
+func (s *JSONLinesSink) Send(ev *Event) error {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = s.f.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file
+func (s *JSONLinesSink) Close() error {
+	return s.f.Close()
+}
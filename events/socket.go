@@ -0,0 +1,100 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// socketWriteTimeout bounds how long a slow client can hold up the delivery of
+// an event; clients that don't keep up are disconnected
+const socketWriteTimeout = time.Second
+
+// SocketSink listens on a unix stream socket, and streams every event as a
+// JSON line to all the connected clients. Clients only receive the events
+// published while they are connected.
+type SocketSink struct {
+	listener net.Listener
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+}
+
+// NewSocketSink listens on the unix socket at the given path, replacing any
+// stale socket file
+func NewSocketSink(path string) (*SocketSink, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("socket: cannot remove stale socket %s: %w", path, err)
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("socket: cannot listen on %s: %w", path, err)
+	}
+	s := &SocketSink{
+		listener: l,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	go s.accept()
+	return s, nil
+}
+
+func newSocketSinkFromArgs(args ...string) (Sink, error) {
+	if len(args) != 1 {
+		return nil, errors.New("socket: want exactly one argument, the socket path")
+	}
+	return NewSocketSink(args[0])
+}
+
+func (s *SocketSink) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			// the listener was closed
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+	}
+}
+
+// Send writes ev to all the connected clients
+func (s *SocketSink) Send(ev *Event) error {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		_ = conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+		if _, err := conn.Write(line); err != nil {
+			log.Debugf("socket: dropping client: %v", err)
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+	return nil
+}
+
+// Close stops listening and disconnects all the clients
+func (s *SocketSink) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+	return err
+}
@@ -0,0 +1,111 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Default settings of the webhook sink
+const (
+	DefaultWebhookRetries = 3
+	DefaultWebhookTimeout = 5 * time.Second
+)
+
+// WebhookSink POSTs every event, as JSON, to a URL. Deliveries failing with a
+// network error or a non-2xx status are retried with an exponential backoff.
+type WebhookSink struct {
+	URL     string
+	Retries int
+	// Backoff is the delay before the first retry, doubled for each
+	// subsequent retry
+	Backoff time.Duration
+	client  *http.Client
+}
+
+// NewWebhookSink returns a sink posting events to the given URL
+func NewWebhookSink(u string, retries int, timeout time.Duration) (*WebhookSink, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, fmt.Errorf("webhook: invalid URL: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("webhook: unsupported URL scheme '%s'", parsed.Scheme)
+	}
+	return &WebhookSink{
+		URL:     u,
+		Retries: retries,
+		Backoff: time.Second,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// newWebhookSinkFromArgs parses: <URL> [<retries> [<timeout>]]
+func newWebhookSinkFromArgs(args ...string) (Sink, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return nil, errors.New("webhook: want arguments: <URL> [<retries> [<timeout>]]")
+	}
+	retries := DefaultWebhookRetries
+	timeout := DefaultWebhookTimeout
+	var err error
+	if len(args) > 1 {
+		if retries, err = strconv.Atoi(args[1]); err != nil || retries < 0 {
+			return nil, fmt.Errorf("webhook: invalid number of retries: %s", args[1])
+		}
+	}
+	if len(args) > 2 {
+		if timeout, err = time.ParseDuration(args[2]); err != nil {
+			return nil, fmt.Errorf("webhook: invalid timeout: %s", args[2])
+		}
+	}
+	return NewWebhookSink(args[0], retries, timeout)
+}
+
+func (s *WebhookSink) post(body []byte) error {
+	resp, err := s.client.Post(s.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+	return nil
+}
+
+// Send posts ev, retrying on failure
+func (s *WebhookSink) Send(ev *Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	backoff := s.Backoff
+	for attempt := 0; ; attempt++ {
+		err = s.post(body)
+		if err == nil || attempt >= s.Retries {
+			return err
+		}
+		log.Debugf("webhook: delivery to %s failed, retrying in %s: %v", s.URL, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Close closes idle connections to the webhook server
+func (s *WebhookSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -13,9 +13,9 @@ require (
 	github.com/mattn/go-isatty v0.0.19 // indirect
 	github.com/mgutz/ansi v0.0.0-20200706080929-d51e80ef957d // indirect
 	github.com/milosgajdos/tenus v0.0.3
+	github.com/mitchellh/mapstructure v1.5.0
 	github.com/onsi/ginkgo v1.14.0 // indirect
 	github.com/pierrec/lz4/v4 v4.1.18 // indirect
-	github.com/rifflock/lfshook v0.0.0-20180920164130-b9218ef580f5
 	github.com/sirupsen/logrus v1.9.3
 	github.com/spf13/cast v1.5.1
 	github.com/spf13/pflag v1.0.6-0.20201009195203-85dd5c8bc61c
@@ -24,5 +24,8 @@ require (
 	github.com/u-root/uio v0.0.0-20230305220412-3e8cd9d6bf63 // indirect
 	github.com/vishvananda/netns v0.0.0-20210104183010-2eb08e3e575f
 	github.com/x-cray/logrus-prefixed-formatter v0.5.2 // indirect
+	go.etcd.io/bbolt v1.3.6
+	go.starlark.net v0.0.0-20200306205701-8dd3e2ee1dd5
 	golang.org/x/net v0.11.0
+	gopkg.in/yaml.v3 v3.0.1
 )
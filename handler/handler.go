@@ -9,8 +9,29 @@ import (
 	"github.com/insomniacslk/dhcp/dhcpv6"
 )
 
+// PropagateState is passed along the handlers of a request
 type PropagateState struct {
 	InterfaceName string
+	// Classes are the names of the classes the request belongs to, as
+	// assigned by the class plugins
+	Classes []string
+}
+
+// AddClass adds the request to a class
+func (s *PropagateState) AddClass(name string) {
+	if !s.HasClass(name) {
+		s.Classes = append(s.Classes, name)
+	}
+}
+
+// HasClass returns whether the request belongs to a class
+func (s *PropagateState) HasClass(name string) bool {
+	for _, c := range s.Classes {
+		if c == name {
+			return true
+		}
+	}
+	return false
 }
 
 // Handler6 is a function that is called on a given DHCPv6 packet.
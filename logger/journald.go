@@ -0,0 +1,135 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build linux
+
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultJournaldSocket is where systemd-journald listens for messages in
+// its native protocol
+const DefaultJournaldSocket = "/run/systemd/journal/socket"
+
+// journaldHook sends messages to systemd-journald using its native protocol,
+// see https://systemd.io/JOURNAL_NATIVE_PROTOCOL/ . Logrus fields are sent as
+// journal fields, so they can be matched on with journalctl, eg.
+// `journalctl MAC=00:11:22:33:44:55`.
+//
+// Messages are sent as a single datagram, so they are limited in size by the
+// socket buffer size; passing larger messages through a memfd isn't supported.
+type journaldHook struct {
+	sync.Mutex
+	conn *net.UnixConn
+	addr *net.UnixAddr
+}
+
+// WithJournald sends all messages to systemd-journald, listening on the given
+// socket (DefaultJournaldSocket if empty). It fails if journald doesn't
+// listen on the socket.
+func WithJournald(log *logrus.Entry, socket string) error {
+	if socket == "" {
+		socket = DefaultJournaldSocket
+	}
+	addr := &net.UnixAddr{Name: socket, Net: "unixgram"}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("cannot create journald socket: %w", err)
+	}
+	// journald ignores empty datagrams, sending one only checks that it
+	// listens
+	if _, err := conn.WriteToUnix(nil, addr); err != nil {
+		conn.Close()
+		return fmt.Errorf("cannot connect to journald: %w", err)
+	}
+	addHook(&journaldHook{conn: conn, addr: addr})
+	return nil
+}
+
+func (h *journaldHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *journaldHook) Fire(entry *logrus.Entry) error {
+	msg := journaldMessage(entry)
+	h.Lock()
+	defer h.Unlock()
+	_, err := h.conn.WriteToUnix(msg, h.addr)
+	return err
+}
+
+// journaldPriority maps logrus levels to syslog priorities
+func journaldPriority(level logrus.Level) int {
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return 2
+	case logrus.ErrorLevel:
+		return 3
+	case logrus.WarnLevel:
+		return 4
+	case logrus.InfoLevel:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// journaldFieldName converts a logrus field name to a valid journal field
+// name: uppercase ASCII letters, digits and underscores, not starting with an
+// underscore (those are reserved for trusted fields)
+func journaldFieldName(name string) string {
+	b := []byte(strings.ToUpper(name))
+	for i, c := range b {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return strings.TrimLeft(string(b), "_")
+}
+
+func journaldAppendField(buf *bytes.Buffer, name, value string) {
+	if name == "" {
+		return
+	}
+	buf.WriteString(name)
+	if !strings.ContainsRune(value, '\n') {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	// Values containing newlines need to be sent with an explicit size
+	buf.WriteByte('\n')
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	buf.Write(size[:])
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+func journaldMessage(entry *logrus.Entry) []byte {
+	var buf bytes.Buffer
+	journaldAppendField(&buf, "MESSAGE", entry.Message)
+	journaldAppendField(&buf, "PRIORITY", fmt.Sprint(journaldPriority(entry.Level)))
+	journaldAppendField(&buf, "SYSLOG_IDENTIFIER", DefaultSyslogTag)
+	for k, v := range entry.Data {
+		name := journaldFieldName(k)
+		switch name {
+		case "MESSAGE", "PRIORITY", "SYSLOG_IDENTIFIER":
+			// don't let fields override the ones set above
+			continue
+		}
+		journaldAppendField(&buf, name, fmt.Sprint(v))
+	}
+	return buf.Bytes()
+}
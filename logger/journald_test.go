@@ -0,0 +1,54 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build linux
+
+package logger
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournaldMessage(t *testing.T) {
+	entry := testEntry()
+	entry.Message = "two\nlines"
+	entry.Level = logrus.WarnLevel
+	entry.Data["priority"] = "not allowed to override"
+
+	msg := string(journaldMessage(entry))
+	assert.Contains(t, msg, "MESSAGE\n\x09\x00\x00\x00\x00\x00\x00\x00two\nlines\n")
+	assert.Contains(t, msg, "PRIORITY=4\n")
+	assert.Contains(t, msg, "MAC=00:11:22:33:44:55\n")
+	assert.Contains(t, msg, "PREFIX=plugins/test\n")
+	assert.Equal(t, 1, strings.Count(msg, "PRIORITY"))
+
+	assert.Equal(t, "CLIENT_ID", journaldFieldName("client-id"))
+	assert.Equal(t, "X", journaldFieldName("_x"))
+}
+
+func TestJournaldUnavailable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "journal.sock")
+
+	assert.Error(t, WithJournald(testEntry(), socket))
+
+	// a socket nobody listens on anymore
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+	_, err = os.Stat(socket)
+	require.NoError(t, err)
+	assert.Error(t, WithJournald(testEntry(), socket))
+}
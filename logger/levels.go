@@ -0,0 +1,90 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package logger
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LevelNone can be used as a per-prefix level to silence a prefix entirely
+// (except for panics)
+const LevelNone = "none"
+
+var (
+	// defaultLevel is the level of loggers without a per-prefix level
+	defaultLevel = logrus.InfoLevel
+	// prefixLevels maps a logger prefix to its level
+	prefixLevels = make(map[string]logrus.Level)
+)
+
+// ParseLevel converts a level name, as accepted by logrus, or LevelNone to a
+// logrus level
+func ParseLevel(name string) (logrus.Level, error) {
+	if strings.ToLower(name) == LevelNone {
+		return logrus.PanicLevel, nil
+	}
+	level, err := logrus.ParseLevel(name)
+	if err != nil {
+		return level, fmt.Errorf("invalid log level '%s'", name)
+	}
+	return level, nil
+}
+
+// levelFor returns the level a logger with the given prefix should have: the
+// level of the longest matching prefix, or the default level. Must be called
+// with loggersLock held.
+func levelFor(prefix string) logrus.Level {
+	for p := prefix; p != ""; {
+		if level, ok := prefixLevels[p]; ok {
+			return level
+		}
+		i := strings.LastIndexByte(p, '/')
+		if i < 0 {
+			break
+		}
+		p = p[:i]
+	}
+	return defaultLevel
+}
+
+// updateLevels applies the current levels to all the loggers. Must be called
+// with loggersLock held.
+func updateLevels() {
+	for prefix, l := range loggers {
+		l.SetLevel(levelFor(prefix))
+	}
+}
+
+// SetLevel sets the level of all the loggers without a per-prefix level
+func SetLevel(level logrus.Level) {
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+	defaultLevel = level
+	updateLevels()
+}
+
+// SetPrefixLevel sets the level of the loggers with the given prefix, and the
+// ones below it (eg. "plugins" also applies to "plugins/range")
+func SetPrefixLevel(prefix string, level logrus.Level) {
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+	prefixLevels[prefix] = level
+	updateLevels()
+}
+
+// SetPrefixLevels replaces all the per-prefix levels with the given ones.
+// Prefixes that are not in levels go back to the default level.
+func SetPrefixLevels(levels map[string]logrus.Level) {
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+	prefixLevels = make(map[string]logrus.Level, len(levels))
+	for prefix, level := range levels {
+		prefixLevels[prefix] = level
+	}
+	updateLevels()
+}
@@ -2,20 +2,78 @@
 // This source code is licensed under the MIT license found in the
 // LICENSE file in the root directory of this source tree.
 
+// Package logger provides the logrus-based loggers used throughout coredhcp.
+//
+// Every package gets its own logger through GetLogger, which tags all the
+// messages with a "prefix" field. Messages are written to stdout/stderr with
+// the format selected with SetFormat, and can additionally be sent to any
+// number of sinks: a log file (see WithFile), syslog (see WithSyslog) or the
+// systemd journal (see WithJournald). Each sink has its own format.
+// Formats and sinks apply to all the loggers, regardless of which logger is
+// passed to the functions setting them up.
+//
+// The level of messages to log can be set globally with SetLevel, and
+// overridden for some prefixes with SetPrefixLevel. Prefixes are hierarchical:
+// the level set for "plugins" applies to "plugins/range", unless
+// "plugins/range" has its own level.
+//
+// Information about the client or the request being handled should be passed
+// as structured fields, using the Field* names defined here, rather than
+// being interpolated into the message, e.g.:
+//
+//  log.WithFields(logrus.Fields{
+//      logger.FieldMAC: req.ClientHWAddr.String(),
+//      logger.FieldIP:  ip.String(),
+//  }).Info("leased new address")
 package logger
 
 import (
+	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
 	"sync"
 
 	log_prefixed "github.com/chappjc/logrus-prefix"
-	"github.com/rifflock/lfshook"
 	"github.com/sirupsen/logrus"
 )
 
+// Names of the structured fields shared by all the loggers.
+const (
+	FieldPrefix    = "prefix"
+	FieldPlugin    = "plugin"
+	FieldMAC       = "mac"
+	FieldIP        = "ip"
+	FieldInterface = "interface"
+	FieldMsgType   = "msgtype"
+	FieldLease     = "lease"
+)
+
+// Supported output formats.
+const (
+	// FormatText is a human-readable format, with the prefix of the logger
+	// highlighted and colors when writing to a terminal
+	FormatText = "text"
+	// FormatJSON writes one JSON object per message
+	FormatJSON = "json"
+	// FormatLogfmt writes key=value pairs, one message per line
+	FormatLogfmt = "logfmt"
+)
+
+// Formats lists the supported output formats
+var Formats = []string{FormatText, FormatJSON, FormatLogfmt}
+
 var (
-	globalLogger   *logrus.Logger
-	getLoggerMutex sync.Mutex
+	// loggersLock protects loggers and the settings shared by all of them
+	loggersLock sync.Mutex
+	// loggers holds one logger per prefix, so that each prefix can have its
+	// own level (see SetPrefixLevel)
+	loggers   = make(map[string]*logrus.Logger)
+	output    io.Writer = os.Stderr
+	formatter logrus.Formatter = &log_prefixed.TextFormatter{
+		FullTimestamp: true,
+	}
+	hooks []logrus.Hook
 )
 
 // GetLogger returns a configured logger instance
@@ -23,24 +81,91 @@ func GetLogger(prefix string) *logrus.Entry {
 	if prefix == "" {
 		prefix = "<no prefix>"
 	}
-	if globalLogger == nil {
-		getLoggerMutex.Lock()
-		defer getLoggerMutex.Unlock()
-		logger := logrus.New()
-		logger.SetFormatter(&log_prefixed.TextFormatter{
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+	l, ok := loggers[prefix]
+	if !ok {
+		l = logrus.New()
+		l.SetOutput(output)
+		l.SetFormatter(formatter)
+		for _, h := range hooks {
+			l.AddHook(h)
+		}
+		l.SetLevel(levelFor(prefix))
+		loggers[prefix] = l
+	}
+	return l.WithField(FieldPrefix, prefix)
+}
+
+// addHook adds a hook to all the loggers, existing and future
+func addHook(h logrus.Hook) {
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+	hooks = append(hooks, h)
+	for _, l := range loggers {
+		l.AddHook(h)
+	}
+}
+
+// NewFormatter returns a formatter for the given format name.
+// Sinks other than stdout/stderr should use a formatter with colors disabled.
+func NewFormatter(format string, colors bool) (logrus.Formatter, error) {
+	switch format {
+	case FormatText, "":
+		return &log_prefixed.TextFormatter{
+			FullTimestamp:   true,
+			DisableColors:   !colors,
+			ForceFormatting: !colors,
+		}, nil
+	case FormatJSON:
+		return &logrus.JSONFormatter{}, nil
+	case FormatLogfmt:
+		return &logrus.TextFormatter{
+			DisableColors: true,
 			FullTimestamp: true,
-		})
-		globalLogger = logger
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown log format '%s', valid formats are %v", format, Formats)
 	}
-	return globalLogger.WithField("prefix", prefix)
 }
 
-// WithFile logs to the specified file in addition to the existing output.
-func WithFile(log *logrus.Entry, logfile string) {
-	log.Logger.AddHook(lfshook.NewHook(logfile, &logrus.TextFormatter{}))
+// SetFormat selects the format of the messages written to stdout/stderr.
+func SetFormat(log *logrus.Entry, format string) error {
+	f, err := NewFormatter(format, true)
+	if err != nil {
+		return err
+	}
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+	formatter = f
+	for _, l := range loggers {
+		l.SetFormatter(f)
+	}
+	return nil
+}
+
+// WithFile logs to the specified file in addition to the existing output,
+// using the given format. The file is reopened when ReopenFiles is called, to
+// allow for external log rotation.
+func WithFile(log *logrus.Entry, logfile, format string) error {
+	f, err := NewFormatter(format, false)
+	if err != nil {
+		return err
+	}
+	w, err := openReopenableFile(logfile)
+	if err != nil {
+		return err
+	}
+	addHook(&writerHook{w: w, formatter: f})
+	return nil
 }
 
 // WithNoStdOutErr disables logging to stdout/stderr.
 func WithNoStdOutErr(log *logrus.Entry) {
-	log.Logger.SetOutput(ioutil.Discard)
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+	output = ioutil.Discard
+	for _, l := range loggers {
+		l.SetOutput(output)
+	}
 }
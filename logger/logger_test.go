@@ -0,0 +1,120 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package logger
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEntry() *logrus.Entry {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+	return l.WithFields(logrus.Fields{
+		FieldPrefix: "plugins/test",
+		FieldMAC:    "00:11:22:33:44:55",
+	})
+}
+
+func TestFormats(t *testing.T) {
+	entry := testEntry()
+	entry.Message = "hello"
+	entry.Level = logrus.InfoLevel
+
+	f, err := NewFormatter(FormatJSON, false)
+	require.NoError(t, err)
+	out, err := f.Format(entry)
+	require.NoError(t, err)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(out, &decoded))
+	assert.Equal(t, "hello", decoded["msg"])
+	assert.Equal(t, "00:11:22:33:44:55", decoded[FieldMAC])
+
+	f, err = NewFormatter(FormatLogfmt, false)
+	require.NoError(t, err)
+	out, err = f.Format(entry)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "msg=hello")
+	assert.Contains(t, string(out), "mac=\"00:11:22:33:44:55\"")
+
+	f, err = NewFormatter(FormatText, false)
+	require.NoError(t, err)
+	out, err = f.Format(entry)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "plugins/test")
+	assert.NotContains(t, string(out), "\x1b[", "colors should be disabled")
+
+	_, err = NewFormatter("xml", false)
+	assert.Error(t, err)
+}
+
+func TestReopenableFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "coredhcp.log")
+
+	rf, err := openReopenableFile(name)
+	require.NoError(t, err)
+	_, err = rf.Write([]byte("first\n"))
+	require.NoError(t, err)
+
+	// rotate the file away, then reopen
+	require.NoError(t, os.Rename(name, name+".1"))
+	require.NoError(t, ReopenFiles())
+	_, err = rf.Write([]byte("second\n"))
+	require.NoError(t, err)
+
+	rotated, err := ioutil.ReadFile(name + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, "first\n", string(rotated))
+	current, err := ioutil.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", string(current))
+}
+
+func TestPrefixLevels(t *testing.T) {
+	defer SetPrefixLevels(nil)
+	defer SetLevel(logrus.InfoLevel)
+
+	rangeLog := GetLogger("plugins/range")
+	fileLog := GetLogger("plugins/file")
+	serverLog := GetLogger("server")
+
+	SetLevel(logrus.WarnLevel)
+	SetPrefixLevels(map[string]logrus.Level{
+		"plugins":       logrus.InfoLevel,
+		"plugins/range": logrus.DebugLevel,
+	})
+	assert.Equal(t, logrus.DebugLevel, rangeLog.Logger.GetLevel())
+	assert.Equal(t, logrus.InfoLevel, fileLog.Logger.GetLevel())
+	assert.Equal(t, logrus.WarnLevel, serverLog.Logger.GetLevel())
+	// loggers created after the levels were set get them too
+	assert.Equal(t, logrus.InfoLevel, GetLogger("plugins/dns").Logger.GetLevel())
+
+	SetPrefixLevel("server", logrus.ErrorLevel)
+	assert.Equal(t, logrus.ErrorLevel, serverLog.Logger.GetLevel())
+
+	SetPrefixLevels(nil)
+	assert.Equal(t, logrus.WarnLevel, rangeLog.Logger.GetLevel())
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("debug")
+	require.NoError(t, err)
+	assert.Equal(t, logrus.DebugLevel, level)
+	level, err = ParseLevel(LevelNone)
+	require.NoError(t, err)
+	assert.Equal(t, logrus.PanicLevel, level)
+	_, err = ParseLevel("verbose")
+	assert.Error(t, err)
+}
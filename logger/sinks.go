@@ -0,0 +1,97 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// writerHook is a logrus hook writing every message to w, formatted with
+// formatter.
+type writerHook struct {
+	w         io.Writer
+	formatter logrus.Formatter
+}
+
+func (h *writerHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *writerHook) Fire(entry *logrus.Entry) error {
+	msg, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = h.w.Write(msg)
+	return err
+}
+
+// reopenableFile is an append-only file that can be closed and opened again
+// under the same name. This is what log rotation tools like logrotate expect:
+// they move the file away, then signal the program to start writing to a new
+// file.
+type reopenableFile struct {
+	sync.Mutex
+	name string
+	f    *os.File
+}
+
+var (
+	openFilesLock sync.Mutex
+	openFiles     []*reopenableFile
+)
+
+func openReopenableFile(name string) (*reopenableFile, error) {
+	rf := &reopenableFile{name: name}
+	if err := rf.Reopen(); err != nil {
+		return nil, err
+	}
+	openFilesLock.Lock()
+	openFiles = append(openFiles, rf)
+	openFilesLock.Unlock()
+	return rf, nil
+}
+
+func (rf *reopenableFile) Write(p []byte) (int, error) {
+	rf.Lock()
+	defer rf.Unlock()
+	return rf.f.Write(p)
+}
+
+// Reopen closes the underlying file, if any, and opens it again, creating it
+// if needed.
+func (rf *reopenableFile) Reopen() error {
+	f, err := os.OpenFile(rf.name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open log file %s: %w", rf.name, err)
+	}
+	rf.Lock()
+	defer rf.Unlock()
+	old := rf.f
+	rf.f = f
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+// ReopenFiles reopens all the log files set up with WithFile. It is meant to
+// be called after the files have been rotated, usually upon receiving SIGUSR1.
+func ReopenFiles() error {
+	openFilesLock.Lock()
+	defer openFilesLock.Unlock()
+	var firstErr error
+	for _, rf := range openFiles {
+		if err := rf.Reopen(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,67 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build !windows,!plan9
+
+package logger
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultSyslogTag is the program name attached to messages sent to syslog
+const DefaultSyslogTag = "coredhcp"
+
+type syslogHook struct {
+	w         *syslog.Writer
+	formatter logrus.Formatter
+}
+
+// WithSyslog sends all messages to the syslog daemon listening on the given
+// unix datagram socket, in the given format. If socket is empty, the default
+// locations (/dev/log and the like) are tried.
+func WithSyslog(log *logrus.Entry, socket, format string) error {
+	f, err := NewFormatter(format, false)
+	if err != nil {
+		return err
+	}
+	network := ""
+	if socket != "" {
+		network = "unixgram"
+	}
+	w, err := syslog.Dial(network, socket, syslog.LOG_DAEMON|syslog.LOG_INFO, DefaultSyslogTag)
+	if err != nil {
+		return fmt.Errorf("cannot connect to syslog: %w", err)
+	}
+	addHook(&syslogHook{w: w, formatter: f})
+	return nil
+}
+
+func (h *syslogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *syslogHook) Fire(entry *logrus.Entry) error {
+	msg, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	line := strings.TrimRight(string(msg), "\n")
+	switch entry.Level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return h.w.Crit(line)
+	case logrus.ErrorLevel:
+		return h.w.Err(line)
+	case logrus.WarnLevel:
+		return h.w.Warning(line)
+	case logrus.InfoLevel:
+		return h.w.Info(line)
+	default:
+		return h.w.Debug(line)
+	}
+}
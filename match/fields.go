@@ -0,0 +1,262 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package match
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// field computes the values of a field of a request. arg is the index of
+// indexed fields such as option[N].
+type field struct {
+	indexed bool
+	// maxIndex bounds the index of indexed fields for DHCPv4
+	maxIndex int
+	v4       func(c *context, arg int) []string
+	v6       func(c *context, arg int) []string
+	// validate optionally checks the literals the field is compared to
+	validate func(op, lit string) error
+}
+
+// fieldRef is a field as used in an expression
+type fieldRef struct {
+	name string
+	*field
+	arg int
+}
+
+func (f *fieldRef) values(c *context) []string {
+	if c.req4 != nil {
+		if f.v4 == nil || (f.indexed && f.arg > f.maxIndex) {
+			return nil
+		}
+		return f.v4(c, f.arg)
+	}
+	if f.v6 == nil {
+		return nil
+	}
+	return f.v6(c, f.arg)
+}
+
+var fields = map[string]*field{
+	"class": {
+		v4: classes,
+		v6: classes,
+	},
+	"interface": {
+		v4: interfaceName,
+		v6: interfaceName,
+	},
+	"message_type": {
+		v4:       func(c *context, _ int) []string { return []string{c.req4.MessageType().String()} },
+		v6:       func(c *context, _ int) []string { return []string{c.msg6.MessageType.String()} },
+		validate: validateMessageType,
+	},
+	"giaddr": {
+		v4: func(c *context, _ int) []string {
+			if c.req4.GatewayIPAddr.IsUnspecified() {
+				return nil
+			}
+			return []string{c.req4.GatewayIPAddr.String()}
+		},
+		v6: func(c *context, _ int) []string {
+			if relay := relay6(c); relay != nil {
+				return []string{relay.LinkAddr.String()}
+			}
+			return nil
+		},
+	},
+	"requested": {
+		v4: func(c *context, _ int) []string {
+			var ret []string
+			for _, code := range c.req4.ParameterRequestList() {
+				ret = append(ret, strconv.Itoa(int(code.Code())))
+			}
+			return ret
+		},
+		v6: func(c *context, _ int) []string {
+			var ret []string
+			for _, code := range c.msg6.Options.RequestedOptions() {
+				ret = append(ret, strconv.Itoa(int(code)))
+			}
+			return ret
+		},
+	},
+	"mac": {
+		v4: func(c *context, _ int) []string { return macValue(c.req4.ClientHWAddr) },
+		v6: func(c *context, _ int) []string { return macValue(mac6(c)) },
+	},
+	"oui": {
+		v4: func(c *context, _ int) []string { return ouiValue(c.req4.ClientHWAddr) },
+		v6: func(c *context, _ int) []string { return ouiValue(mac6(c)) },
+	},
+	"hostname": {
+		v4: func(c *context, _ int) []string { return nonEmpty(c.req4.HostName()) },
+	},
+	"vendor_class": {
+		v4: func(c *context, _ int) []string { return nonEmpty(c.req4.ClassIdentifier()) },
+		v6: func(c *context, _ int) []string {
+			var ret []string
+			for _, vc := range c.msg6.Options.VendorClasses() {
+				ret = append(ret, byteStrings(vc.Data)...)
+			}
+			return ret
+		},
+	},
+	"user_class": {
+		v4: func(c *context, _ int) []string { return c.req4.UserClass() },
+		v6: func(c *context, _ int) []string { return byteStrings(c.msg6.Options.UserClasses()) },
+	},
+	"arch": {
+		v4: func(c *context, _ int) []string { return archValues(c.req4.ClientArch()) },
+		v6: func(c *context, _ int) []string { return archValues(c.msg6.Options.ArchTypes()) },
+	},
+	"circuit_id": {
+		v4: func(c *context, _ int) []string { return agentOption(c, dhcpv4.AgentCircuitIDSubOption) },
+		v6: func(c *context, _ int) []string {
+			if relay := relay6(c); relay != nil {
+				return nonEmpty(string(relay.Options.InterfaceID()))
+			}
+			return nil
+		},
+	},
+	"remote_id": {
+		v4: func(c *context, _ int) []string { return agentOption(c, dhcpv4.AgentRemoteIDSubOption) },
+		v6: func(c *context, _ int) []string {
+			if relay := relay6(c); relay != nil {
+				if id := relay.Options.RemoteID(); id != nil {
+					return nonEmpty(string(id.RemoteID))
+				}
+			}
+			return nil
+		},
+	},
+	"option": {
+		indexed:  true,
+		maxIndex: 254,
+		v4: func(c *context, code int) []string {
+			if v, ok := c.req4.Options[uint8(code)]; ok {
+				return []string{string(v)}
+			}
+			return nil
+		},
+		v6: func(c *context, code int) []string {
+			var ret []string
+			for _, opt := range c.msg6.Options.Get(dhcpv6.OptionCode(code)) {
+				ret = append(ret, string(opt.ToBytes()))
+			}
+			return ret
+		},
+	},
+}
+
+func classes(c *context, _ int) []string {
+	return c.state.Classes
+}
+
+func interfaceName(c *context, _ int) []string {
+	return nonEmpty(c.state.InterfaceName)
+}
+
+// messageTypes are the names of the DHCPv4 and DHCPv6 message types
+var messageTypes = func() map[string]bool {
+	names := make(map[string]bool)
+	for t := 1; t < 256; t++ {
+		for _, name := range []string{dhcpv4.MessageType(t).String(), dhcpv6.MessageType(t).String()} {
+			if !strings.HasPrefix(name, "unknown") {
+				names[name] = true
+			}
+		}
+	}
+	return names
+}()
+
+// validateMessageType catches typos in message types, which would otherwise
+// never match
+func validateMessageType(op, lit string) error {
+	if (op == "==" || op == "!=") && !messageTypes[lit] {
+		return fmt.Errorf("unknown message type %q", lit)
+	}
+	return nil
+}
+
+// nonEmpty returns s as the only value of a field, or no value if it is empty
+func nonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
+
+func byteStrings(data [][]byte) []string {
+	ret := make([]string, 0, len(data))
+	for _, d := range data {
+		ret = append(ret, string(d))
+	}
+	return ret
+}
+
+func macValue(mac net.HardwareAddr) []string {
+	if len(mac) == 0 {
+		return nil
+	}
+	return []string{mac.String()}
+}
+
+func ouiValue(mac net.HardwareAddr) []string {
+	if len(mac) < 3 {
+		return nil
+	}
+	return []string{mac[:3].String()}
+}
+
+func archValues(archs []iana.Arch) []string {
+	ret := make([]string, 0, len(archs))
+	for _, a := range archs {
+		ret = append(ret, strconv.Itoa(int(a)))
+	}
+	return ret
+}
+
+func agentOption(c *context, code dhcpv4.OptionCode) []string {
+	info := c.req4.RelayAgentInfo()
+	if info == nil {
+		return nil
+	}
+	if v := info.Get(code); v != nil {
+		return []string{string(v)}
+	}
+	return nil
+}
+
+// mac6 returns the MAC address of a DHCPv6 client, if it can be found
+func mac6(c *context) net.HardwareAddr {
+	mac, err := dhcpv6.ExtractMAC(c.req6)
+	if err != nil {
+		return nil
+	}
+	return mac
+}
+
+// relay6 returns the relay closest to the client of a DHCPv6 request, or nil
+// if the request wasn't relayed
+func relay6(c *context) *dhcpv6.RelayMessage {
+	if !c.req6.IsRelay() {
+		return nil
+	}
+	inner, err := dhcpv6.DecapsulateRelayIndex(c.req6, -1)
+	if err != nil {
+		return nil
+	}
+	relay, _ := inner.(*dhcpv6.RelayMessage)
+	return relay
+}
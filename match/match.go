@@ -0,0 +1,207 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package match implements the boolean expressions used to classify requests
+// (see the class plugin) and to run plugins only for some requests (the
+// `when` key of a plugin in the configuration), eg:
+//
+//  vendor_class startswith "PXEClient" and arch == 7
+//  class == "voip-phones" or oui == "00:1b:54"
+//  interface == "eth1" and message_type == "DISCOVER" and requested == 66
+//  not (option[77] or circuit_id contains "guest")
+//
+// An expression compares a field of the request to a string or an integer
+// literal, with one of the operators:
+//
+//  ==, !=      equality
+//  startswith  prefix
+//  endswith    suffix
+//  contains    substring
+//  matches     regular expression (see the regexp package)
+//  in          address within a network, eg. giaddr in "10.1.0.0/16"
+//
+// Fields can have several values, eg. a request can belong to several
+// classes: a comparison is true if any value matches, except != which is
+// true if no value is equal. A field alone, without an operator, is true if it
+// has any value, which tests whether an option is present. Comparisons are
+// combined with `and`, `or` and `not`, and grouped with parentheses. Strings
+// are written as in Go, and integers are compared to the decimal value of
+// numeric fields. The fields are:
+//
+//  class         the classes assigned to the request by the class plugins
+//  interface     the name of the interface the request was received on
+//  message_type  the type of the request, eg. "DISCOVER" or "SOLICIT"
+//  giaddr        the relay address (DHCPv4), or the link address of the
+//                relay closest to the client (DHCPv6)
+//  requested     the codes of the options requested by the client (option
+//                55, or 6 for DHCPv6)
+//  mac           the client MAC address, eg. "00:11:22:33:44:55"
+//  oui           the first three bytes of the MAC address, eg. "00:11:22"
+//  hostname      the hostname option (DHCPv4 only)
+//  vendor_class  the vendor class identifier (option 60, or 16 for DHCPv6)
+//  user_class    the user classes (option 77, or 15 for DHCPv6)
+//  arch          the client system architecture types (option 93, or 61)
+//  circuit_id    the relay agent circuit ID (option 82 sub-option 1), or
+//                the interface ID added by the DHCPv6 relay
+//  remote_id     the relay agent remote ID (option 82 sub-option 2, or
+//                option 37 added by the DHCPv6 relay)
+//  option[N]     the raw value of option N; all the options with that code
+//                for DHCPv6
+package match
+
+import (
+	"fmt"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// Expr is a compiled expression
+type Expr struct {
+	src  string
+	root node
+}
+
+// Compile parses an expression
+func Compile(src string) (*Expr, error) {
+	p := parser{lex: lexer{src: src}}
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// MustCompile is like Compile but panics if the expression cannot be parsed
+func MustCompile(src string) *Expr {
+	e, err := Compile(src)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// Compare returns the expression comparing a field to a string, as
+// `field op "literal"` would, eg. Compare("giaddr", "in", "10.1.0.0/16")
+func Compare(field, op, literal string) (*Expr, error) {
+	p := parser{lex: lexer{src: field}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	ref, err := p.parseField()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	n, err := comparison(ref, op, literal)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", field, err)
+	}
+	return &Expr{src: fmt.Sprintf("%s %s %q", field, op, literal), root: n}, nil
+}
+
+// And returns the expression matching when all of exprs match. nil
+// expressions are ignored, and And returns nil if they are all nil.
+func And(exprs ...*Expr) *Expr {
+	return combine("and", exprs, func(l, r node) node { return &andNode{l, r} })
+}
+
+// Or returns the expression matching when any of exprs matches. nil
+// expressions are ignored like for And.
+func Or(exprs ...*Expr) *Expr {
+	return combine("or", exprs, func(l, r node) node { return &orNode{l, r} })
+}
+
+func combine(op string, exprs []*Expr, join func(l, r node) node) *Expr {
+	var ret *Expr
+	for _, e := range exprs {
+		switch {
+		case e == nil:
+		case ret == nil:
+			ret = e
+		default:
+			ret = &Expr{src: fmt.Sprintf("(%s) %s (%s)", ret.src, op, e.src), root: join(ret.root, e.root)}
+		}
+	}
+	return ret
+}
+
+// String returns the source of the expression
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Match4 evaluates the expression for a DHCPv4 request
+func (e *Expr) Match4(state *handler.PropagateState, req *dhcpv4.DHCPv4) bool {
+	return e.root.eval(&context{state: state, req4: req})
+}
+
+// Match6 evaluates the expression for a DHCPv6 request, which may be relayed
+func (e *Expr) Match6(state *handler.PropagateState, req dhcpv6.DHCPv6) bool {
+	c := &context{state: state, req6: req}
+	if msg, err := req.GetInnerMessage(); err == nil {
+		c.msg6 = msg
+	} else {
+		c.msg6 = &dhcpv6.Message{}
+	}
+	return e.root.eval(c)
+}
+
+// context is what an expression is evaluated against. Exactly one of req4
+// and req6 is set.
+type context struct {
+	state *handler.PropagateState
+	req4  *dhcpv4.DHCPv4
+	req6  dhcpv6.DHCPv6
+	// msg6 is the client message in req6
+	msg6 *dhcpv6.Message
+}
+
+// node is a node of the syntax tree of an expression
+type node interface {
+	eval(c *context) bool
+}
+
+type andNode struct{ left, right node }
+
+func (n *andNode) eval(c *context) bool { return n.left.eval(c) && n.right.eval(c) }
+
+type orNode struct{ left, right node }
+
+func (n *orNode) eval(c *context) bool { return n.left.eval(c) || n.right.eval(c) }
+
+type notNode struct{ expr node }
+
+func (n *notNode) eval(c *context) bool { return !n.expr.eval(c) }
+
+// presentNode is a field without operator: it is true if the field has any
+// value
+type presentNode struct {
+	field *fieldRef
+}
+
+func (n *presentNode) eval(c *context) bool {
+	return len(n.field.values(c)) > 0
+}
+
+// compareNode compares the values of a field to a literal
+type compareNode struct {
+	field *fieldRef
+	// test checks one value of the field
+	test func(value string) bool
+	// negate is set for !=, which is true if no value is equal
+	negate bool
+}
+
+func (n *compareNode) eval(c *context) bool {
+	for _, v := range n.field.values(c) {
+		if n.test(v) {
+			return !n.negate
+		}
+	}
+	return n.negate
+}
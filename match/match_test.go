@@ -0,0 +1,157 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package match
+
+import (
+	"net"
+	"testing"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x00, 0x1b, 0x54, 3, 4, 5},
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00007")),
+		dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64)),
+		dhcpv4.WithOption(dhcpv4.OptUserClass("ipxe")),
+		dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(
+			dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("vlan10-guest")),
+		)),
+	)
+	require.NoError(t, err)
+	req.GatewayIPAddr = net.IPv4(10, 1, 2, 3)
+	req.UpdateOption(dhcpv4.OptParameterRequestList(dhcpv4.OptionRouter, dhcpv4.OptionBootfileName))
+	state := &handler.PropagateState{InterfaceName: "eth1", Classes: []string{"pxe", "lab"}}
+
+	for src, want := range map[string]bool{
+		`interface == "eth1"`:                                   true,
+		`message_type == "DISCOVER"`:                            true,
+		`message_type != "REQUEST"`:                             true,
+		`giaddr in "10.1.0.0/16"`:                               true,
+		`giaddr in "10.1.2.3"`:                                  true,
+		`giaddr in "10.2.0.0/16"`:                               false,
+		`mac in "10.0.0.0/8"`:                                   false,
+		`requested == 67`:                                       true,
+		`requested == 66`:                                       false,
+		`class == "pxe"`:                                        true,
+		`class == "voip"`:                                       false,
+		`class != "voip"`:                                       true,
+		`class != "lab"`:                                        false,
+		`oui == "00:1b:54"`:                                     true,
+		`mac == "00:1b:54:03:04:05"`:                            true,
+		`vendor_class startswith "PXEClient"`:                   true,
+		`vendor_class endswith "00007" and arch == 7`:           true,
+		`arch == 0x0007`:                                        true,
+		`arch == 0`:                                             false,
+		`user_class == "ipxe"`:                                  true,
+		`circuit_id contains "guest"`:                           true,
+		`circuit_id matches "^vlan[0-9]+-"`:                     true,
+		`remote_id`:                                             false,
+		`hostname`:                                              false,
+		`option[60]`:                                            true,
+		`option[60] == "PXEClient:Arch:00007"`:                  true,
+		`not option[77] or class == "pxe"`:                      true,
+		`not (option[77] or class == "pxe")`:                    false,
+		`class == "voip" or class == "lab" and oui == "x"`:      false,
+		`(class == "voip" or class == "lab") and not remote_id`: true,
+	} {
+		e, err := Compile(src)
+		if assert.NoError(t, err, src) {
+			assert.Equal(t, want, e.Match4(state, req), src)
+		}
+	}
+}
+
+func TestMatch6(t *testing.T) {
+	solicit, err := dhcpv6.NewSolicit(net.HardwareAddr{0x00, 0x1b, 0x54, 3, 4, 5},
+		dhcpv6.WithOption(&dhcpv6.OptVendorClass{EnterpriseNumber: 9, Data: [][]byte{[]byte("cisco-phone")}}),
+	)
+	require.NoError(t, err)
+	relayed, err := dhcpv6.EncapsulateRelay(solicit, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8::1"), net.ParseIP("fe80::1"))
+	require.NoError(t, err)
+	relayed.Options.Add(dhcpv6.OptInterfaceID([]byte("port7")))
+	state := &handler.PropagateState{}
+
+	for src, want := range map[string]bool{
+		`message_type == "SOLICIT"`:     true,
+		`giaddr in "2001:db8::/64"`:     true,
+		`requested == 23`:               true,
+		`interface`:                     false,
+		`oui == "00:1b:54"`:             true,
+		`vendor_class == "cisco-phone"`: true,
+		`circuit_id == "port7"`:         true,
+		`option[16]`:                    true,
+		`option[300]`:                   false,
+		`hostname or class`:             false,
+	} {
+		e, err := Compile(src)
+		if assert.NoError(t, err, src) {
+			assert.Equal(t, want, e.Match6(state, relayed), src)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		``,
+		`class ==`,
+		`class = "x"`,
+		`klass == "x"`,
+		`option == "x"`,
+		`option[0]`,
+		`option[70000]`,
+		`option[60`,
+		`(class == "x"`,
+		`class == "x")`,
+		`class == "x`,
+		`class matches "("`,
+		`giaddr in "10.0.0.0/33"`,
+		`message_type == "DISCOVERY"`,
+		`class == "x" class`,
+		`class == 12ab`,
+		`not`,
+	} {
+		_, err := Compile(src)
+		assert.Error(t, err, src)
+	}
+	_, err := Compile(`class == "x" or clas == "y"`)
+	if assert.IsType(t, &SyntaxError{}, err) {
+		assert.Equal(t, 16, err.(*SyntaxError).Pos)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0, 1, 2, 3, 4, 5}, dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient")))
+	require.NoError(t, err)
+	state := &handler.PropagateState{InterfaceName: "eth1"}
+
+	intf, err := Compare("interface", "==", "eth1")
+	require.NoError(t, err)
+	vc, err := Compare("option[60]", "startswith", "PXE")
+	require.NoError(t, err)
+	other, err := Compare("interface", "==", "eth2")
+	require.NoError(t, err)
+	assert.True(t, And(intf, nil, vc).Match4(state, req))
+	assert.False(t, And(intf, other).Match4(state, req))
+	assert.True(t, Or(other, vc).Match4(state, req))
+	assert.Nil(t, And(nil, nil))
+	assert.Equal(t, `(interface == "eth1") and (option[60] startswith "PXE")`, And(intf, vc).String())
+
+	for _, args := range [][3]string{
+		{"interfaces", "==", "eth1"},
+		{"interface", "=", "eth1"},
+		{"option", "==", "x"},
+		{"interface and", "==", "x"},
+		{"giaddr", "in", "10.0.0"},
+	} {
+		_, err := Compare(args[0], args[1], args[2])
+		assert.Error(t, err, args)
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package match
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokInt
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	// text is the token as written, or the value of a string literal
+	text string
+	// pos is the offset of the token in the expression
+	pos int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// lexer splits an expression into tokens
+type lexer struct {
+	src string
+	pos int
+}
+
+func isIdentChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && strings.IndexByte(" \t\r\n", l.src[l.pos]) >= 0 {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case isIdentChar(c, true):
+		for l.pos < len(l.src) && isIdentChar(l.src[l.pos], false) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: l.src[start:l.pos], pos: start}, nil
+	case c >= '0' && c <= '9':
+		for l.pos < len(l.src) && isIdentChar(l.src[l.pos], false) {
+			l.pos++
+		}
+		text := l.src[start:l.pos]
+		if _, err := strconv.ParseUint(text, 0, 32); err != nil {
+			return token{}, &SyntaxError{Pos: start, Msg: fmt.Sprintf("invalid integer %q", text)}
+		}
+		return token{kind: tokInt, text: text, pos: start}, nil
+	case c == '"' || c == '`':
+		// find the end of the literal, skipping escaped quotes
+		end := l.pos + 1
+		for end < len(l.src) && l.src[end] != c {
+			if c == '"' && l.src[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(l.src) {
+			return token{}, &SyntaxError{Pos: start, Msg: "unterminated string"}
+		}
+		l.pos = end + 1
+		s, err := strconv.Unquote(l.src[start:l.pos])
+		if err != nil {
+			return token{}, &SyntaxError{Pos: start, Msg: "invalid string " + l.src[start:l.pos]}
+		}
+		return token{kind: tokString, text: s, pos: start}, nil
+	}
+	for _, punct := range []string{"==", "!=", "(", ")", "[", "]"} {
+		if strings.HasPrefix(l.src[l.pos:], punct) {
+			l.pos += len(punct)
+			return token{kind: tokPunct, text: punct, pos: start}, nil
+		}
+	}
+	return token{}, &SyntaxError{Pos: start, Msg: fmt.Sprintf("unexpected character %q", c)}
+}
+
+// SyntaxError is returned by Compile for invalid expressions
+type SyntaxError struct {
+	// Pos is the offset of the error in the expression
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("at column %d: %s", e.Pos+1, e.Msg)
+}
+
+// parser is a recursive descent parser for the grammar:
+//
+//  expr       = and { "or" and }
+//  and        = unary { "and" unary }
+//  unary      = "not" unary | "(" expr ")" | comparison
+//  comparison = field [ operator literal ]
+//  field      = identifier [ "[" integer "]" ]
+//  literal    = string | integer
+type parser struct {
+	lex lexer
+	tok token
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Pos: p.tok.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// keyword returns whether the current token is the given keyword
+func (p *parser) keyword(kw string) bool {
+	return p.tok.kind == tokIdent && p.tok.text == kw
+}
+
+func (p *parser) punct(s string) bool {
+	return p.tok.kind == tokPunct && p.tok.text == s
+}
+
+func (p *parser) parse() (node, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokEOF {
+		return nil, p.errorf("empty expression")
+	}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	return n, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	switch {
+	case p.keyword("not"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{n}, nil
+	case p.punct("("):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.punct(")") {
+			return nil, p.errorf("expected \")\", got %s", p.tok)
+		}
+		return n, p.advance()
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseField() (*fieldRef, error) {
+	if p.tok.kind != tokIdent {
+		return nil, p.errorf("expected a field, got %s", p.tok)
+	}
+	f, ok := fields[p.tok.text]
+	if !ok {
+		return nil, p.errorf("unknown field %s", p.tok)
+	}
+	ref := &fieldRef{name: p.tok.text, field: f}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if !f.indexed {
+		return ref, nil
+	}
+	if !p.punct("[") {
+		return nil, p.errorf("expected \"[\" after %s", ref.name)
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokInt {
+		return nil, p.errorf("expected an integer, got %s", p.tok)
+	}
+	arg, _ := strconv.ParseUint(p.tok.text, 0, 32)
+	if arg == 0 || arg > 0xffff {
+		return nil, p.errorf("invalid index %s", p.tok.text)
+	}
+	ref.arg = int(arg)
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if !p.punct("]") {
+		return nil, p.errorf("expected \"]\", got %s", p.tok)
+	}
+	return ref, p.advance()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	field, err := p.parseField()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.punct("==") || p.punct("!="):
+	case p.tok.kind == tokIdent && operators[p.tok.text] != nil:
+	default:
+		return &presentNode{field: field}, nil
+	}
+	op := p.tok.text
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var lit string
+	switch p.tok.kind {
+	case tokString:
+		lit = p.tok.text
+	case tokInt:
+		n, _ := strconv.ParseUint(p.tok.text, 0, 32)
+		lit = strconv.FormatUint(n, 10)
+	default:
+		return nil, p.errorf("expected a string or an integer after %s, got %s", op, p.tok)
+	}
+	n, err := comparison(field, op, lit)
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	return n, p.advance()
+}
+
+// comparison builds the node comparing a field to a literal
+func comparison(field *fieldRef, op, lit string) (node, error) {
+	if field.validate != nil {
+		if err := field.validate(op, lit); err != nil {
+			return nil, err
+		}
+	}
+	n := &compareNode{field: field}
+	switch op {
+	case "==":
+		n.test = func(v string) bool { return v == lit }
+	case "!=":
+		n.test = func(v string) bool { return v == lit }
+		n.negate = true
+	default:
+		build, ok := operators[op]
+		if !ok {
+			return nil, fmt.Errorf("unknown operator %q", op)
+		}
+		var err error
+		if n.test, err = build(lit); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// operators are the operators written as words, which build the test of a
+// value from the literal they are given
+var operators = map[string]func(lit string) (func(string) bool, error){
+	"startswith": func(lit string) (func(string) bool, error) {
+		return func(v string) bool { return strings.HasPrefix(v, lit) }, nil
+	},
+	"endswith": func(lit string) (func(string) bool, error) {
+		return func(v string) bool { return strings.HasSuffix(v, lit) }, nil
+	},
+	"contains": func(lit string) (func(string) bool, error) {
+		return func(v string) bool { return strings.Contains(v, lit) }, nil
+	},
+	"matches": func(lit string) (func(string) bool, error) {
+		re, err := regexp.Compile(lit)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	},
+	"in": func(lit string) (func(string) bool, error) {
+		n, err := parseNet(lit)
+		if err != nil {
+			return nil, err
+		}
+		return func(v string) bool {
+			ip := net.ParseIP(v)
+			return ip != nil && n.Contains(ip)
+		}, nil
+	},
+}
+
+// parseNet parses a network in CIDR notation, or a single address
+func parseNet(s string) (*net.IPNet, error) {
+	if _, n, err := net.ParseCIDR(s); err == nil {
+		return n, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid network %q", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
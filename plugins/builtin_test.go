@@ -0,0 +1,70 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/dns"
+	"github.com/coredhcp/coredhcp/plugins/leasetime"
+	"github.com/coredhcp/coredhcp/plugins/mtu"
+	"github.com/coredhcp/coredhcp/plugins/nbp"
+	"github.com/coredhcp/coredhcp/plugins/netmask"
+	"github.com/coredhcp/coredhcp/plugins/router"
+	"github.com/coredhcp/coredhcp/plugins/searchdomains"
+	"github.com/coredhcp/coredhcp/plugins/serverid"
+	"github.com/coredhcp/coredhcp/plugins/staticroute"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestInstancesAreIndependent sets up two instances of each built-in plugin
+// with different arguments, and checks that setting up the second one doesn't
+// change the responses of the first one.
+func TestInstancesAreIndependent(t *testing.T) {
+	for _, tt := range []struct {
+		plugin *plugins.Plugin
+		first  []string
+		second []string
+	}{
+		{&dns.Plugin, []string{"192.0.2.1"}, []string{"192.0.2.2"}},
+		{&leasetime.Plugin, []string{"1h"}, []string{"2h"}},
+		{&mtu.Plugin, []string{"1500"}, []string{"9000"}},
+		{&nbp.Plugin, []string{"tftp://192.0.2.1/first"}, []string{"tftp://192.0.2.2/second"}},
+		{&netmask.Plugin, []string{"255.255.255.0"}, []string{"255.255.0.0"}},
+		{&router.Plugin, []string{"192.0.2.1"}, []string{"192.0.2.2"}},
+		{&searchdomains.Plugin, []string{"first.example.com"}, []string{"second.example.com"}},
+		{&serverid.Plugin, []string{"192.0.2.1"}, []string{"192.0.2.2"}},
+		{&staticroute.Plugin, []string{"10.0.0.0/8,192.0.2.1"}, []string{"10.0.0.0/8,192.0.2.2"}},
+	} {
+		t.Run(tt.plugin.Name, func(t *testing.T) {
+			first, err := tt.plugin.Setup4(tt.first...)
+			require.NoError(t, err)
+			before := handle4(t, first)
+
+			second, err := tt.plugin.Setup4(tt.second...)
+			require.NoError(t, err)
+			assert.NotEqual(t, before, handle4(t, second), "the arguments should change the response")
+			assert.Equal(t, before, handle4(t, first), "the first instance should keep its configuration")
+		})
+	}
+}
+
+// handle4 runs a DHCPv4 request through h, and returns the options of the
+// response
+func handle4(t *testing.T, h handler.Handler4) dhcpv4.Options {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionInterfaceMTU, dhcpv4.OptionTFTPServerName, dhcpv4.OptionBootfileName))
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, _ = h(&handler.PropagateState{}, req, resp)
+	require.NotNil(t, resp)
+	return resp.Options
+}
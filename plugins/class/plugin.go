@@ -0,0 +1,132 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package class assigns requests to classes, which the next plugins can be
+// made conditional on with a `when` guard. Each class is matched by an
+// expression on the request (see the match package), eg:
+//
+//  $ cat config.yml
+//
+//  server4:
+//     ...
+//     plugins:
+//       - class:
+//           voip-phones: vendor_class startswith "Cisco IP Phone" or oui == "00:1b:54"
+//           pxe: arch == 7 or vendor_class startswith "PXEClient"
+//           guests: circuit_id contains "guest"
+//       - nbp: http://10.0.0.1/boot.ipxe
+//         when: class == "pxe"
+//     ...
+//
+// A request can be in several classes. The expressions of a class plugin see
+// the classes assigned by the class plugins before it, but not those of the
+// same plugin.
+package class
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/match"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var log = logger.GetLogger("plugins/class")
+
+const pluginName = "class"
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:         pluginName,
+	Setup6Config: setup6,
+	Setup4Config: setup4,
+	Args: []plugins.Arg{
+		{Name: "class", Type: "expression", Help: "map of class names to the expression the requests in the class match", Repeated: true},
+	},
+}
+
+// class is a class name and its expression
+type class struct {
+	name string
+	expr *match.Expr
+}
+
+// PluginState is the data held by an instance of the class plugin
+type PluginState struct {
+	// classes are sorted by name
+	classes []class
+}
+
+func setup6(pc *config.PluginConfig) (handler.Handler6, error) {
+	p, err := setupClasses(pc)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler6, nil
+}
+
+func setup4(pc *config.PluginConfig) (handler.Handler4, error) {
+	p, err := setupClasses(pc)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
+
+func setupClasses(pc *config.PluginConfig) (*PluginState, error) {
+	var exprs map[string]string
+	if !pc.IsMap() {
+		return nil, errors.New("want a map of class names to expressions")
+	}
+	if err := pc.Decode(&exprs); err != nil {
+		return nil, err
+	}
+	if len(exprs) == 0 {
+		return nil, errors.New("no class defined")
+	}
+	p := &PluginState{}
+	for name, src := range exprs {
+		expr, err := match.Compile(src)
+		if err != nil {
+			return nil, fmt.Errorf("class %s: %w", name, err)
+		}
+		p.classes = append(p.classes, class{name: name, expr: expr})
+	}
+	sort.Slice(p.classes, func(i, j int) bool { return p.classes[i].name < p.classes[j].name })
+	log.Infof("loaded %d classes", len(p.classes))
+	return p, nil
+}
+
+// assign adds the request to the classes it matches
+func (p *PluginState) assign(state *handler.PropagateState, matches func(*match.Expr) bool) {
+	var matched []string
+	for _, c := range p.classes {
+		if matches(c.expr) {
+			matched = append(matched, c.name)
+		}
+	}
+	for _, name := range matched {
+		state.AddClass(name)
+	}
+}
+
+// Handler6 handles DHCPv6 packets for the class plugin
+func (p *PluginState) Handler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	p.assign(state, func(e *match.Expr) bool { return e.Match6(state, req) })
+	log.Debugf("request classes: %v", state.Classes)
+	return resp, false
+}
+
+// Handler4 handles DHCPv4 packets for the class plugin
+func (p *PluginState) Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	p.assign(state, func(e *match.Expr) bool { return e.Match4(state, req) })
+	log.WithField(logger.FieldMAC, req.ClientHWAddr.String()).Debugf("request classes: %v", state.Classes)
+	return resp, false
+}
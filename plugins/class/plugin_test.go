@@ -0,0 +1,50 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package class
+
+import (
+	"net"
+	"testing"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler4(t *testing.T) {
+	h, err := setup4(&config.PluginConfig{Name: pluginName, Value: map[string]interface{}{
+		"pxe":    `vendor_class startswith "PXEClient"`,
+		"phones": `oui == "00:1b:54"`,
+		// classes from the same plugin are not visible
+		"pxe-phones": `class == "pxe" and class == "phones"`,
+		"lab":        `class == "lab"`,
+	}})
+	require.NoError(t, err)
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x00, 0x1b, 0x54, 3, 4, 5},
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00000")))
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	state := &handler.PropagateState{Classes: []string{"lab"}}
+	result, stop := h(state, req, resp)
+	assert.Equal(t, resp, result)
+	assert.False(t, stop)
+	assert.Equal(t, []string{"lab", "phones", "pxe"}, state.Classes)
+}
+
+func TestSetupErrors(t *testing.T) {
+	for _, pc := range []*config.PluginConfig{
+		{Name: pluginName, Args: []string{"pxe"}},
+		{Name: pluginName, Value: map[string]interface{}{}},
+		{Name: pluginName, Value: map[string]interface{}{"pxe": `vendor_class startswith`}},
+		{Name: pluginName, Value: map[string]interface{}{"pxe": []interface{}{"a", "b"}}},
+	} {
+		_, err := setup6(pc)
+		assert.Error(t, err, pc)
+	}
+}
@@ -22,26 +22,30 @@ var Plugin = plugins.Plugin{
 	Name:   "dns",
 	Setup6: setup6,
 	Setup4: setup4,
+	Args: []plugins.Arg{
+		{Name: "resolver", Type: "IP", Help: "address of a DNS resolver", Repeated: true},
+	},
 }
 
-var (
-	dnsServers6 []net.IP
-	dnsServers4 []net.IP
-)
+// PluginState is the data held by an instance of the dns plugin
+type PluginState struct {
+	Servers []net.IP
+}
 
 func setup6(args ...string) (handler.Handler6, error) {
 	if len(args) < 1 {
 		return nil, errors.New("need at least one DNS server")
 	}
+	var p PluginState
 	for _, arg := range args {
 		server := net.ParseIP(arg)
 		if server.To16() == nil {
-			return Handler6, errors.New("expected an DNS server address, got: " + arg)
+			return nil, errors.New("expected an DNS server address, got: " + arg)
 		}
-		dnsServers6 = append(dnsServers6, server)
+		p.Servers = append(p.Servers, server)
 	}
-	log.Infof("loaded %d DNS servers.", len(dnsServers6))
-	return Handler6, nil
+	log.Infof("loaded %d DNS servers.", len(p.Servers))
+	return p.Handler6, nil
 }
 
 func setup4(args ...string) (handler.Handler4, error) {
@@ -49,19 +53,20 @@ func setup4(args ...string) (handler.Handler4, error) {
 	if len(args) < 1 {
 		return nil, errors.New("need at least one DNS server")
 	}
+	var p PluginState
 	for _, arg := range args {
 		DNSServer := net.ParseIP(arg)
 		if DNSServer.To4() == nil {
-			return Handler4, errors.New("expected an DNS server address, got: " + arg)
+			return nil, errors.New("expected an DNS server address, got: " + arg)
 		}
-		dnsServers4 = append(dnsServers4, DNSServer)
+		p.Servers = append(p.Servers, DNSServer)
 	}
-	log.Infof("loaded %d DNS servers.", len(dnsServers4))
-	return Handler4, nil
+	log.Infof("loaded %d DNS servers.", len(p.Servers))
+	return p.Handler4, nil
 }
 
 // Handler6 handles DHCPv6 packets for the dns plugin
-func Handler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+func (p *PluginState) Handler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 	decap, err := req.GetInnerMessage()
 	if err != nil {
 		log.Errorf("Could not decapsulate relayed message, aborting: %v", err)
@@ -69,15 +74,15 @@ func Handler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DH
 	}
 
 	if decap.IsOptionRequested(dhcpv6.OptionDNSRecursiveNameServer) {
-		resp.UpdateOption(dhcpv6.OptDNS(dnsServers6...))
+		resp.UpdateOption(dhcpv6.OptDNS(p.Servers...))
 	}
 	return resp, false
 }
 
 //Handler4 handles DHCPv4 packets for the dns plugin
-func Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+func (p *PluginState) Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	if req.IsOptionRequested(dhcpv4.OptionDomainNameServer) {
-		resp.Options.Update(dhcpv4.OptDNS(dnsServers4...))
+		resp.Options.Update(dhcpv4.OptDNS(p.Servers...))
 	}
 	return resp, false
 }
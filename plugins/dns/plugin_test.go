@@ -8,6 +8,7 @@ import (
 	"net"
 	"testing"
 
+	"github.com/coredhcp/coredhcp/handler"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 )
@@ -26,12 +27,12 @@ func TestAddServer6(t *testing.T) {
 	}
 	stub.MessageType = dhcpv6.MessageTypeReply
 
-	dnsServers6 = []net.IP{
+	p := PluginState{Servers: []net.IP{
 		net.ParseIP("2001:db8::1"),
 		net.ParseIP("2001:db8::3"),
-	}
+	}}
 
-	resp, stop := Handler6(req, stub)
+	resp, stop := p.Handler6(&handler.PropagateState{}, req, stub)
 	if resp == nil {
 		t.Fatal("plugin did not return a message")
 	}
@@ -46,12 +47,12 @@ func TestAddServer6(t *testing.T) {
 	foundServers := resp.(*dhcpv6.Message).Options.DNS()
 	// XXX: is enforcing the order relevant here ?
 	for i, srv := range foundServers {
-		if !srv.Equal(dnsServers6[i]) {
-			t.Errorf("Found server %s, expected %s", srv, dnsServers6[i])
+		if !srv.Equal(p.Servers[i]) {
+			t.Errorf("Found server %s, expected %s", srv, p.Servers[i])
 		}
 	}
-	if len(foundServers) != len(dnsServers6) {
-		t.Errorf("Found %d servers, expected %d", len(foundServers), len(dnsServers6))
+	if len(foundServers) != len(p.Servers) {
+		t.Errorf("Found %d servers, expected %d", len(foundServers), len(p.Servers))
 	}
 }
 
@@ -69,11 +70,11 @@ func TestNotRequested6(t *testing.T) {
 	}
 	stub.MessageType = dhcpv6.MessageTypeReply
 
-	dnsServers6 = []net.IP{
+	p := PluginState{Servers: []net.IP{
 		net.ParseIP("2001:db8::1"),
-	}
+	}}
 
-	resp, stop := Handler6(req, stub)
+	resp, stop := p.Handler6(&handler.PropagateState{}, req, stub)
 	if resp == nil {
 		t.Fatal("plugin did not return a message")
 	}
@@ -97,12 +98,12 @@ func TestAddServer4(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	dnsServers4 = []net.IP{
+	p := PluginState{Servers: []net.IP{
 		net.ParseIP("192.0.2.1"),
 		net.ParseIP("192.0.2.3"),
-	}
+	}}
 
-	resp, stop := Handler4(req, stub)
+	resp, stop := p.Handler4(&handler.PropagateState{}, req, stub)
 	if resp == nil {
 		t.Fatal("plugin did not return a message")
 	}
@@ -111,12 +112,12 @@ func TestAddServer4(t *testing.T) {
 	}
 	servers := resp.DNS()
 	for i, srv := range servers {
-		if !srv.Equal(dnsServers4[i]) {
-			t.Errorf("Found server %s, expected %s", srv, dnsServers4[i])
+		if !srv.Equal(p.Servers[i]) {
+			t.Errorf("Found server %s, expected %s", srv, p.Servers[i])
 		}
 	}
-	if len(servers) != len(dnsServers4) {
-		t.Errorf("Found %d servers, expected %d", len(servers), len(dnsServers4))
+	if len(servers) != len(p.Servers) {
+		t.Errorf("Found %d servers, expected %d", len(servers), len(p.Servers))
 	}
 }
 
@@ -130,12 +131,12 @@ func TestNotRequested4(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	dnsServers4 = []net.IP{
+	p := PluginState{Servers: []net.IP{
 		net.ParseIP("192.0.2.1"),
-	}
+	}}
 	req.UpdateOption(dhcpv4.OptParameterRequestList(dhcpv4.OptionBroadcastAddress))
 
-	resp, stop := Handler4(req, stub)
+	resp, stop := p.Handler4(&handler.PropagateState{}, req, stub)
 	if resp == nil {
 		t.Fatal("plugin did not return a message")
 	}
@@ -74,6 +74,11 @@ var Plugin = plugins.Plugin{
 // `exampleHandler6` function. Such function will be called for every DHCPv6
 // packet that the server receives. Remember that a handler may not be called
 // for each packet, if the handler chain is interrupted before reaching it.
+// The setup function is called once for each time the plugin appears in the
+// configuration. Any state derived from `args` should be kept in a value
+// created here, for instance a struct whose method is returned as the handler,
+// and not in package variables: otherwise the instances would overwrite each
+// other's configuration.
 func setup6(args ...string) (handler.Handler6, error) {
 	log.Printf("loaded plugin for DHCPv6.")
 	return exampleHandler6, nil
@@ -87,9 +92,10 @@ func setup4(args ...string) (handler.Handler4, error) {
 }
 
 // exampleHandler6 handles DHCPv6 packets for the example plugin. It implements
-// the `handler.Handler6` interface. The input arguments are the request packet
-// that the server received from a client, and the response packet that has been
-// computed so far. This function returns the response packet to be sent back to
+// the `handler.Handler6` interface. The input arguments are the state shared
+// by the plugins handling this packet (eg. the interface it was received on),
+// the request packet that the server received from a client, and the response
+// packet that has been computed so far. This function returns the response packet to be sent back to
 // the client, and a boolean.
 // The response can be either the same response packet received as input, a
 // modified response packet, or nil. If nil, the server will not reply to the
@@ -99,7 +105,7 @@ func setup4(args ...string) (handler.Handler4, error) {
 // respond to the client (or drop the response, if nil). If `false`, the server
 // will call the next plugin in the chan, using the returned response packet as
 // input for the next plugin.
-func exampleHandler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+func exampleHandler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 	log.Printf("received DHCPv6 packet: %s", req.Summary())
 	// return the unmodified response, and false. This means that the next
 	// plugin in the chain will be called, and the unmodified response packet
@@ -109,7 +115,7 @@ func exampleHandler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 
 // exampleHandler4 behaves like exampleHandler6, but for DHCPv4 packets. It
 // implements the `handler.Handler4` interface.
-func exampleHandler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+func exampleHandler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	log.Printf("received DHCPv4 packet: %s", req.Summary())
 	// return the unmodified response, and false. This means that the next
 	// plugin in the chain will be called, and the unmodified response packet
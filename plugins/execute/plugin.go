@@ -1,52 +1,434 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
 package execute
 
+// This plugin runs an external command (a hook) for every request, or for
+// every lease event published by the lease plugins.
+//
+// The command receives the details of the client in its environment:
+//  COREDHCP_MSGTYPE    type of the request, eg. REQUEST or SOLICIT
+//  COREDHCP_MAC        client hardware address
+//  COREDHCP_DUID       client DUID (DHCPv6 only)
+//  COREDHCP_IP         address leased to the client by the previous plugins
+//  COREDHCP_HOSTNAME   host name sent by the client
+//  COREDHCP_INTERFACE  interface the request was received on
+// In events mode, COREDHCP_MSGTYPE is not set, and the following are set too:
+//  COREDHCP_EVENT      granted, renewed, released, declined or expired
+//  COREDHCP_PLUGIN     plugin that published the event
+//  COREDHCP_PREFIX     delegated prefix (DHCPv6 only)
+//  COREDHCP_EXPIRY     lease expiry time, in RFC3339 format
+//
+// Arguments: <command> [<mode> [<timeout>]]
+// The mode is one of:
+//  async   run the command for each request, without waiting for it (default)
+//  sync    run the command for each request, and wait for it to finish. Every
+//          line of its standard output sets an option of the response, in the
+//          format `<code> <kind> <value>`, where kind is one of string, hex, ip
+//          (a comma-separated list of addresses), uint8, uint16 or uint32
+//  events  run the command for each lease event (see the `events` package)
+// The command is killed if it runs for longer than the timeout (default 2s).
+//
+// Example configuration of the `execute` plugin:
+//
+// server4:
+//   plugins:
+//     - range: leases.txt 10.10.10.100 10.10.10.200 60s
+//     - execute: /etc/coredhcp/hooks/options.sh sync 500ms
+//     - execute: /etc/coredhcp/hooks/notify.sh events
+//
+// where options.sh could send the client its own NTP server:
+//
+//  #!/bin/sh
+//  echo "42 ip 10.10.10.1"
+
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coredhcp/coredhcp/events"
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/sirupsen/logrus"
 )
 
 var log = logger.GetLogger("plugins/execute")
 
+const pluginName = "execute"
+
+// Plugin wraps plugin registration information
 var Plugin = plugins.Plugin{
-	Name:   "execute",
+	Name:   pluginName,
 	Setup6: setup6,
 	Setup4: setup4,
+	Args: []plugins.Arg{
+		{Name: "command", Type: "path", Help: "command to run"},
+		{Name: "mode", Type: "async, sync or events", Help: "when to run the command, and whether to wait for it", Optional: true},
+		{Name: "timeout", Type: "duration", Help: "time after which the command is killed", Optional: true},
+	},
 }
 
+// Execution modes
+const (
+	ModeAsync  = "async"
+	ModeSync   = "sync"
+	ModeEvents = "events"
+)
+
+// DefaultTimeout is the time a command can run for, unless configured
+// otherwise
+const DefaultTimeout = 2 * time.Second
+
+// maxRunning bounds the number of asynchronous commands running at the same
+// time for an instance of the plugin. Commands started beyond that are
+// skipped, so that a hung command doesn't pile up processes.
+const maxRunning = 32
+
+// maxOutput bounds the amount of standard output read from a sync command
+const maxOutput = 64 * 1024
+
+// PluginState is the data held by an instance of the execute plugin
 type PluginState struct {
-	Script string
+	Command string
+	Mode    string
+	Timeout time.Duration
+	running chan struct{}
 }
-func setup6(args ...string) (handler.Handler6, error) {
-	var (
-		p   PluginState
-	)
 
-	/* This plugin is deprecated */
+func parseArgs(args ...string) (*PluginState, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return nil, fmt.Errorf("want arguments: <command> [<mode> [<timeout>]], got %d arguments", len(args))
+	}
+	if args[0] == "" {
+		return nil, errors.New("command cannot be empty")
+	}
+	p := PluginState{
+		Command: args[0],
+		Mode:    ModeAsync,
+		Timeout: DefaultTimeout,
+		running: make(chan struct{}, maxRunning),
+	}
+	if len(args) > 1 {
+		switch args[1] {
+		case ModeAsync, ModeSync, ModeEvents:
+			p.Mode = args[1]
+		default:
+			return nil, fmt.Errorf("invalid mode '%s', want one of %s, %s or %s", args[1], ModeAsync, ModeSync, ModeEvents)
+		}
+	}
+	if len(args) > 2 {
+		timeout, err := time.ParseDuration(args[2])
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout: %v", args[2])
+		}
+		p.Timeout = timeout
+	}
+	return &p, nil
+}
 
-	return p.executeHandler6, nil
+func setup6(args ...string) (handler.Handler6, error) {
+	p, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	if p.Mode == ModeEvents && !plugins.DryRun() {
+		events.Subscribe(pluginName+" "+p.Command, events.SinkFunc(func(ev *events.Event) error {
+			if ev.DUID == "" {
+				// not a DHCPv6 lease
+				return nil
+			}
+			return p.runEvent(ev)
+		}))
+	}
+	log.Printf("loaded plugin for DHCPv6, running %s in %s mode", p.Command, p.Mode)
+	return p.Handler6, nil
 }
 
 func setup4(args ...string) (handler.Handler4, error) {
+	p, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	if p.Mode == ModeEvents && !plugins.DryRun() {
+		events.Subscribe(pluginName+" "+p.Command, events.SinkFunc(func(ev *events.Event) error {
+			if ev.DUID != "" {
+				return nil
+			}
+			return p.runEvent(ev)
+		}))
+	}
+	log.Printf("loaded plugin for DHCPv4, running %s in %s mode", p.Command, p.Mode)
+	return p.Handler4, nil
+}
 
-	var (
-		p   PluginState
-	)
-	/* This plugin is deprecated */
+// run runs the command with the given extra environment, and returns what it
+// wrote to its standard output
+func (p *PluginState) run(env []string) ([]byte, error) {
+	cmd := exec.Command(p.Command)
+	cmd.Env = append(os.Environ(), env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &limitedWriter{w: &stdout, n: maxOutput}
+	cmd.Stderr = &limitedWriter{w: &stderr, n: maxOutput}
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("cannot start %s: %w", p.Command, err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	timer := time.NewTimer(p.Timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, fmt.Errorf("%s failed: %w (stderr: %q)", p.Command, err, strings.TrimSpace(stderr.String()))
+		}
+		return stdout.Bytes(), nil
+	case <-timer.C:
+		// kill the children too, they would keep the output open
+		killProcessGroup(cmd)
+		<-done
+		return nil, fmt.Errorf("%s killed after %s", p.Command, p.Timeout)
+	}
+}
 
-	return p.executeHandler4, nil
+// runAsync runs the command in the background, unless too many are running
+// already
+func (p *PluginState) runAsync(clog *logrus.Entry, env []string) {
+	select {
+	case p.running <- struct{}{}:
+	default:
+		clog.Warningf("%d commands already running, skipping %s", maxRunning, p.Command)
+		return
+	}
+	go func() {
+		defer func() { <-p.running }()
+		if _, err := p.run(env); err != nil {
+			clog.Warning(err)
+		}
+	}()
 }
-func (p *PluginState) executeHandler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
-	/* This plugin is deprecated */
 
-	return resp, false
+func (p *PluginState) runEvent(ev *events.Event) error {
+	env := []string{
+		"COREDHCP_EVENT=" + string(ev.Type),
+		"COREDHCP_PLUGIN=" + ev.Plugin,
+		"COREDHCP_MAC=" + ev.MAC,
+		"COREDHCP_DUID=" + ev.DUID,
+		"COREDHCP_PREFIX=" + ev.Prefix,
+		"COREDHCP_HOSTNAME=" + ev.Hostname,
+		"COREDHCP_INTERFACE=" + ev.Interface,
+	}
+	if ev.IP != nil {
+		env = append(env, "COREDHCP_IP="+ev.IP.String())
+	}
+	if !ev.Expiry.IsZero() {
+		env = append(env, "COREDHCP_EXPIRY="+ev.Expiry.Format(time.RFC3339))
+	}
+	_, err := p.run(env)
+	return err
 }
 
-func (p *PluginState) executeHandler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+// Handler6 handles DHCPv6 packets for the execute plugin
+func (p *PluginState) Handler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	if p.Mode == ModeEvents {
+		return resp, false
+	}
+	msg, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("could not decapsulate request: %v", err)
+		return resp, false
+	}
+	env := []string{
+		"COREDHCP_MSGTYPE=" + msg.MessageType.String(),
+		"COREDHCP_INTERFACE=" + state.InterfaceName,
+	}
+	clog := log.WithFields(logrus.Fields{
+		logger.FieldInterface: state.InterfaceName,
+		logger.FieldMsgType:   msg.MessageType.String(),
+	})
+	if mac, err := dhcpv6.ExtractMAC(req); err == nil {
+		env = append(env, "COREDHCP_MAC="+mac.String())
+		clog = clog.WithField(logger.FieldMAC, mac.String())
+	}
+	if duid := msg.Options.ClientID(); duid != nil {
+		env = append(env, "COREDHCP_DUID="+hex.EncodeToString(duid.ToBytes()))
+	}
+	if fqdn := msg.Options.FQDN(); fqdn != nil && fqdn.DomainName != nil && len(fqdn.DomainName.Labels) > 0 {
+		env = append(env, "COREDHCP_HOSTNAME="+fqdn.DomainName.Labels[0])
+	}
+	if iana, ok := resp.GetOneOption(dhcpv6.OptionIANA).(*dhcpv6.OptIANA); ok {
+		if addr := iana.Options.OneAddress(); addr != nil {
+			env = append(env, "COREDHCP_IP="+addr.IPv6Addr.String())
+		}
+	}
 
-	/* This plugin is deprecated */
+	if p.Mode == ModeAsync {
+		p.runAsync(clog, env)
+		return resp, false
+	}
+	out, err := p.run(env)
+	if err != nil {
+		clog.Warning(err)
+		return resp, false
+	}
+	err = parseOutput(out, true, func(code uint16, value []byte) {
+		resp.UpdateOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionCode(code), OptionData: value})
+	})
+	if err != nil {
+		clog.Warningf("invalid output from %s: %v", p.Command, err)
+	}
+	return resp, false
+}
 
+// Handler4 handles DHCPv4 packets for the execute plugin
+func (p *PluginState) Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if p.Mode == ModeEvents {
+		return resp, false
+	}
+	env := []string{
+		"COREDHCP_MSGTYPE=" + req.MessageType().String(),
+		"COREDHCP_MAC=" + req.ClientHWAddr.String(),
+		"COREDHCP_HOSTNAME=" + req.HostName(),
+		"COREDHCP_INTERFACE=" + state.InterfaceName,
+	}
+	if resp.YourIPAddr != nil && !resp.YourIPAddr.IsUnspecified() {
+		env = append(env, "COREDHCP_IP="+resp.YourIPAddr.String())
+	}
+	clog := log.WithFields(logrus.Fields{
+		logger.FieldMAC:       req.ClientHWAddr.String(),
+		logger.FieldInterface: state.InterfaceName,
+		logger.FieldMsgType:   req.MessageType().String(),
+	})
+
+	if p.Mode == ModeAsync {
+		p.runAsync(clog, env)
+		return resp, false
+	}
+	out, err := p.run(env)
+	if err != nil {
+		clog.Warning(err)
+		return resp, false
+	}
+	err = parseOutput(out, false, func(code uint16, value []byte) {
+		resp.Options.Update(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(code), value))
+	})
+	if err != nil {
+		clog.Warningf("invalid output from %s: %v", p.Command, err)
+	}
 	return resp, false
 }
+
+// parseOutput parses the output of a sync command, calling set for each
+// option. Empty lines and lines starting with '#' are ignored. Options are
+// only set if the whole output is valid.
+func parseOutput(out []byte, ipv6 bool, set func(code uint16, value []byte)) error {
+	type option struct {
+		code  uint16
+		value []byte
+	}
+	var opts []option
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		code, value, err := parseOption(line, ipv6)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineno, err)
+		}
+		opts = append(opts, option{code, value})
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for _, o := range opts {
+		set(o.code, o.value)
+	}
+	return nil
+}
+
+// parseOption parses a line in the format `<code> <kind> <value>`
+func parseOption(line string, ipv6 bool) (uint16, []byte, error) {
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) != 3 {
+		return 0, nil, fmt.Errorf("want `<code> <kind> <value>`, got %q", line)
+	}
+	maxCode := uint64(254)
+	if ipv6 {
+		maxCode = 65535
+	}
+	code, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil || code == 0 || code > maxCode {
+		return 0, nil, fmt.Errorf("invalid option code %s", fields[0])
+	}
+	kind, text := fields[1], strings.TrimSpace(fields[2])
+	var value []byte
+	switch kind {
+	case "string":
+		value = []byte(text)
+	case "hex":
+		value, err = hex.DecodeString(strings.Replace(text, ":", "", -1))
+	case "ip":
+		for _, s := range strings.Split(text, ",") {
+			ip := net.ParseIP(strings.TrimSpace(s))
+			if !ipv6 {
+				ip = ip.To4()
+			}
+			if ip == nil {
+				return 0, nil, fmt.Errorf("invalid IP address %s", s)
+			}
+			value = append(value, ip...)
+		}
+	case "uint8", "uint16", "uint32":
+		bits, _ := strconv.Atoi(strings.TrimPrefix(kind, "uint"))
+		var n uint64
+		n, err = strconv.ParseUint(text, 10, bits)
+		value = make([]byte, 4)
+		binary.BigEndian.PutUint32(value, uint32(n))
+		value = value[4-bits/8:]
+	default:
+		return 0, nil, fmt.Errorf("unknown value kind %s", kind)
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid %s value %s", kind, text)
+	}
+	return uint16(code), value, nil
+}
+
+// limitedWriter discards everything written past the first n bytes
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	size := len(p)
+	if l.n <= 0 {
+		return size, nil
+	}
+	if len(p) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.w.Write(p)
+	l.n -= n
+	if err != nil {
+		return n, err
+	}
+	return size, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package execute
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOption(t *testing.T) {
+	for _, tt := range []struct {
+		line  string
+		ipv6  bool
+		code  uint16
+		value []byte
+	}{
+		{"15 string example.com", false, 15, []byte("example.com")},
+		{"43 hex 01:02:0a", false, 43, []byte{1, 2, 10}},
+		{"6 ip 10.0.0.1, 10.0.0.2", false, 6, []byte{10, 0, 0, 1, 10, 0, 0, 2}},
+		{"23 ip 2001:db8::1", true, 23, net.ParseIP("2001:db8::1")},
+		{"26 uint16 1500", false, 26, []byte{0x05, 0xdc}},
+		{"51 uint32 3600", false, 51, []byte{0, 0, 0x0e, 0x10}},
+		{"1000 uint8 7", true, 1000, []byte{7}},
+	} {
+		code, value, err := parseOption(tt.line, tt.ipv6)
+		if assert.NoError(t, err, tt.line) {
+			assert.Equal(t, tt.code, code, tt.line)
+			assert.Equal(t, tt.value, value, tt.line)
+		}
+	}
+
+	for _, line := range []string{
+		"15 string",
+		"0 string foo",
+		"1000 string foo",
+		"15 float 1.5",
+		"26 uint8 1500",
+		"6 ip 2001:db8::1",
+		"43 hex xyz",
+	} {
+		_, _, err := parseOption(line, false)
+		assert.Error(t, err, line)
+	}
+}
+
+func writeScript(t *testing.T, dir, body string) string {
+	path := filepath.Join(dir, "hook.sh")
+	require.NoError(t, ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755))
+	return path
+}
+
+func TestSyncHandler4(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	script := writeScript(t, dir, `
+# comments are ignored
+echo "15 string $COREDHCP_INTERFACE-$COREDHCP_IP"
+echo "26 uint16 1400"
+`)
+	h, err := setup4(script, "sync", "5s")
+	require.NoError(t, err)
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0, 1, 2, 3, 4, 5})
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp.YourIPAddr = net.IPv4(192, 0, 2, 10)
+
+	resp, stop := h(&handler.PropagateState{InterfaceName: "eth0"}, req, resp)
+	require.False(t, stop)
+	assert.Equal(t, "eth0-192.0.2.10", resp.DomainName())
+	assert.Equal(t, []byte{0x05, 0x78}, resp.Options.Get(dhcpv4.OptionInterfaceMTU))
+}
+
+func TestSyncHandler4Failure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	state := &handler.PropagateState{InterfaceName: "eth0"}
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0, 1, 2, 3, 4, 5})
+	require.NoError(t, err)
+
+	for _, body := range []string{
+		"echo '15 string foo'; exit 1",
+		"echo '15 string foo'; echo 'bogus'",
+		"echo '15 string foo'; sleep 5",
+	} {
+		script := writeScript(t, dir, body)
+		h, err := setup4(script, "sync", "100ms")
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		resp, stop := h(state, req, resp)
+		assert.False(t, stop, body)
+		assert.Nil(t, resp.Options.Get(dhcpv4.OptionDomainName), body)
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	p, err := parseArgs("/bin/true")
+	require.NoError(t, err)
+	assert.Equal(t, ModeAsync, p.Mode)
+	assert.Equal(t, DefaultTimeout, p.Timeout)
+
+	for _, args := range [][]string{
+		{},
+		{""},
+		{"/bin/true", "later"},
+		{"/bin/true", "sync", "-1s"},
+		{"/bin/true", "sync", "1s", "extra"},
+	} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
@@ -0,0 +1,22 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build !windows,!plan9
+
+package execute
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes the command the leader of a new process group, so
+// that it can be killed along with its children
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,359 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package external delegates the handling of requests to a process outside of
+// coredhcp, written in any language, listening on a unix socket.
+//
+// For every request, the plugin calls the process with JSON-RPC 2.0
+// (https://www.jsonrpc.org/specification) over the unix stream socket: each
+// message is a JSON object on a single line. The method is "dhcp4" or
+// "dhcp6", and its params describe the request and the response computed by
+// the previous plugins (see Request4 and Request6). The result tells what to
+// change in the response, or whether to drop the request (see Reply4 and
+// Reply6). Option values are hexadecimal strings. For example:
+//
+//  --> {"jsonrpc":"2.0","method":"dhcp4","id":1,"params":{"interface":"eth0",
+//       "message_type":"DISCOVER","xid":305419896,"mac":"00:11:22:33:44:55",
+//       "options":{"53":"01","55":"0103062a"},
+//       "response":{"message_type":"OFFER","yiaddr":"0.0.0.0","options":{"53":"02"}}}}
+//  <-- {"jsonrpc":"2.0","id":1,"result":{"yiaddr":"10.0.0.5","options":{"42":"0a000001"}}}
+//
+// coredhcp may send several requests before getting their responses, so the
+// process must match responses to requests by id. The connection is opened
+// when the first request is received, and opened again after any error, so the
+// process can be restarted independently of coredhcp.
+//
+// Arguments: <socket> [<timeout> [<on error>]]
+// or, as a map:
+//
+//  - external:
+//      socket: /run/policy.sock
+//      timeout: 500ms
+//      on_error: drop
+//
+// If the process doesn't answer within the timeout (default 1s), or answers
+// with an error, the request is handled as if the plugin wasn't configured
+// (on_error: continue, the default), or dropped (on_error: drop).
+package external
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logger.GetLogger("plugins/external")
+
+const pluginName = "external"
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:         pluginName,
+	Setup6:       setup6,
+	Setup4:       setup4,
+	Setup6Config: setup6Config,
+	Setup4Config: setup4Config,
+	Args: []plugins.Arg{
+		{Name: "socket", Type: "path", Help: "unix socket the external process listens on"},
+		{Name: "timeout", Type: "duration", Help: "how long to wait for an answer (default 1s)", Optional: true},
+		{Name: "on_error", Type: "continue or drop", Help: "what to do with the request when the process fails", Optional: true},
+	},
+}
+
+// DefaultTimeout is how long to wait for the external process to answer when
+// no timeout is configured
+const DefaultTimeout = time.Second
+
+// What to do with a request when the external process fails
+const (
+	OnErrorContinue = "continue"
+	OnErrorDrop     = "drop"
+)
+
+// Config is the configuration of the external plugin
+type Config struct {
+	Socket  string        `mapstructure:"socket"`
+	Timeout time.Duration `mapstructure:"timeout"`
+	OnError string        `mapstructure:"on_error"`
+}
+
+// PluginState is the data held by an instance of the external plugin
+type PluginState struct {
+	conf   Config
+	client *client
+}
+
+func parseArgs(args ...string) (*Config, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return nil, fmt.Errorf("want 1 to 3 arguments (socket, timeout, on error), got %d", len(args))
+	}
+	conf := Config{Socket: args[0]}
+	if len(args) > 1 {
+		timeout, err := time.ParseDuration(args[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+		conf.Timeout = timeout
+	}
+	if len(args) > 2 {
+		conf.OnError = args[2]
+	}
+	return &conf, nil
+}
+
+func decodeConfig(pc *config.PluginConfig) (*Config, error) {
+	if !pc.IsMap() {
+		return parseArgs(pc.Args...)
+	}
+	var conf Config
+	if err := pc.Decode(&conf); err != nil {
+		return nil, err
+	}
+	return &conf, nil
+}
+
+func newState(conf *Config) (*PluginState, error) {
+	if conf.Socket == "" {
+		return nil, errors.New("socket path cannot be empty")
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = DefaultTimeout
+	}
+	if conf.Timeout < 0 {
+		return nil, fmt.Errorf("invalid timeout: %v", conf.Timeout)
+	}
+	switch conf.OnError {
+	case "":
+		conf.OnError = OnErrorContinue
+	case OnErrorContinue, OnErrorDrop:
+	default:
+		return nil, fmt.Errorf("on_error must be %s or %s, got %q", OnErrorContinue, OnErrorDrop, conf.OnError)
+	}
+	p := &PluginState{conf: *conf, client: newClient(conf.Socket, conf.Timeout)}
+	plugins.Manage(p)
+	log.Printf("forwarding requests to %s", conf.Socket)
+	return p, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	return setup4Config(&config.PluginConfig{Name: pluginName, Args: args})
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	return setup6Config(&config.PluginConfig{Name: pluginName, Args: args})
+}
+
+func setup4Config(pc *config.PluginConfig) (handler.Handler4, error) {
+	conf, err := decodeConfig(pc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := newState(conf)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
+
+func setup6Config(pc *config.PluginConfig) (handler.Handler6, error) {
+	conf, err := decodeConfig(pc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := newState(conf)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler6, nil
+}
+
+// Close closes the connection to the external process. It implements
+// plugins.Closer.
+func (p *PluginState) Close() error {
+	return p.client.close()
+}
+
+// Health checks that the external process accepts connections. It implements
+// plugins.HealthChecker.
+func (p *PluginState) Health() error {
+	return p.client.ping()
+}
+
+// fail returns whether to drop a request the external process failed to
+// decide on
+func (p *PluginState) fail() bool {
+	return p.conf.OnError == OnErrorDrop
+}
+
+// Handler4 handles DHCPv4 packets for the external plugin
+func (p *PluginState) Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	clog := log.WithFields(logrus.Fields{
+		logger.FieldMAC:       req.ClientHWAddr.String(),
+		logger.FieldInterface: state.InterfaceName,
+	})
+	params := Request4{
+		Interface:   state.InterfaceName,
+		Classes:     state.Classes,
+		MessageType: req.MessageType().String(),
+		XID:         uint32FromXID(req.TransactionID[:]),
+		MAC:         req.ClientHWAddr.String(),
+		CIAddr:      nonZero(req.ClientIPAddr),
+		GIAddr:      nonZero(req.GatewayIPAddr),
+		Hostname:    req.HostName(),
+		Options:     options4(req.Options),
+		Response: Response4{
+			MessageType: resp.MessageType().String(),
+			YIAddr:      resp.YourIPAddr,
+			Options:     options4(resp.Options),
+		},
+	}
+	var reply Reply4
+	if err := p.client.call(Method4, &params, &reply); err != nil {
+		clog.Warningf("%s: %v", p.conf.Socket, err)
+		if p.fail() {
+			return nil, true
+		}
+		return resp, false
+	}
+	if reply.Drop {
+		clog.Debug("dropping request as asked by the external process")
+		return nil, true
+	}
+	if reply.YIAddr != nil {
+		if reply.YIAddr.To4() == nil {
+			clog.Warningf("%s: yiaddr %v is not an IPv4 address", p.conf.Socket, reply.YIAddr)
+			if p.fail() {
+				return nil, true
+			}
+			return resp, false
+		}
+		resp.YourIPAddr = reply.YIAddr.To4()
+	}
+	for _, code := range reply.DeleteOptions {
+		resp.Options.Del(dhcpv4.GenericOptionCode(code))
+	}
+	for code, value := range reply.Options {
+		if code <= 0 || code >= 255 {
+			clog.Warningf("%s: ignoring invalid option code %d", p.conf.Socket, code)
+			continue
+		}
+		resp.Options.Update(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(code), value))
+	}
+	return resp, reply.Stop
+}
+
+// Handler6 handles DHCPv6 packets for the external plugin
+func (p *PluginState) Handler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	m, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("BUG: could not decapsulate: %v", err)
+		return nil, true
+	}
+	msg, ok := resp.(*dhcpv6.Message)
+	if !ok {
+		log.Errorf("BUG: response is not a message: %T", resp)
+		return nil, true
+	}
+	params := Request6{
+		Interface:   state.InterfaceName,
+		Classes:     state.Classes,
+		MessageType: m.MessageType.String(),
+		XID:         uint32FromXID(m.TransactionID[:]),
+		Options:     options6(m.Options.Options),
+		Response: Response6{
+			MessageType: msg.MessageType.String(),
+			Options:     options6(msg.Options.Options),
+		},
+	}
+	if duid := m.Options.ClientID(); duid != nil {
+		params.DUID = duid.ToBytes()
+	}
+	if mac, err := dhcpv6.ExtractMAC(req); err == nil {
+		params.MAC = mac.String()
+	}
+	if req.IsRelay() {
+		if inner, err := dhcpv6.DecapsulateRelayIndex(req, -1); err == nil {
+			if relay, ok := inner.(*dhcpv6.RelayMessage); ok {
+				params.LinkAddress = relay.LinkAddr
+				params.PeerAddress = relay.PeerAddr
+			}
+		}
+	}
+	clog := log.WithFields(logrus.Fields{
+		logger.FieldMAC:       params.MAC,
+		logger.FieldInterface: state.InterfaceName,
+	})
+
+	var reply Reply6
+	if err := p.client.call(Method6, &params, &reply); err != nil {
+		clog.Warningf("%s: %v", p.conf.Socket, err)
+		if p.fail() {
+			return nil, true
+		}
+		return resp, false
+	}
+	if reply.Drop {
+		clog.Debug("dropping request as asked by the external process")
+		return nil, true
+	}
+	for _, code := range reply.DeleteOptions {
+		msg.Options.Del(dhcpv6.OptionCode(code))
+	}
+	for code, values := range reply.Options {
+		if code <= 0 || code > 0xffff {
+			clog.Warningf("%s: ignoring invalid option code %d", p.conf.Socket, code)
+			continue
+		}
+		msg.Options.Del(dhcpv6.OptionCode(code))
+		for _, value := range values {
+			msg.Options.Add(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionCode(code), OptionData: value})
+		}
+	}
+	return msg, reply.Stop
+}
+
+// options4 returns the options of a DHCPv4 message for a request
+func options4(opts dhcpv4.Options) map[int]Hex {
+	ret := make(map[int]Hex, len(opts))
+	for code, value := range opts {
+		ret[int(code)] = value
+	}
+	return ret
+}
+
+// options6 returns the options of a DHCPv6 message for a request
+func options6(opts []dhcpv6.Option) map[int][]Hex {
+	ret := make(map[int][]Hex, len(opts))
+	for _, opt := range opts {
+		code := int(opt.Code())
+		ret[code] = append(ret[code], opt.ToBytes())
+	}
+	return ret
+}
+
+// uint32FromXID returns a transaction ID as an integer
+func uint32FromXID(xid []byte) uint32 {
+	var ret uint32
+	for _, b := range xid {
+		ret = ret<<8 | uint32(b)
+	}
+	return ret
+}
+
+// nonZero returns ip, or nil for the unspecified address
+func nonZero(ip net.IP) net.IP {
+	if ip == nil || ip.IsUnspecified() {
+		return nil
+	}
+	return ip
+}
@@ -0,0 +1,236 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package external
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve runs a JSON-RPC server on a unix socket in dir, answering each
+// request with answer(method, params). A nil answer means no response. The
+// returned function stops the server.
+func serve(t *testing.T, dir string, answer func(method string, params json.RawMessage) interface{}) (string, func()) {
+	path := filepath.Join(dir, "policy.sock")
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	var (
+		mu    sync.Mutex
+		conns []net.Conn
+	)
+	stop := func() {
+		l.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go func() {
+				defer conn.Close()
+				sc := bufio.NewScanner(conn)
+				for sc.Scan() {
+					var req struct {
+						Method string          `json:"method"`
+						Params json.RawMessage `json:"params"`
+						ID     uint64          `json:"id"`
+					}
+					if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+						return
+					}
+					result := answer(req.Method, req.Params)
+					if result == nil {
+						continue
+					}
+					line, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result})
+					if _, err := conn.Write(append(line, '\n')); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return path, stop
+}
+
+func TestHandler4(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var (
+		mu  sync.Mutex
+		got Request4
+	)
+	path, shutdown := serve(t, dir, func(method string, params json.RawMessage) interface{} {
+		var req Request4
+		require.Equal(t, Method4, method)
+		require.NoError(t, json.Unmarshal(params, &req))
+		mu.Lock()
+		got = req
+		mu.Unlock()
+		switch req.Hostname {
+		case "drop":
+			return Reply4{Drop: true}
+		case "slow":
+			return nil
+		}
+		return Reply4{
+			YIAddr:        net.IPv4(10, 0, 0, 5),
+			Options:       map[int]Hex{42: {10, 0, 0, 1}},
+			DeleteOptions: []int{3},
+		}
+	})
+	defer shutdown()
+
+	h, err := setup4(path, "100ms")
+	require.NoError(t, err)
+	state := &handler.PropagateState{InterfaceName: "eth0", Classes: []string{"guests"}}
+	newRequest := func(hostname string) (*dhcpv4.DHCPv4, *dhcpv4.DHCPv4) {
+		req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0, 1, 2, 3, 4, 5}, dhcpv4.WithOption(dhcpv4.OptHostName(hostname)))
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithRouter(net.IPv4(10, 0, 0, 254)))
+		require.NoError(t, err)
+		return req, resp
+	}
+
+	req, resp := newRequest("host")
+	resp, stop := h(state, req, resp)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+	mu.Lock()
+	assert.Equal(t, "eth0", got.Interface)
+	assert.Equal(t, []string{"guests"}, got.Classes)
+	assert.Equal(t, "00:01:02:03:04:05", got.MAC)
+	assert.Equal(t, "DISCOVER", got.MessageType)
+	assert.Equal(t, Hex("host"), got.Options[int(dhcpv4.OptionHostName.Code())])
+	assert.Equal(t, Hex{10, 0, 0, 254}, got.Response.Options[int(dhcpv4.OptionRouter.Code())])
+	mu.Unlock()
+	assert.Equal(t, net.IPv4(10, 0, 0, 5).To4(), resp.YourIPAddr)
+	assert.Equal(t, []byte{10, 0, 0, 1}, resp.Options.Get(dhcpv4.OptionNTPServers))
+	assert.False(t, resp.Options.Has(dhcpv4.OptionRouter))
+
+	req, resp = newRequest("drop")
+	resp, stop = h(state, req, resp)
+	assert.Nil(t, resp)
+	assert.True(t, stop)
+
+	// a process that doesn't answer in time leaves the response unchanged
+	req, resp = newRequest("slow")
+	resp, stop = h(state, req, resp)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+	assert.True(t, resp.Options.Has(dhcpv4.OptionRouter))
+
+	// unless configured to drop the request
+	h, err = setup4Config(&config.PluginConfig{Name: pluginName, Value: map[string]interface{}{
+		"socket":   path,
+		"timeout":  "100ms",
+		"on_error": "drop",
+	}})
+	require.NoError(t, err)
+	req, resp = newRequest("slow")
+	resp, _ = h(state, req, resp)
+	assert.Nil(t, resp)
+}
+
+func TestHandler6(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var got Request6
+	path, shutdown := serve(t, dir, func(method string, params json.RawMessage) interface{} {
+		require.Equal(t, Method6, method)
+		require.NoError(t, json.Unmarshal(params, &got))
+		return Reply6{
+			Stop:    true,
+			Options: map[int][]Hex{int(dhcpv6.OptionDNSRecursiveNameServer): {Hex(net.ParseIP("2001:db8::53"))}},
+		}
+	})
+	defer shutdown()
+
+	h, err := setup6(path)
+	require.NoError(t, err)
+	req, err := dhcpv6.NewSolicit(net.HardwareAddr{0, 1, 2, 3, 4, 5})
+	require.NoError(t, err)
+	resp, err := dhcpv6.NewAdvertiseFromSolicit(req)
+	require.NoError(t, err)
+
+	result, stop := h(&handler.PropagateState{InterfaceName: "eth0", Classes: []string{"lab", "wired"}}, req, resp)
+	require.NotNil(t, result)
+	assert.True(t, stop)
+	assert.Equal(t, "SOLICIT", got.MessageType)
+	assert.Equal(t, []string{"lab", "wired"}, got.Classes)
+	assert.Equal(t, "ADVERTISE", got.Response.MessageType)
+	assert.Equal(t, "00:01:02:03:04:05", got.MAC)
+	assert.NotEmpty(t, got.DUID)
+	dns := result.(*dhcpv6.Message).Options.Get(dhcpv6.OptionDNSRecursiveNameServer)
+	if assert.Len(t, dns, 1) {
+		assert.Equal(t, []byte(net.ParseIP("2001:db8::53")), dns[0].ToBytes())
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.sock")
+
+	p, err := newState(&Config{Socket: path, Timeout: 100 * time.Millisecond})
+	require.NoError(t, err)
+	defer p.Close()
+	assert.Error(t, p.Health(), "nothing listens yet")
+
+	answer := func(string, json.RawMessage) interface{} { return Reply4{Stop: true} }
+	_, shutdown := serve(t, dir, answer)
+	assert.NoError(t, p.Health())
+	var reply Reply4
+	require.NoError(t, p.client.call(Method4, Request4{}, &reply))
+	assert.True(t, reply.Stop)
+
+	// restart the external process
+	shutdown()
+	_, shutdown = serve(t, dir, answer)
+	defer shutdown()
+	require.Eventually(t, func() bool {
+		return p.client.call(Method4, Request4{}, &reply) == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestParseArgs(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"/run/policy.sock", "soon"},
+		{"/run/policy.sock", "1s", "retry"},
+		{""},
+	} {
+		_, err := setup4(args...)
+		assert.Error(t, err, args)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package external
+
+import (
+	"encoding/hex"
+	"net"
+)
+
+// Methods called on the external process
+const (
+	// Method4 is called with a Request4 for every DHCPv4 request, and
+	// returns a Reply4
+	Method4 = "dhcp4"
+	// Method6 is called with a Request6 for every DHCPv6 request, and
+	// returns a Reply6
+	Method6 = "dhcp6"
+)
+
+// Hex is a byte string encoded in JSON as a hexadecimal string, without
+// separators, eg. "0a000001"
+type Hex []byte
+
+// MarshalText implements encoding.TextMarshaler
+func (h Hex) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(h)), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (h *Hex) UnmarshalText(text []byte) error {
+	b, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*h = b
+	return nil
+}
+
+// Request4 describes a DHCPv4 request, and the response computed for it by the
+// previous plugins. Classes are the classes the previous plugins put the
+// client in. Options map option codes to their raw value.
+type Request4 struct {
+	Interface   string      `json:"interface"`
+	Classes     []string    `json:"classes,omitempty"`
+	MessageType string      `json:"message_type"`
+	XID         uint32      `json:"xid"`
+	MAC         string      `json:"mac"`
+	CIAddr      net.IP      `json:"ciaddr,omitempty"`
+	GIAddr      net.IP      `json:"giaddr,omitempty"`
+	Hostname    string      `json:"hostname,omitempty"`
+	Options     map[int]Hex `json:"options"`
+	Response    Response4   `json:"response"`
+}
+
+// Response4 is the DHCPv4 response computed so far
+type Response4 struct {
+	MessageType string      `json:"message_type"`
+	YIAddr      net.IP      `json:"yiaddr,omitempty"`
+	Options     map[int]Hex `json:"options"`
+}
+
+// Reply4 is what the external process wants done with a DHCPv4 response.
+// The zero value leaves the response unchanged and lets the next plugins run.
+type Reply4 struct {
+	// Drop drops the request: no response is sent, and no other plugin runs
+	Drop bool `json:"drop,omitempty"`
+	// Stop sends the response without running the next plugins
+	Stop bool `json:"stop,omitempty"`
+	// YIAddr, if set, is the address given to the client
+	YIAddr net.IP `json:"yiaddr,omitempty"`
+	// Options are set in the response, replacing existing values
+	Options map[int]Hex `json:"options,omitempty"`
+	// DeleteOptions are removed from the response
+	DeleteOptions []int `json:"delete_options,omitempty"`
+}
+
+// Request6 describes a DHCPv6 request, and the response computed for it by the
+// previous plugins. Options map option codes to the raw values of all the
+// options with that code, as DHCPv6 options can be repeated. For relayed
+// requests, the options are those of the client message, and LinkAddress and
+// PeerAddress come from the relay closest to the client. Classes are the
+// classes the previous plugins put the client in.
+type Request6 struct {
+	Interface   string        `json:"interface"`
+	Classes     []string      `json:"classes,omitempty"`
+	MessageType string        `json:"message_type"`
+	XID         uint32        `json:"xid"`
+	MAC         string        `json:"mac,omitempty"`
+	DUID        Hex           `json:"duid,omitempty"`
+	LinkAddress net.IP        `json:"link_address,omitempty"`
+	PeerAddress net.IP        `json:"peer_address,omitempty"`
+	Options     map[int][]Hex `json:"options"`
+	Response    Response6     `json:"response"`
+}
+
+// Response6 is the DHCPv6 response computed so far
+type Response6 struct {
+	MessageType string        `json:"message_type"`
+	Options     map[int][]Hex `json:"options"`
+}
+
+// Reply6 is what the external process wants done with a DHCPv6 response.
+// The zero value leaves the response unchanged and lets the next plugins run.
+type Reply6 struct {
+	// Drop drops the request: no response is sent, and no other plugin runs
+	Drop bool `json:"drop,omitempty"`
+	// Stop sends the response without running the next plugins
+	Stop bool `json:"stop,omitempty"`
+	// Options replace all the options of the response with the same codes
+	Options map[int][]Hex `json:"options,omitempty"`
+	// DeleteOptions are removed from the response
+	DeleteOptions []int `json:"delete_options,omitempty"`
+}
@@ -0,0 +1,193 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package external
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxMessage bounds the size of a message from the external process
+const maxMessage = 1 << 20
+
+// rpcRequest is a JSON-RPC 2.0 request
+type rpcRequest struct {
+	Version string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+	ID      uint64      `json:"id"`
+}
+
+// rpcError is a JSON-RPC 2.0 error object
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("remote error %d: %s", e.Code, e.Message)
+}
+
+// rpcResponse is a JSON-RPC 2.0 response
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+	ID     uint64          `json:"id"`
+}
+
+var errClosed = errors.New("connection closed")
+
+// client is a JSON-RPC 2.0 client over a unix stream socket, one message per
+// line. Calls are multiplexed on a single connection, which is opened on the
+// first call and reopened after any failure, so that the external process can
+// be restarted independently of coredhcp.
+type client struct {
+	path    string
+	timeout time.Duration
+
+	mu      sync.Mutex
+	conn    net.Conn
+	pending map[uint64]chan *rpcResponse
+	nextID  uint64
+	closed  bool
+}
+
+func newClient(path string, timeout time.Duration) *client {
+	return &client{
+		path:    path,
+		timeout: timeout,
+		pending: make(map[uint64]chan *rpcResponse),
+	}
+}
+
+// connect opens the connection if needed. It must be called with c.mu held.
+func (c *client) connect() error {
+	if c.closed {
+		return errClosed
+	}
+	if c.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("unix", c.path, c.timeout)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	go c.read(conn)
+	return nil
+}
+
+// disconnect closes conn, and fails the calls waiting for a response on it. It
+// must be called with c.mu held.
+func (c *client) disconnect(conn net.Conn) {
+	if c.conn != conn {
+		return
+	}
+	conn.Close()
+	c.conn = nil
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// read dispatches the responses received on conn to the waiting calls
+func (c *client) read(conn net.Conn) {
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(make([]byte, 4096), maxMessage)
+	for sc.Scan() {
+		var resp rpcResponse
+		if err := json.Unmarshal(sc.Bytes(), &resp); err != nil {
+			log.Warningf("%s: ignoring malformed response: %v", c.path, err)
+			continue
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[resp.ID]
+		delete(c.pending, resp.ID)
+		c.mu.Unlock()
+		if ok {
+			ch <- &resp
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// errors are expected when the connection was closed on our side
+	if err := sc.Err(); err != nil && c.conn == conn {
+		log.Warningf("%s: %v", c.path, err)
+	}
+	c.disconnect(conn)
+}
+
+// send writes a request on the connection, and returns the channel its
+// response will be delivered on
+func (c *client) send(method string, params interface{}) (uint64, chan *rpcResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.connect(); err != nil {
+		return 0, nil, err
+	}
+	c.nextID++
+	id := c.nextID
+	line, err := json.Marshal(rpcRequest{Version: "2.0", Method: method, Params: params, ID: id})
+	if err != nil {
+		return 0, nil, err
+	}
+	ch := make(chan *rpcResponse, 1)
+	c.pending[id] = ch
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(append(line, '\n')); err != nil {
+		c.disconnect(c.conn)
+		return 0, nil, err
+	}
+	return id, ch, nil
+}
+
+// call calls method with params, and decodes its result into result
+func (c *client) call(method string, params, result interface{}) error {
+	id, ch, err := c.send(method, params)
+	if err != nil {
+		return err
+	}
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return errClosed
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		return json.Unmarshal(resp.Result, result)
+	case <-timer.C:
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return fmt.Errorf("no response after %v", c.timeout)
+	}
+}
+
+// ping checks that the external process accepts connections
+func (c *client) ping() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connect()
+}
+
+// close closes the connection; calls fail afterwards
+func (c *client) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.conn != nil {
+		c.disconnect(c.conn)
+	}
+	return nil
+}
@@ -25,10 +25,15 @@
 //
 // Optionally, when the 'autorefresh' argument is given, the plugin will try to refresh
 // the lease mapping during runtime whenever the lease file is updated.
+//
+// The IPv4 addresses of the file are reserved: the range plugin doesn't hand
+// them out to other clients, and the file is refused if one of them is leased
+// to another client.
 package file
 
 import (
 	"bytes"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -37,12 +42,16 @@ import (
 	"sync"
 	"time"
 
+	"github.com/coredhcp/coredhcp/events"
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/reservations"
 	"github.com/fsnotify/fsnotify"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/sirupsen/logrus"
 )
 
 const (
@@ -51,27 +60,34 @@ const (
 
 var log = logger.GetLogger("plugins/file")
 
+const pluginName = "file"
+
 // Plugin wraps plugin registration information
 var Plugin = plugins.Plugin{
-	Name:   "file",
+	Name:   pluginName,
 	Setup6: setup6,
 	Setup4: setup4,
+	Args: []plugins.Arg{
+		{Name: "file", Type: "path", Help: "file of static leases, one \"<MAC> <IP>\" per line"},
+		{Name: "autorefresh", Type: "keyword", Help: "reload the file when it changes", Optional: true},
+	},
+	// the clients in the file are answered right away
+	Terminal: plugins.TerminalForSome,
 }
 
-var recLock sync.RWMutex
-
-// StaticRecords holds a MAC -> IP address mapping
-var StaticRecords map[string]net.IP
-
-// DHCPv6Records and DHCPv4Records are mappings between MAC addresses in
-// form of a string, to network configurations.
-var (
-	DHCPv6Records map[string]net.IP
-	DHCPv4Records map[string]net.IP
-)
+// PluginState is the data held by an instance of the file plugin
+type PluginState struct {
+	// Rough lock for the whole plugin, held while swapping the records
+	sync.RWMutex
+	// StaticRecords holds a MAC -> IP address mapping
+	StaticRecords map[string]net.IP
+	filename      string
+	v6            bool
+	watcher       *fsnotify.Watcher
+}
 
-// LoadDHCPv4Records loads the DHCPv4Records global map with records stored on
-// the specified file. The records have to be one per line, a mac address and an
+// LoadDHCPv4Records loads a MAC -> IPv4 address mapping from the specified
+// file. The records have to be one per line, a mac address and an
 // IPv4 address.
 func LoadDHCPv4Records(filename string) (map[string]net.IP, error) {
 	log.Infof("reading leases from %s", filename)
@@ -106,8 +122,8 @@ func LoadDHCPv4Records(filename string) (map[string]net.IP, error) {
 	return records, nil
 }
 
-// LoadDHCPv6Records loads the DHCPv6Records global map with records stored on
-// the specified file. The records have to be one per line, a mac address and an
+// LoadDHCPv6Records loads a MAC -> IPv6 address mapping from the specified
+// file. The records have to be one per line, a mac address and an
 // IPv6 address.
 func LoadDHCPv6Records(filename string) (map[string]net.IP, error) {
 	log.Infof("reading leases from %s", filename)
@@ -142,7 +158,7 @@ func LoadDHCPv6Records(filename string) (map[string]net.IP, error) {
 }
 
 // Handler6 handles DHCPv6 packets for the file plugin
-func Handler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+func (p *PluginState) Handler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 	m, err := req.GetInnerMessage()
 	if err != nil {
 		log.Errorf("BUG: could not decapsulate: %v", err)
@@ -159,17 +175,47 @@ func Handler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DH
 		log.Warningf("Could not find client MAC, passing")
 		return resp, false
 	}
-	log.Debugf("looking up an IP address for MAC %s", mac.String())
+	clog := log.WithFields(logrus.Fields{
+		logger.FieldMAC:       mac.String(),
+		logger.FieldInterface: state.InterfaceName,
+	})
+	clog.Debug("looking up an IP address")
 
-	recLock.RLock()
-	defer recLock.RUnlock()
+	p.RLock()
+	defer p.RUnlock()
 
-	ipaddr, ok := StaticRecords[mac.String()]
+	ipaddr, ok := p.StaticRecords[mac.String()]
 	if !ok {
-		log.Warningf("MAC address %s is unknown", mac.String())
+		clog.Warning("MAC address is unknown")
+		return resp, false
+	}
+	clog.WithField(logger.FieldIP, ipaddr.String()).Debug("found IP address")
+
+	if evType, ok := events.TypeFromMessage6(m); ok {
+		events.Publish(events.Event{
+			Type:      evType,
+			Plugin:    pluginName,
+			MAC:       mac.String(),
+			DUID:      duidString(m.Options.ClientID()),
+			IP:        ipaddr,
+			Interface: state.InterfaceName,
+		})
+	}
+
+	switch m.Type() {
+	case dhcpv6.MessageTypeDecline:
+		clog.WithField(logger.FieldIP, ipaddr.String()).Warning("Client declined its static address, which another host uses")
+		fallthrough
+	case dhcpv6.MessageTypeRelease:
+		// static bindings stay, only acknowledge the IA
+		resp.AddOption(&dhcpv6.OptIANA{
+			IaId: m.Options.OneIANA().IaId,
+			Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+				&dhcpv6.OptStatusCode{StatusCode: iana.StatusSuccess},
+			}},
+		})
 		return resp, false
 	}
-	log.Debugf("found IP address %s for MAC %s", ipaddr, mac.String())
 
 	resp.AddOption(&dhcpv6.OptIANA{
 		IaId: m.Options.OneIANA().IaId,
@@ -185,43 +231,81 @@ func Handler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DH
 }
 
 // Handler4 handles DHCPv4 packets for the file plugin
-func Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
-	recLock.RLock()
-	defer recLock.RUnlock()
+func (p *PluginState) Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	p.RLock()
+	defer p.RUnlock()
 
-	ipaddr, ok := StaticRecords[req.ClientHWAddr.String()]
+	clog := log.WithFields(logrus.Fields{
+		logger.FieldMAC:       req.ClientHWAddr.String(),
+		logger.FieldInterface: state.InterfaceName,
+	})
+	ipaddr, ok := p.StaticRecords[req.ClientHWAddr.String()]
 	if !ok {
-		log.Warningf("MAC address %s is unknown", req.ClientHWAddr.String())
+		clog.Warning("MAC address is unknown")
 		return resp, false
 	}
 	resp.YourIPAddr = ipaddr
-	log.Debugf("found IP address %s for MAC %s", ipaddr, req.ClientHWAddr.String())
+	clog.WithField(logger.FieldIP, ipaddr.String()).Debug("found IP address")
+	if req.MessageType() == dhcpv4.MessageTypeRequest {
+		events.Publish(events.Event{
+			Type:      events.TypeFromRequest4(req),
+			Plugin:    pluginName,
+			MAC:       req.ClientHWAddr.String(),
+			IP:        ipaddr,
+			Hostname:  req.HostName(),
+			Interface: state.InterfaceName,
+		})
+	}
 	return resp, true
 }
 
+// duidString formats a client DUID for events, or returns an empty string if
+// there is none
+func duidString(duid dhcpv6.DUID) string {
+	if duid == nil {
+		return ""
+	}
+	return hex.EncodeToString(duid.ToBytes())
+}
+
 func setup6(args ...string) (handler.Handler6, error) {
-	h6, _, err := setupFile(true, args...)
-	return h6, err
+	p, err := setupFile(true, args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler6, nil
 }
 
 func setup4(args ...string) (handler.Handler4, error) {
-	_, h4, err := setupFile(false, args...)
-	return h4, err
+	p, err := setupFile(false, args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
 }
 
-func setupFile(v6 bool, args ...string) (handler.Handler6, handler.Handler4, error) {
+func setupFile(v6 bool, args ...string) (*PluginState, error) {
 	var err error
 	if len(args) < 1 {
-		return nil, nil, errors.New("need a file name")
+		return nil, errors.New("need a file name")
 	}
 	filename := args[0]
 	if filename == "" {
-		return nil, nil, errors.New("got empty file name")
+		return nil, errors.New("got empty file name")
+	}
+
+	p := &PluginState{
+		StaticRecords: make(map[string]net.IP),
+		filename:      filename,
+		v6:            v6,
+	}
+	if plugins.DryRun() {
+		return p, nil
 	}
 
 	// load initial database from lease file
-	if err = loadFromFile(v6, filename); err != nil {
-		return nil, nil, err
+	if err = p.Reload(); err != nil {
+		return nil, err
 	}
 
 	// when the 'autorefresh' argument was passed, watch the lease file for
@@ -230,53 +314,86 @@ func setupFile(v6 bool, args ...string) (handler.Handler6, handler.Handler4, err
 		// creates a new file watcher
 		watcher, err := fsnotify.NewWatcher()
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to create watcher: %w", err)
+			return nil, fmt.Errorf("failed to create watcher: %w", err)
 		}
 
 		// have file watcher watch over lease file
 		if err = watcher.Add(filename); err != nil {
-			return nil, nil, fmt.Errorf("failed to watch %s: %w", filename, err)
+			watcher.Close()
+			return nil, fmt.Errorf("failed to watch %s: %w", filename, err)
 		}
 
 		// very simple watcher on the lease file to trigger a refresh on any event
-		// on the file
+		// on the file. It stops when Close closes the watcher.
+		p.watcher = watcher
 		go func() {
 			for range watcher.Events {
-				err := loadFromFile(v6, filename)
+				err := p.Reload()
 				if err != nil {
 					log.Warningf("failed to refresh from %s: %s", filename, err)
 
 					continue
 				}
 
-				log.Infof("updated to %d leases from %s", len(StaticRecords), filename)
+				log.Infof("updated to %d leases from %s", p.count(), filename)
 			}
 		}()
 	}
 
-	log.Infof("loaded %d leases from %s", len(StaticRecords), filename)
-	return Handler6, Handler4, nil
+	plugins.Manage(p)
+	log.Infof("loaded %d leases from %s", p.count(), filename)
+	return p, nil
 }
 
-func loadFromFile(v6 bool, filename string) error {
+// Close stops watching the lease file and drops the reservations. It
+// implements plugins.Closer.
+func (p *PluginState) Close() error {
+	if !p.v6 {
+		reservations.Release(p)
+	}
+	if p.watcher == nil {
+		return nil
+	}
+	return p.watcher.Close()
+}
+
+// count returns the number of records currently loaded
+func (p *PluginState) count() int {
+	p.RLock()
+	defer p.RUnlock()
+	return len(p.StaticRecords)
+}
+
+// Reload reads the lease file again. If it cannot be read, the current
+// records are kept. It implements plugins.Reloader.
+func (p *PluginState) Reload() error {
 	var err error
 	var records map[string]net.IP
 	var protver int
-	if v6 {
+	if p.v6 {
 		protver = 6
-		records, err = LoadDHCPv6Records(filename)
+		records, err = LoadDHCPv6Records(p.filename)
 	} else {
 		protver = 4
-		records, err = LoadDHCPv4Records(filename)
+		records, err = LoadDHCPv4Records(p.filename)
 	}
 	if err != nil {
 		return fmt.Errorf("failed to load DHCPv%d records: %w", protver, err)
 	}
+	if !p.v6 {
+		addrs := make(map[string]string, len(records))
+		for mac, ip := range records {
+			addrs[ip.String()] = mac
+		}
+		if err := reservations.Reserve(p, addrs); err != nil {
+			return fmt.Errorf("failed to reserve DHCPv4 addresses: %w", err)
+		}
+	}
 
-	recLock.Lock()
-	defer recLock.Unlock()
+	p.Lock()
+	defer p.Unlock()
 
-	StaticRecords = records
+	p.StaticRecords = records
 
 	return nil
 }
@@ -5,14 +5,18 @@
 package file
 
 import (
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/plugins/reservations"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -223,10 +227,11 @@ func TestHandler4(t *testing.T) {
 		}
 		resp := &dhcpv4.DHCPv4{}
 		assert.Nil(t, resp.ClientIPAddr)
+		p := &PluginState{}
 
 		// if we handle this DHCP request, nothing should change since the lease is
 		// unknown
-		result, stop := Handler4(req, resp)
+		result, stop := p.Handler4(&handler.PropagateState{}, req, resp)
 		assert.Same(t, result, resp)
 		assert.False(t, stop)
 		assert.Nil(t, result.YourIPAddr)
@@ -244,19 +249,16 @@ func TestHandler4(t *testing.T) {
 
 		// add lease for the MAC in the lease map
 		clIPAddr := net.ParseIP("192.0.2.100")
-		StaticRecords = map[string]net.IP{
+		p := &PluginState{StaticRecords: map[string]net.IP{
 			mac: clIPAddr,
-		}
+		}}
 
 		// if we handle this DHCP request, the YourIPAddr field should be set
 		// in the result
-		result, stop := Handler4(req, resp)
+		result, stop := p.Handler4(&handler.PropagateState{}, req, resp)
 		assert.Same(t, result, resp)
 		assert.True(t, stop)
 		assert.Equal(t, clIPAddr, result.YourIPAddr)
-
-		// cleanup
-		StaticRecords = make(map[string]net.IP)
 	})
 }
 
@@ -270,10 +272,11 @@ func TestHandler6(t *testing.T) {
 		resp, err := dhcpv6.NewAdvertiseFromSolicit(req)
 		require.NoError(t, err)
 		assert.Equal(t, 0, len(resp.GetOption(dhcpv6.OptionIANA)))
+		p := &PluginState{}
 
 		// if we handle this DHCP request, nothing should change since the lease is
 		// unknown
-		result, stop := Handler6(req, resp)
+		result, stop := p.Handler6(&handler.PropagateState{}, req, resp)
 		assert.False(t, stop)
 		assert.Equal(t, 0, len(result.GetOption(dhcpv6.OptionIANA)))
 	})
@@ -290,38 +293,56 @@ func TestHandler6(t *testing.T) {
 
 		// add lease for the MAC in the lease map
 		clIPAddr := net.ParseIP("2001:db8::10:1")
-		StaticRecords = map[string]net.IP{
+		p := &PluginState{StaticRecords: map[string]net.IP{
 			mac: clIPAddr,
-		}
+		}}
 
 		// if we handle this DHCP request, there should be a specific IANA option
 		// set in the resulting response
-		result, stop := Handler6(req, resp)
+		result, stop := p.Handler6(&handler.PropagateState{}, req, resp)
 		assert.False(t, stop)
 		if assert.Equal(t, 1, len(result.GetOption(dhcpv6.OptionIANA))) {
 			opt := result.GetOneOption(dhcpv6.OptionIANA)
 			assert.Contains(t, opt.String(), "IP=2001:db8::10:1")
 		}
+	})
 
-		// cleanup
-		StaticRecords = make(map[string]net.IP)
+	t.Run("release", func(t *testing.T) {
+		mac := "11:22:33:44:55:66"
+		claddr, _ := net.ParseMAC(mac)
+		req, err := dhcpv6.NewSolicit(claddr)
+		require.NoError(t, err)
+		req.MessageType = dhcpv6.MessageTypeRelease
+		resp, err := dhcpv6.NewReplyFromMessage(req)
+		require.NoError(t, err)
+		p := &PluginState{StaticRecords: map[string]net.IP{
+			mac: net.ParseIP("2001:db8::10:1"),
+		}}
+
+		// the static binding is acknowledged, without giving out the address
+		result, _ := p.Handler6(&handler.PropagateState{}, req, resp)
+		ianas := result.(*dhcpv6.Message).Options.IANA()
+		if assert.Len(t, ianas, 1) {
+			assert.Empty(t, ianas[0].Options.Addresses())
+			assert.Equal(t, iana.StatusSuccess, ianas[0].Options.Status().StatusCode)
+		}
 	})
 }
 
 func TestSetupFile(t *testing.T) {
 	// too few arguments
-	_, _, err := setupFile(false)
+	_, err := setupFile(false)
 	assert.Error(t, err)
 
 	// empty file name
-	_, _, err = setupFile(false, "")
+	_, err = setupFile(false, "")
 	assert.Error(t, err)
 
 	// trigger error in LoadDHCPv*Records
-	_, _, err = setupFile(false, "/foo/bar")
+	_, err = setupFile(false, "/foo/bar")
 	assert.Error(t, err)
 
-	_, _, err = setupFile(true, "/foo/bar")
+	_, err = setupFile(true, "/foo/bar")
 	assert.Error(t, err)
 
 	// setup temp leases file
@@ -338,20 +359,17 @@ func TestSetupFile(t *testing.T) {
 		_, err = tmp.WriteString("11:22:33:44:55:66 2001:db8::10:2\n")
 		require.NoError(t, err)
 
-		assert.Equal(t, 0, len(StaticRecords))
-
 		// leases should show up in StaticRecords
-		_, _, err = setupFile(true, tmp.Name())
+		p, err := setupFile(true, tmp.Name())
 		if assert.NoError(t, err) {
-			assert.Equal(t, 2, len(StaticRecords))
+			assert.Equal(t, 2, len(p.StaticRecords))
 		}
 	})
 
 	t.Run("autorefresh enabled", func(t *testing.T) {
-		_, _, err = setupFile(true, tmp.Name(), autoRefreshArg)
-		if assert.NoError(t, err) {
-			assert.Equal(t, 2, len(StaticRecords))
-		}
+		p, err := setupFile(true, tmp.Name(), autoRefreshArg)
+		require.NoError(t, err)
+		assert.Equal(t, 2, len(p.StaticRecords))
 		// we add more leases to the file
 		// this should trigger an event to refresh the leases database
 		// without calling setupFile again
@@ -361,9 +379,90 @@ func TestSetupFile(t *testing.T) {
 		time.Sleep(time.Millisecond * 100)
 		// an additional record should show up in the database
 		// but we should respect the locking first
-		recLock.RLock()
-		defer recLock.RUnlock()
+		p.RLock()
+		defer p.RUnlock()
 
-		assert.Equal(t, 3, len(StaticRecords))
+		assert.Equal(t, 3, len(p.StaticRecords))
 	})
 }
+
+func TestReloadAndClose(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "test_plugin_file")
+	require.NoError(t, err)
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	_, err = tmp.WriteString("00:11:22:33:44:55 192.0.2.100\n")
+	require.NoError(t, err)
+
+	p, err := setupFile(false, tmp.Name())
+	require.NoError(t, err)
+	assert.Equal(t, 1, p.count())
+
+	// without autorefresh, new leases only show up on reload
+	_, err = tmp.WriteString("11:22:33:44:55:66 192.0.2.101\n")
+	require.NoError(t, err)
+	assert.Equal(t, 1, p.count())
+	require.NoError(t, p.Reload())
+	assert.Equal(t, 2, p.count())
+
+	// a broken file keeps the current records
+	_, err = tmp.WriteString("garbage\n")
+	require.NoError(t, err)
+	assert.Error(t, p.Reload())
+	assert.Equal(t, 2, p.count())
+	assert.NoError(t, p.Close())
+
+	// closing stops the watcher
+	require.NoError(t, tmp.Truncate(0))
+	_, err = tmp.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	p, err = setupFile(false, tmp.Name(), autoRefreshArg)
+	require.NoError(t, err)
+	require.NoError(t, p.Close())
+	_, err = tmp.WriteString("00:11:22:33:44:55 192.0.2.100\n")
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, 0, p.count())
+}
+
+// leasedPool leases all its addresses to one client
+type leasedPool string
+
+func (l leasedPool) LeasedTo(ip net.IP) (string, bool) {
+	return string(l), true
+}
+
+func TestReservations(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "test_plugin_file")
+	require.NoError(t, err)
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	_, err = tmp.WriteString("00:11:22:33:44:55 192.0.2.100\n")
+	require.NoError(t, err)
+
+	p, err := setupFile(false, tmp.Name())
+	require.NoError(t, err)
+	mac, ok := reservations.ReservedFor(net.ParseIP("192.0.2.100"))
+	assert.True(t, ok)
+	assert.Equal(t, "00:11:22:33:44:55", mac)
+
+	// an address leased to another client can't be reserved
+	pool := leasedPool("11:22:33:44:55:66")
+	require.NoError(t, reservations.AddPool(pool, "leases.txt", []reservations.Range{
+		{Start: net.ParseIP("192.0.2.101"), End: net.ParseIP("192.0.2.200")},
+	}))
+	defer reservations.RemovePool(pool)
+	_, err = tmp.WriteString("00:11:22:33:44:56 192.0.2.101\n")
+	require.NoError(t, err)
+	assert.Error(t, p.Reload())
+	_, ok = reservations.ReservedFor(net.ParseIP("192.0.2.101"))
+	assert.False(t, ok)
+
+	assert.NoError(t, p.Close())
+	_, ok = reservations.ReservedFor(net.ParseIP("192.0.2.100"))
+	assert.False(t, ok)
+}
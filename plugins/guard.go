@@ -0,0 +1,89 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/match"
+)
+
+// Step6 is a plugin in the DHCPv6 chain of a server: its handler, and the
+// guard restricting the requests the handler runs for. The server skips the
+// handler for the requests the guard doesn't match, passing them on to the
+// next plugin unchanged.
+type Step6 struct {
+	Name string
+	// Guard is nil when the plugin runs for all the requests
+	Guard   *match.Expr
+	Handler handler.Handler6
+}
+
+// Step4 is the DHCPv4 version of Step6
+type Step4 struct {
+	Name    string
+	Guard   *match.Expr
+	Handler handler.Handler4
+}
+
+// compileGuard compiles the guard of a plugin: its `when` expression and its
+// condition, which must both match. It returns nil if the plugin has neither.
+func compileGuard(conf *config.PluginConfig) (*match.Expr, error) {
+	var when *match.Expr
+	if conf.When != "" {
+		var err error
+		if when, err = match.Compile(conf.When); err != nil {
+			return nil, fmt.Errorf("when: %w", err)
+		}
+	}
+	cond, err := compileCondition(conf.Condition)
+	if err != nil {
+		return nil, fmt.Errorf("condition: %w", err)
+	}
+	return match.And(when, cond), nil
+}
+
+// compileCondition builds the expression matching a condition
+func compileCondition(cond *config.Condition) (*match.Expr, error) {
+	if cond == nil {
+		return nil, nil
+	}
+	var all []*match.Expr
+	anyOf := func(field, op string, values []string) error {
+		var exprs []*match.Expr
+		for _, v := range values {
+			e, err := match.Compare(field, op, v)
+			if err != nil {
+				return err
+			}
+			exprs = append(exprs, e)
+		}
+		if e := match.Or(exprs...); e != nil {
+			all = append(all, e)
+		}
+		return nil
+	}
+	requested := make([]string, 0, len(cond.Requested))
+	for _, code := range cond.Requested {
+		requested = append(requested, strconv.Itoa(code))
+	}
+	for _, f := range []struct {
+		field, op string
+		values    []string
+	}{
+		{"interface", "==", cond.Interface},
+		{"giaddr", "in", cond.GIAddr},
+		{"message_type", "==", cond.MessageType},
+		{"requested", "==", requested},
+	} {
+		if err := anyOf(f.field, f.op, f.values); err != nil {
+			return nil, err
+		}
+	}
+	return match.And(all...), nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"net"
+	"testing"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuards(t *testing.T) {
+	plugin := Plugin{
+		Name: "test_guards",
+		Setup4: func(args ...string) (handler.Handler4, error) {
+			return func(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+				resp.UpdateOption(dhcpv4.OptDomainName(resp.DomainName() + args[0]))
+				return resp, false
+			}, nil
+		},
+	}
+	require.NoError(t, RegisterPlugin(&plugin))
+	defer delete(RegisteredPlugins, plugin.Name)
+
+	conf := config.New()
+	conf.Server4 = &config.ServerConfig{
+		Plugins: []config.PluginConfig{
+			{Name: "test_guards", Args: []string{"a"}},
+			{Name: "test_guards", Args: []string{"v"}, When: `class == "voip"`},
+			{Name: "test_guards", Args: []string{"p"}, Condition: &config.Condition{
+				Interface:   []string{"eth1", "eth2"},
+				GIAddr:      []string{"10.1.0.0/16"},
+				MessageType: []string{"DISCOVER"},
+				Requested:   []int{66, 67},
+			}},
+			{Name: "test_guards", Args: []string{"b"}, When: `class == "voip"`, Condition: &config.Condition{
+				Interface: []string{"eth1"},
+			}},
+		},
+	}
+	steps, _, err := LoadPlugins(conf)
+	require.NoError(t, err)
+	require.Len(t, steps, 4)
+	assert.Nil(t, steps[0].Guard)
+	assert.Equal(t, "test_guards", steps[1].Name)
+
+	// run runs the plugins like the server does
+	run := func(intf string, giaddr net.IP, requested []dhcpv4.OptionCode, classes ...string) string {
+		req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0, 1, 2, 3, 4, 5}, dhcpv4.WithRequestedOptions(requested...))
+		require.NoError(t, err)
+		req.GatewayIPAddr = giaddr
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		state := &handler.PropagateState{InterfaceName: intf, Classes: classes}
+		for _, step := range steps {
+			if step.Guard == nil || step.Guard.Match4(state, req) {
+				resp, _ = step.Handler(state, req, resp)
+			}
+		}
+		return resp.DomainName()
+	}
+	pxe := []dhcpv4.OptionCode{dhcpv4.OptionTFTPServerName, dhcpv4.OptionBootfileName}
+	relay := net.IPv4(10, 1, 2, 3)
+	assert.Equal(t, "a", run("eth0", relay, pxe))
+	assert.Equal(t, "av", run("eth0", relay, pxe, "voip"))
+	assert.Equal(t, "ap", run("eth2", relay, pxe))
+	assert.Equal(t, "a", run("eth2", net.IPv4(10, 3, 2, 3), pxe))
+	assert.Equal(t, "a", run("eth2", relay, []dhcpv4.OptionCode{dhcpv4.OptionRouter}))
+	assert.Equal(t, "avpb", run("eth1", relay, pxe, "voip"))
+
+	for _, pc := range []config.PluginConfig{
+		{Name: "test_guards", When: `class = "voip"`},
+		{Name: "test_guards", Condition: &config.Condition{GIAddr: []string{"10.1.0.0/33"}}},
+		{Name: "test_guards", Condition: &config.Condition{MessageType: []string{"DISCOVERY"}}},
+	} {
+		conf.Server4.Plugins = []config.PluginConfig{pc}
+		_, _, err = LoadPlugins(conf)
+		assert.Error(t, err, pc)
+	}
+}
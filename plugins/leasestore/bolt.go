@@ -0,0 +1,144 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package leasestore
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltBucket is the bucket holding the leases, by key
+var boltBucket = []byte("leases")
+
+// Bolt is a store keeping the leases in an embedded bbolt database. Each
+// change is a transaction written to disk before the method returns.
+type Bolt struct {
+	db *bolt.DB
+}
+
+// boltValue is how a lease is encoded in the database
+type boltValue struct {
+	Address string    `json:"address"`
+	Expires time.Time `json:"expires"`
+}
+
+func openBolt(filename string) (Store, error) {
+	return OpenBolt(filename)
+}
+
+// OpenBolt opens a bbolt store, creating the database if it doesn't exist.
+// The database is locked while it is open.
+func OpenBolt(filename string) (*Bolt, error) {
+	db, err := bolt.Open(filename, 0640, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Bolt{db: db}, nil
+}
+
+func decodeBolt(key, value []byte) (*Lease, error) {
+	var v boltValue
+	if err := json.Unmarshal(value, &v); err != nil {
+		return nil, err
+	}
+	return &Lease{Key: string(key), Address: v.Address, Expires: v.Expires}, nil
+}
+
+// Get implements Store
+func (b *Bolt) Get(key string) (*Lease, error) {
+	var l *Lease
+	err := b.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltBucket).Get([]byte(key))
+		if value == nil {
+			return ErrNotFound
+		}
+		var err error
+		l, err = decodeBolt([]byte(key), value)
+		return err
+	})
+	return l, err
+}
+
+// Put implements Store
+func (b *Bolt) Put(l *Lease) error {
+	if err := check(l); err != nil {
+		return err
+	}
+	l = copyLease(l)
+	value, err := json.Marshal(boltValue{Address: l.Address, Expires: l.Expires.UTC()})
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(l.Key), value)
+	})
+}
+
+// Delete implements Store
+func (b *Bolt) Delete(key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+}
+
+// Iterate implements Store
+func (b *Bolt) Iterate(fn func(l *Lease) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			l, err := decodeBolt(k, v)
+			if err != nil {
+				return err
+			}
+			return fn(l)
+		})
+	})
+}
+
+// ExpireBefore implements Store
+func (b *Bolt) ExpireBefore(t time.Time) ([]*Lease, error) {
+	var expired []*Lease
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		// deleting while iterating skips items, collect the leases first
+		err := bucket.ForEach(func(k, v []byte) error {
+			l, err := decodeBolt(k, v)
+			if err != nil {
+				return err
+			}
+			if l.Expires.Before(t) {
+				expired = append(expired, l)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, l := range expired {
+			if err := bucket.Delete([]byte(l.Key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
+// Close implements Store
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,130 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package leasestore provides the storage of leases for the plugins handing
+// them out, eg. range and prefix, so that they survive server restarts.
+//
+// A store is selected with a specification of the form [backend:]path, where
+// backend is one of:
+//
+//  text    a text file, one lease per line (the default)
+//  bolt    an embedded bbolt database
+//  memory  leases are only kept in memory, the path is ignored. For tests
+//
+// eg. "leases.txt", or "bolt:/var/lib/coredhcp/leases.db".
+package leasestore
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coredhcp/coredhcp/logger"
+)
+
+var log = logger.GetLogger("plugins/leasestore")
+
+// Lease is a stored lease
+type Lease struct {
+	// Key identifies the lease, eg. the MAC address of the client. It must
+	// not contain white space.
+	Key string
+	// Address is what is leased, eg. an IP address or a prefix, in text
+	// form. It must not contain white space.
+	Address string
+	// Expires is when the lease expires. Stores keep it to the second.
+	Expires time.Time
+}
+
+// Store is a lease storage backend. Its methods are safe for concurrent use.
+type Store interface {
+	// Get returns the lease with the given key, or ErrNotFound
+	Get(key string) (*Lease, error)
+	// Put adds a lease, or replaces the lease with the same key
+	Put(l *Lease) error
+	// Delete removes the lease with the given key. Deleting a missing lease
+	// is not an error.
+	Delete(key string) error
+	// Iterate calls fn for each lease, sorted by key, until fn returns an
+	// error, which Iterate returns. fn must not call the other methods of the
+	// store.
+	Iterate(fn func(l *Lease) error) error
+	// ExpireBefore removes the leases expiring before t, and returns them
+	ExpireBefore(t time.Time) ([]*Lease, error)
+	// Close releases the resources of the store, which can't be used
+	// afterwards
+	Close() error
+}
+
+// ErrNotFound is returned by Get for unknown keys
+var ErrNotFound = errors.New("lease not found")
+
+// DefaultBackend is the backend used when a specification doesn't name one
+const DefaultBackend = "text"
+
+// backends maps the name of a backend to the function opening a store
+var backends = map[string]func(path string) (Store, error){
+	"text":   openText,
+	"bolt":   openBolt,
+	"memory": func(string) (Store, error) { return NewMemory(), nil },
+}
+
+// Backends returns the names of the available backends
+func Backends() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseSpec splits a store specification into a backend and a path. It
+// doesn't open the store, so that the configuration can be checked.
+func ParseSpec(spec string) (backend, path string, err error) {
+	backend, path = DefaultBackend, spec
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		if _, ok := backends[spec[:i]]; ok {
+			backend, path = spec[:i], spec[i+1:]
+		}
+	}
+	if path == "" && backend != "memory" {
+		return "", "", fmt.Errorf("lease store %q: file name cannot be empty", spec)
+	}
+	return backend, path, nil
+}
+
+// Open opens the store matching a specification, creating it if needed
+func Open(spec string) (Store, error) {
+	backend, path, err := ParseSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	s, err := backends[backend](path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s lease store %s: %w", backend, path, err)
+	}
+	return s, nil
+}
+
+// check validates a lease before it is stored
+func check(l *Lease) error {
+	if l.Key == "" || strings.ContainsAny(l.Key, " \t\r\n") {
+		return fmt.Errorf("invalid lease key %q", l.Key)
+	}
+	if l.Address == "" || strings.ContainsAny(l.Address, " \t\r\n") {
+		return fmt.Errorf("invalid lease address %q", l.Address)
+	}
+	return nil
+}
+
+// copyLease returns a copy of a lease, with its expiry truncated to the second
+// like all the stores keep it
+func copyLease(l *Lease) *Lease {
+	c := *l
+	c.Expires = c.Expires.Truncate(time.Second)
+	return &c
+}
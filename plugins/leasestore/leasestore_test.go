@@ -0,0 +1,241 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package leasestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSpec(t *testing.T) {
+	for spec, want := range map[string][2]string{
+		"leases.txt":           {"text", "leases.txt"},
+		"text:leases.txt":      {"text", "leases.txt"},
+		"bolt:/var/leases.db":  {"bolt", "/var/leases.db"},
+		"memory:":              {"memory", ""},
+		"unknown:leases.txt":   {"text", "unknown:leases.txt"},
+		"/var/lib/a:b/l.txt":   {"text", "/var/lib/a:b/l.txt"},
+		"bolt:text:leases.txt": {"bolt", "text:leases.txt"},
+	} {
+		backend, path, err := ParseSpec(spec)
+		if assert.NoError(t, err, spec) {
+			assert.Equal(t, want, [2]string{backend, path}, spec)
+		}
+	}
+	for _, spec := range []string{"", "text:", "bolt:"} {
+		_, _, err := ParseSpec(spec)
+		assert.Error(t, err, spec)
+	}
+	assert.Equal(t, []string{"bolt", "memory", "text"}, Backends())
+}
+
+// testStore checks the behavior common to all the stores. reopen closes and
+// reopens the store, or returns nil if the leases don't persist.
+func testStore(t *testing.T, s Store, reopen func() Store) {
+	now := time.Now()
+	leases := []*Lease{
+		{Key: "02:00:00:00:00:02", Address: "10.0.0.2", Expires: now.Add(time.Hour)},
+		{Key: "02:00:00:00:00:01", Address: "10.0.0.1", Expires: now.Add(-time.Hour)},
+		{Key: "02:00:00:00:00:03", Address: "10.0.0.3", Expires: now.Add(-time.Minute)},
+	}
+	for _, l := range leases {
+		require.NoError(t, s.Put(l))
+	}
+	assert.Error(t, s.Put(&Lease{Key: "with space", Address: "10.0.0.4"}))
+	assert.Error(t, s.Put(&Lease{Key: "02:00:00:00:00:04"}))
+
+	l, err := s.Get("02:00:00:00:00:02")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", l.Address)
+	assert.True(t, l.Expires.Equal(now.Add(time.Hour).Truncate(time.Second)))
+	_, err = s.Get("02:00:00:00:00:04")
+	assert.Equal(t, ErrNotFound, err)
+
+	// replace a lease
+	require.NoError(t, s.Put(&Lease{Key: "02:00:00:00:00:02", Address: "10.0.0.5", Expires: now.Add(time.Hour)}))
+	l, err = s.Get("02:00:00:00:00:02")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.5", l.Address)
+
+	expired, err := s.ExpireBefore(now)
+	require.NoError(t, err)
+	if assert.Len(t, expired, 2) {
+		assert.Equal(t, "02:00:00:00:00:01", expired[0].Key)
+		assert.Equal(t, "02:00:00:00:00:03", expired[1].Key)
+	}
+	expired, err = s.ExpireBefore(now)
+	require.NoError(t, err)
+	assert.Empty(t, expired)
+
+	require.NoError(t, s.Put(&Lease{Key: "02:00:00:00:00:06", Address: "10.0.0.6", Expires: now}))
+	require.NoError(t, s.Delete("02:00:00:00:00:06"))
+	require.NoError(t, s.Delete("02:00:00:00:00:06"))
+	require.NoError(t, s.Put(&Lease{Key: "02:00:00:00:00:00", Address: "10.0.0.7", Expires: now}))
+
+	check := func(s Store) {
+		var keys []string
+		require.NoError(t, s.Iterate(func(l *Lease) error {
+			keys = append(keys, l.Key)
+			return nil
+		}))
+		assert.Equal(t, []string{"02:00:00:00:00:00", "02:00:00:00:00:02"}, keys)
+		stop := assert.AnError
+		assert.Equal(t, stop, s.Iterate(func(l *Lease) error { return stop }))
+	}
+	check(s)
+	if s = reopen(); s != nil {
+		check(s)
+		assert.NoError(t, s.Close())
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "coredhcp-leasestore")
+	require.NoError(t, err)
+	return dir
+}
+
+func TestMemory(t *testing.T) {
+	s, err := Open("memory:")
+	require.NoError(t, err)
+	testStore(t, s, func() Store { return nil })
+}
+
+func TestText(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "leases.txt")
+
+	s, err := Open(filename)
+	require.NoError(t, err)
+	testStore(t, s, func() Store {
+		require.NoError(t, s.Close())
+		s, err := Open(filename)
+		require.NoError(t, err)
+		return s
+	})
+	// the file was compacted when reopened
+	data, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"))
+}
+
+func TestBolt(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "leases.db")
+
+	s, err := Open("bolt:" + filename)
+	require.NoError(t, err)
+	testStore(t, s, func() Store {
+		require.NoError(t, s.Close())
+		s, err := Open("bolt:" + filename)
+		require.NoError(t, err)
+		return s
+	})
+}
+
+var textFile = `02:00:00:00:00:00 10.0.0.0 2000-01-01T00:00:00Z
+02:00:00:00:00:01 10.0.0.1 2000-01-01T00:00:00Z
+02:00:00:00:00:02 10.0.0.2 2000-01-01T00:00:00Z
+02:00:00:00:00:01 - 2000-01-01T00:00:01Z
+02:00:00:00:00:02 10.0.0.3 2000-01-01T01:00:00Z
+`
+
+func TestTextLoad(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "leases.txt")
+
+	// a truncated last line is ignored
+	require.NoError(t, ioutil.WriteFile(filename, []byte(textFile+"02:00:00:00:00:04 10.0.0.4 2000-01"), 0600))
+	s, err := OpenText(filename)
+	require.NoError(t, err)
+	var leases []Lease
+	require.NoError(t, s.Iterate(func(l *Lease) error {
+		leases = append(leases, *l)
+		return nil
+	}))
+	assert.Equal(t, []Lease{
+		{Key: "02:00:00:00:00:00", Address: "10.0.0.0", Expires: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Key: "02:00:00:00:00:02", Address: "10.0.0.3", Expires: time.Date(2000, 1, 1, 1, 0, 0, 0, time.UTC)},
+	}, leases)
+	require.NoError(t, s.Close())
+
+	// and the file was rewritten with the current leases only, keeping its
+	// permissions
+	data, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, `02:00:00:00:00:00 10.0.0.0 2000-01-01T00:00:00Z
+02:00:00:00:00:02 10.0.0.3 2000-01-01T01:00:00Z
+`, string(data))
+	fi, err := os.Stat(filename)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	files, err := filepath.Glob(filename + ".tmp*")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	// a complete last line is loaded even without a newline
+	require.NoError(t, ioutil.WriteFile(filename, []byte("02:00:00:00:00:04 10.0.0.4 2000-01-01T00:00:00Z"), 0600))
+	s, err = OpenText(filename)
+	require.NoError(t, err)
+	_, err = s.Get("02:00:00:00:00:04")
+	assert.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	// malformed lines are only ignored at the end
+	require.NoError(t, ioutil.WriteFile(filename, []byte("02:00:00:00:00:04 10.0.0.4 2000-01\n"+textFile), 0600))
+	_, err = OpenText(filename)
+	assert.Error(t, err)
+}
+
+func TestTextCompaction(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "leases.txt")
+
+	s, err := OpenText(filename)
+	require.NoError(t, err)
+	defer s.Close()
+	l := &Lease{Key: "02:00:00:00:00:01", Address: "10.0.0.1", Expires: time.Now()}
+	for i := 0; i < compactMinLines-1; i++ {
+		require.NoError(t, s.Put(l))
+	}
+	assert.Equal(t, compactMinLines-1, s.appended)
+	require.NoError(t, s.Put(l))
+	assert.Equal(t, 0, s.appended)
+	data, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, formatLine(copyLease(l)), string(data))
+	assert.NoError(t, s.Health())
+}
+
+func TestTextHealth(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "leases.txt")
+
+	s, err := OpenText(filename)
+	require.NoError(t, err)
+	assert.NoError(t, s.Health())
+
+	// a lease file replaced behind our back can't be written to anymore
+	require.NoError(t, os.Remove(filename))
+	require.NoError(t, ioutil.WriteFile(filename, nil, 0644))
+	assert.Error(t, s.Health())
+
+	assert.NoError(t, s.Close())
+	assert.NoError(t, s.Close())
+	assert.EqualError(t, s.Health(), "lease file is closed")
+	assert.Error(t, s.Put(&Lease{Key: "k", Address: "a"}))
+}
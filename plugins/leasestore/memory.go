@@ -0,0 +1,106 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package leasestore
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory is a store keeping the leases in memory only
+type Memory struct {
+	sync.Mutex
+	leases map[string]*Lease
+}
+
+// NewMemory returns an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{leases: make(map[string]*Lease)}
+}
+
+// Get implements Store
+func (m *Memory) Get(key string) (*Lease, error) {
+	m.Lock()
+	defer m.Unlock()
+	l, ok := m.leases[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyLease(l), nil
+}
+
+// Put implements Store
+func (m *Memory) Put(l *Lease) error {
+	if err := check(l); err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.leases[l.Key] = copyLease(l)
+	return nil
+}
+
+// Delete implements Store
+func (m *Memory) Delete(key string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.leases, key)
+	return nil
+}
+
+// Iterate implements Store
+func (m *Memory) Iterate(fn func(l *Lease) error) error {
+	m.Lock()
+	defer m.Unlock()
+	return iterate(m.leases, fn)
+}
+
+// ExpireBefore implements Store
+func (m *Memory) ExpireBefore(t time.Time) ([]*Lease, error) {
+	m.Lock()
+	defer m.Unlock()
+	expired := expiredBefore(m.leases, t)
+	for _, l := range expired {
+		delete(m.leases, l.Key)
+	}
+	return expired, nil
+}
+
+// Close implements Store
+func (m *Memory) Close() error {
+	return nil
+}
+
+// sortedKeys returns the keys of a lease map in order
+func sortedKeys(leases map[string]*Lease) []string {
+	keys := make([]string, 0, len(leases))
+	for k := range leases {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// iterate calls fn with a copy of each lease of a map, sorted by key
+func iterate(leases map[string]*Lease, fn func(l *Lease) error) error {
+	for _, k := range sortedKeys(leases) {
+		if err := fn(copyLease(leases[k])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expiredBefore returns the leases of a map expiring before t, sorted by key
+func expiredBefore(leases map[string]*Lease, t time.Time) []*Lease {
+	var expired []*Lease
+	for _, k := range sortedKeys(leases) {
+		if leases[k].Expires.Before(t) {
+			expired = append(expired, copyLease(leases[k]))
+		}
+	}
+	return expired
+}
@@ -0,0 +1,325 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package leasestore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// removedAddress replaces the address in the lines recording a removal
+const removedAddress = "-"
+
+// compactMinLines is the number of lines appended to the lease file since it
+// was last compacted above which it is compacted again, if they outnumber the
+// leases
+const compactMinLines = 1000
+
+// Text is a store appending the changes to a text file, one per line: the
+// key, the address and the expiry time of a lease, or "-" instead of the
+// address for a removal. The file is compacted, keeping only the current
+// leases, when it is opened and whenever it grew too much. The leases are also
+// kept in memory.
+type Text struct {
+	sync.Mutex
+	filename string
+	file     *os.File
+	leases   map[string]*Lease
+	// appended is the number of lines written to file since it was compacted
+	appended int
+}
+
+func openText(filename string) (Store, error) {
+	return OpenText(filename)
+}
+
+// OpenText opens a text store, creating the file if it doesn't exist
+func OpenText(filename string) (*Text, error) {
+	f, err := os.OpenFile(filename, os.O_RDONLY|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	leases, err := loadText(f)
+	if cerr := f.Close(); cerr != nil {
+		log.Warningf("Failed to close file %s: %v", filename, cerr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := &Text{filename: filename, leases: leases}
+	// start from a compact file, without the replaced and removed leases
+	if err := t.compact(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// loadText reads the leases of a text store. A malformed last line without a
+// newline is ignored: it is a lease that was being written when the server
+// stopped.
+func loadText(r io.Reader) (map[string]*Lease, error) {
+	br := bufio.NewReader(r)
+	leases := make(map[string]*Lease)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		truncated := err == io.EOF
+		if line = strings.TrimSpace(line); line != "" {
+			l, removed, perr := parseLine(line)
+			switch {
+			case perr != nil && truncated:
+				log.Warningf("Ignoring truncated last line of the lease file: %v", perr)
+			case perr != nil:
+				return nil, perr
+			case removed:
+				delete(leases, l.Key)
+			default:
+				leases[l.Key] = l
+			}
+		}
+		if truncated {
+			return leases, nil
+		}
+	}
+}
+
+// parseLine parses a line of a text store, which records either a lease or
+// its removal
+func parseLine(line string) (l *Lease, removed bool, err error) {
+	tokens := strings.Fields(line)
+	if len(tokens) != 3 {
+		return nil, false, fmt.Errorf("malformed line, want 3 fields, got %d: %s", len(tokens), line)
+	}
+	expires, err := time.Parse(time.RFC3339, tokens[2])
+	if err != nil {
+		return nil, false, fmt.Errorf("expected time of expiry in RFC3339 format, got: %v", tokens[2])
+	}
+	l = &Lease{Key: tokens[0], Address: tokens[1], Expires: expires}
+	return l, l.Address == removedAddress, nil
+}
+
+// formatLine returns the line of a text store recording a lease
+func formatLine(l *Lease) string {
+	return l.Key + " " + l.Address + " " + l.Expires.Format(time.RFC3339) + "\n"
+}
+
+// Get implements Store
+func (t *Text) Get(key string) (*Lease, error) {
+	t.Lock()
+	defer t.Unlock()
+	l, ok := t.leases[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyLease(l), nil
+}
+
+// Put implements Store
+func (t *Text) Put(l *Lease) error {
+	if err := check(l); err != nil {
+		return err
+	}
+	if l.Address == removedAddress {
+		return fmt.Errorf("invalid lease address %q", l.Address)
+	}
+	t.Lock()
+	defer t.Unlock()
+	l = copyLease(l)
+	if err := t.append(formatLine(l)); err != nil {
+		return err
+	}
+	t.leases[l.Key] = l
+	return t.maybeCompact()
+}
+
+// Delete implements Store
+func (t *Text) Delete(key string) error {
+	t.Lock()
+	defer t.Unlock()
+	if _, ok := t.leases[key]; !ok {
+		return nil
+	}
+	if err := t.append(formatLine(&Lease{Key: key, Address: removedAddress, Expires: time.Now()})); err != nil {
+		return err
+	}
+	delete(t.leases, key)
+	return t.maybeCompact()
+}
+
+// Iterate implements Store
+func (t *Text) Iterate(fn func(l *Lease) error) error {
+	t.Lock()
+	defer t.Unlock()
+	return iterate(t.leases, fn)
+}
+
+// ExpireBefore implements Store
+func (t *Text) ExpireBefore(at time.Time) ([]*Lease, error) {
+	t.Lock()
+	defer t.Unlock()
+	expired := expiredBefore(t.leases, at)
+	if len(expired) == 0 {
+		return nil, nil
+	}
+	var b strings.Builder
+	now := time.Now()
+	for _, l := range expired {
+		b.WriteString(formatLine(&Lease{Key: l.Key, Address: removedAddress, Expires: now}))
+	}
+	if err := t.append(b.String()); err != nil {
+		return nil, err
+	}
+	t.appended += len(expired) - 1
+	for _, l := range expired {
+		delete(t.leases, l.Key)
+	}
+	return expired, t.maybeCompact()
+}
+
+// append writes lines to the file and syncs it. The lock must be held.
+func (t *Text) append(lines string) error {
+	if t.file == nil {
+		return errors.New("lease file is closed")
+	}
+	if _, err := t.file.WriteString(lines); err != nil {
+		return err
+	}
+	t.appended++
+	return t.file.Sync()
+}
+
+// maybeCompact compacts the file if it grew enough. The lock must be held.
+func (t *Text) maybeCompact() error {
+	if t.appended < compactMinLines || t.appended <= len(t.leases) {
+		return nil
+	}
+	lines := t.appended
+	if err := t.compact(); err != nil {
+		return fmt.Errorf("could not compact lease file: %w", err)
+	}
+	log.Infof("Compacted lease file %s after %d updates, %d leases left", t.filename, lines, len(t.leases))
+	return nil
+}
+
+// compact replaces the file with a snapshot of the current leases, and opens
+// the new file for appending. The lock must be held.
+func (t *Text) compact() error {
+	if err := writeSnapshot(t.filename, t.leases); err != nil {
+		return err
+	}
+	// the old file was renamed over, further changes go to the new one
+	if t.file != nil {
+		if err := t.file.Close(); err != nil {
+			log.Warningf("Failed to close replaced lease file: %v", err)
+		}
+		t.file = nil
+	}
+	f, err := os.OpenFile(t.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open lease file %s: %w", t.filename, err)
+	}
+	t.file = f
+	t.appended = 0
+	return nil
+}
+
+// writeSnapshot atomically replaces a lease file with the given leases: they
+// are written to a temporary file in the same directory, which is then renamed
+// to the lease file, so that a crash leaves either the old or the new file
+func writeSnapshot(filename string, leases map[string]*Lease) error {
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, base+".tmp")
+	if err != nil {
+		return fmt.Errorf("cannot create snapshot of lease file %s: %w", filename, err)
+	}
+	defer func() {
+		// only left over if something failed
+		_ = os.Remove(tmp.Name())
+	}()
+	w := bufio.NewWriter(tmp)
+	for _, k := range sortedKeys(leases) {
+		if _, err := w.WriteString(formatLine(leases[k])); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("cannot replace lease file %s: %w", filename, err)
+	}
+	// persist the rename
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Close implements Store
+func (t *Text) Close() error {
+	t.Lock()
+	defer t.Unlock()
+	if t.file == nil {
+		return nil
+	}
+	err := t.file.Close()
+	t.file = nil
+	return err
+}
+
+// Health checks that leases can still be saved: the lease file must be open,
+// and still be the file at its path, rather than a file that was deleted or
+// replaced. It implements plugins.HealthChecker.
+func (t *Text) Health() error {
+	t.Lock()
+	defer t.Unlock()
+	if t.file == nil {
+		return errors.New("lease file is closed")
+	}
+	open, err := t.file.Stat()
+	if err != nil {
+		return fmt.Errorf("cannot stat lease file: %w", err)
+	}
+	current, err := os.Stat(t.filename)
+	if err != nil {
+		return fmt.Errorf("cannot stat lease file: %w", err)
+	}
+	if !os.SameFile(open, current) {
+		return fmt.Errorf("lease file %s was replaced", t.filename)
+	}
+	return nil
+}
@@ -20,21 +20,26 @@ var Plugin = plugins.Plugin{
 	// currently not supported for DHCPv6
 	Setup6: nil,
 	Setup4: setup4,
+	Args: []plugins.Arg{
+		{Name: "duration", Type: "duration", Help: "lease time given to the clients"},
+	},
 }
 
-var (
-	log         = logger.GetLogger("plugins/lease_time")
-	v4LeaseTime time.Duration
-)
+var log = logger.GetLogger("plugins/lease_time")
+
+// PluginState is the data held by an instance of the lease_time plugin
+type PluginState struct {
+	LeaseTime time.Duration
+}
 
 // Handler4 handles DHCPv4 packets for the lease_time plugin.
-func Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+func (p *PluginState) Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	if req.OpCode != dhcpv4.OpcodeBootRequest {
 		return resp, false
 	}
 	// Set lease time unless it has already been set
 	if !resp.Options.Has(dhcpv4.OptionIPAddressLeaseTime) {
-		resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(v4LeaseTime))
+		resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(p.LeaseTime))
 	}
 	return resp, false
 }
@@ -51,7 +56,7 @@ func setup4(args ...string) (handler.Handler4, error) {
 		log.Errorf("invalid duration: %v", args[0])
 		return nil, errors.New("lease_time failed to initialize")
 	}
-	v4LeaseTime = leaseTime
+	p := PluginState{LeaseTime: leaseTime}
 
-	return Handler4, nil
+	return p.Handler4, nil
 }
@@ -0,0 +1,160 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"sync"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/logger"
+)
+
+// Closer is implemented by plugin instances holding resources, like files,
+// sockets or goroutines. Close is called once when the server shuts down, after
+// which the handler of the instance is not called anymore.
+type Closer interface {
+	Close() error
+}
+
+// Reloader is implemented by plugin instances that read external data, like
+// a file of static leases. Reload is called when the server is asked to reload
+// its configuration (on SIGHUP), concurrently with the handler. The arguments
+// of the plugin don't change.
+type Reloader interface {
+	Reload() error
+}
+
+// HealthChecker is implemented by plugin instances that can tell whether they
+// are able to serve requests, eg. whether their lease file is still writable.
+// Health is called on every health check, concurrently with the handler, and
+// returns nil when the instance is healthy.
+type HealthChecker interface {
+	Health() error
+}
+
+// instance is a plugin instance set up by loadPlugins, with the values it
+// registered with Manage
+type instance struct {
+	conf    *config.PluginConfig
+	ver     int
+	managed []interface{}
+}
+
+var (
+	instancesLock sync.Mutex
+	// instances lists the plugin instances that registered lifecycle hooks,
+	// in the order they were set up
+	instances []*instance
+	// current is the instance being set up, if any
+	current *instance
+)
+
+// Manage registers the lifecycle hooks of the plugin instance being set up.
+// Setup functions call it with the value their handler is bound to (typically
+// their PluginState); the Close, Reload and Health methods it implements, see
+// Closer, Reloader and HealthChecker, are then called by the server.
+// Manage does nothing when called outside of a setup function, or in dry-run
+// mode.
+func Manage(v interface{}) {
+	instancesLock.Lock()
+	defer instancesLock.Unlock()
+	if current == nil || dryRun {
+		return
+	}
+	current.managed = append(current.managed, v)
+}
+
+// beginSetup marks the start of the setup of a plugin instance, so that its
+// calls to Manage can be attributed to it
+func beginSetup(conf *config.PluginConfig, ver int) {
+	instancesLock.Lock()
+	defer instancesLock.Unlock()
+	current = &instance{conf: conf, ver: ver}
+}
+
+// endSetup marks the end of the setup of the current plugin instance. If the
+// setup failed, the resources the instance registered are released.
+func endSetup(failed bool) {
+	instancesLock.Lock()
+	inst := current
+	current = nil
+	if !failed && len(inst.managed) > 0 {
+		instances = append(instances, inst)
+	}
+	instancesLock.Unlock()
+	if failed {
+		inst.close()
+	}
+}
+
+func (inst *instance) close() []error {
+	var errs []error
+	for _, v := range inst.managed {
+		if c, ok := v.(Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, pluginError(inst.conf, inst.ver, err))
+			}
+		}
+	}
+	return errs
+}
+
+// Close closes all the plugin instances, in the reverse order of their setup,
+// and returns the errors they reported. Instances are only closed once, so
+// calling Close again does nothing.
+func Close() []error {
+	instancesLock.Lock()
+	closing := instances
+	instances = nil
+	instancesLock.Unlock()
+
+	var errs []error
+	for i := len(closing) - 1; i >= 0; i-- {
+		errs = append(errs, closing[i].close()...)
+	}
+	return errs
+}
+
+// Reload reloads all the plugin instances implementing Reloader, and returns
+// the errors they reported. A failed reload is not fatal: the instance is
+// expected to keep its previous state.
+func Reload() []error {
+	var errs []error
+	for _, inst := range loaded() {
+		for _, v := range inst.managed {
+			if r, ok := v.(Reloader); ok {
+				log.WithField(logger.FieldPlugin, inst.conf.Name).Debugf("DHCPv%d: reloading plugin", inst.ver)
+				if err := r.Reload(); err != nil {
+					errs = append(errs, pluginError(inst.conf, inst.ver, err))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// Health checks all the plugin instances implementing HealthChecker, and
+// returns the errors they reported. The server is healthy when there are none.
+func Health() []error {
+	var errs []error
+	for _, inst := range loaded() {
+		for _, v := range inst.managed {
+			if h, ok := v.(HealthChecker); ok {
+				if err := h.Health(); err != nil {
+					errs = append(errs, pluginError(inst.conf, inst.ver, err))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// loaded returns a copy of the list of instances, so that their hooks can be
+// called without holding the lock
+func loaded() []*instance {
+	instancesLock.Lock()
+	defer instancesLock.Unlock()
+	return append([]*instance(nil), instances...)
+}
@@ -0,0 +1,97 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testInstance records the lifecycle calls it gets
+type testInstance struct {
+	name  string
+	calls *[]string
+	err   error
+}
+
+func (ti *testInstance) Close() error {
+	*ti.calls = append(*ti.calls, "close "+ti.name)
+	return nil
+}
+
+func (ti *testInstance) Reload() error {
+	*ti.calls = append(*ti.calls, "reload "+ti.name)
+	return ti.err
+}
+
+func (ti *testInstance) Health() error {
+	return ti.err
+}
+
+func TestLifecycle(t *testing.T) {
+	var calls []string
+	plugin := Plugin{
+		Name: "test_lifecycle",
+		Setup4: func(args ...string) (handler.Handler4, error) {
+			ti := &testInstance{name: args[0], calls: &calls}
+			if len(args) > 1 {
+				ti.err = errors.New(args[1])
+			}
+			Manage(ti)
+			if args[0] == "fail" {
+				return nil, errors.New("setup failed")
+			}
+			return noop4, nil
+		},
+	}
+	require.NoError(t, RegisterPlugin(&plugin))
+	defer delete(RegisteredPlugins, plugin.Name)
+
+	conf := config.New()
+	conf.Server4 = &config.ServerConfig{
+		Plugins: []config.PluginConfig{
+			{Name: "test_lifecycle", Args: []string{"a"}},
+			{Name: "test_lifecycle", Args: []string{"b", "broken"}, File: "config.yml", Line: 4},
+		},
+	}
+	_, _, err := LoadPlugins(conf)
+	require.NoError(t, err)
+
+	errs := Health()
+	require.Len(t, errs, 1)
+	assert.Equal(t, "config.yml:4: DHCPv4: plugin test_lifecycle: broken", errs[0].Error())
+
+	assert.Len(t, Reload(), 1)
+	assert.Equal(t, []string{"reload a", "reload b"}, calls)
+
+	// instances are closed in reverse order, and only once
+	calls = nil
+	assert.Empty(t, Close())
+	assert.Empty(t, Close())
+	assert.Equal(t, []string{"close b", "close a"}, calls)
+	assert.Empty(t, Health())
+
+	// a failed load closes what was set up, including the failed instance
+	calls = nil
+	conf.Server4.Plugins = []config.PluginConfig{
+		{Name: "test_lifecycle", Args: []string{"a"}},
+		{Name: "test_lifecycle", Args: []string{"fail"}},
+	}
+	_, _, err = LoadPlugins(conf)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"close fail", "close a"}, calls)
+
+	// dry-run setups are not managed
+	calls = nil
+	conf.Server4.Plugins = conf.Server4.Plugins[:1]
+	assert.Empty(t, CheckPlugins(conf))
+	assert.Empty(t, Close())
+	assert.Empty(t, calls)
+}
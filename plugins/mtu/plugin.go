@@ -23,28 +23,33 @@ var Plugin = plugins.Plugin{
 	Name:   "mtu",
 	Setup4: setup4,
 	// No Setup6 since DHCPv6 does not have MTU-related options
+	Args: []plugins.Arg{
+		{Name: "mtu", Type: "integer", Help: "interface MTU advertised to the clients"},
+	},
 }
 
-var (
-	mtu int
-)
+// PluginState is the data held by an instance of the mtu plugin
+type PluginState struct {
+	MTU int
+}
 
 func setup4(args ...string) (handler.Handler4, error) {
 	if len(args) != 1 {
 		return nil, errors.New("need one mtu value")
 	}
-	var err error
-	if mtu, err = strconv.Atoi(args[0]); err != nil {
+	mtu, err := strconv.Atoi(args[0])
+	if err != nil {
 		return nil, fmt.Errorf("invalid mtu: %v", args[0])
 	}
 	log.Infof("loaded mtu %d.", mtu)
-	return Handler4, nil
+	p := PluginState{MTU: mtu}
+	return p.Handler4, nil
 }
 
 // Handler4 handles DHCPv4 packets for the mtu plugin
-func Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+func (p *PluginState) Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	if req.IsOptionRequested(dhcpv4.OptionInterfaceMTU) {
-		resp.Options.Update(dhcpv4.Option{Code: dhcpv4.OptionInterfaceMTU, Value: dhcpv4.Uint16(mtu)})
+		resp.Options.Update(dhcpv4.Option{Code: dhcpv4.OptionInterfaceMTU, Value: dhcpv4.Uint16(p.MTU)})
 	}
 	return resp, false
 }
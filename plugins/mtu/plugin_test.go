@@ -8,6 +8,7 @@ import (
 	"net"
 	"testing"
 
+	"github.com/coredhcp/coredhcp/handler"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 )
 
@@ -21,9 +22,9 @@ func TestAddServer4(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	mtu = 1500
+	p := PluginState{MTU: 1500}
 
-	resp, stop := Handler4(req, stub)
+	resp, stop := p.Handler4(&handler.PropagateState{}, req, stub)
 	if resp == nil {
 		t.Fatal("plugin did not return a message")
 	}
@@ -35,8 +36,8 @@ func TestAddServer4(t *testing.T) {
 		t.Errorf("Failed to retrieve mtu from response")
 	}
 
-	if mtu != int(rMTU) {
-		t.Errorf("Found %d mtu, expected %d", rMTU, mtu)
+	if p.MTU != int(rMTU) {
+		t.Errorf("Found %d mtu, expected %d", rMTU, p.MTU)
 	}
 }
 
@@ -50,10 +51,10 @@ func TestNotRequested4(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	mtu = 1500
+	p := PluginState{MTU: 1500}
 	req.UpdateOption(dhcpv4.OptParameterRequestList(dhcpv4.OptionBroadcastAddress))
 
-	resp, stop := Handler4(req, stub)
+	resp, stop := p.Handler4(&handler.PropagateState{}, req, stub)
 	if resp == nil {
 		t.Fatal("plugin did not return a message")
 	}
@@ -47,12 +47,17 @@ var Plugin = plugins.Plugin{
 	Name:   "nbp",
 	Setup6: setup6,
 	Setup4: setup4,
+	Args: []plugins.Arg{
+		{Name: "url", Type: "URL", Help: "location of the network boot program"},
+	},
+	Terminal: plugins.AlwaysTerminal,
 }
 
-var (
+// PluginState is the data held by an instance of the nbp plugin
+type PluginState struct {
 	opt59, opt60 dhcpv6.Option
 	opt66, opt67 *dhcpv4.Option
-)
+}
 
 func parseArgs(args ...string) (*url.URL, error) {
 	if len(args) != 1 {
@@ -66,16 +71,17 @@ func setup6(args ...string) (handler.Handler6, error) {
 	if err != nil {
 		return nil, err
 	}
-	opt59 = dhcpv6.OptBootFileURL(u.String())
+	var p PluginState
+	p.opt59 = dhcpv6.OptBootFileURL(u.String())
 	params := u.Query().Get("params")
 	if params != "" {
-		opt60 = &dhcpv6.OptionGeneric{
+		p.opt60 = &dhcpv6.OptionGeneric{
 			OptionCode: dhcpv6.OptionBootfileParam,
 			OptionData: []byte(params),
 		}
 	}
 	log.Printf("loaded NBP plugin for DHCPv6.")
-	return nbpHandler6, nil
+	return p.nbpHandler6, nil
 }
 
 func setup4(args ...string) (handler.Handler4, error) {
@@ -84,23 +90,26 @@ func setup4(args ...string) (handler.Handler4, error) {
 		return nil, err
 	}
 
-	var otsn, obfn dhcpv4.Option
+	var (
+		p          PluginState
+		otsn, obfn dhcpv4.Option
+	)
 	switch u.Scheme {
 	case "http", "https", "ftp":
 		obfn = dhcpv4.OptBootFileName(u.String())
 	default:
 		otsn = dhcpv4.OptTFTPServerName(u.Host)
 		obfn = dhcpv4.OptBootFileName(u.Path)
-		opt66 = &otsn
+		p.opt66 = &otsn
 	}
 
-	opt67 = &obfn
+	p.opt67 = &obfn
 	log.Printf("loaded NBP plugin for DHCPv4.")
-	return nbpHandler4, nil
+	return p.nbpHandler4, nil
 }
 
-func nbpHandler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
-	if opt59 == nil {
+func (p *PluginState) nbpHandler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	if p.opt59 == nil {
 		// nothing to do
 		return resp, true
 	}
@@ -113,30 +122,30 @@ func nbpHandler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6
 	for _, code := range decap.Options.RequestedOptions() {
 		if code == dhcpv6.OptionBootfileURL {
 			// bootfile URL is requested
-			resp.AddOption(opt59)
+			resp.AddOption(p.opt59)
 		} else if code == dhcpv6.OptionBootfileParam {
 			// optionally add opt60, bootfile params, if requested
-			if opt60 != nil {
-				resp.AddOption(opt60)
+			if p.opt60 != nil {
+				resp.AddOption(p.opt60)
 			}
 		}
 	}
-	log.Debugf("Added NBP %s to request", opt59)
+	log.Debugf("Added NBP %s to request", p.opt59)
 	return resp, true
 }
 
-func nbpHandler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
-	if opt66 == nil {
+func (p *PluginState) nbpHandler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if p.opt66 == nil {
 		// nothing to do
 		return resp, true
 	}
-	if req.IsOptionRequested(dhcpv4.OptionTFTPServerName) && opt66 != nil {
-		resp.Options.Update(*opt66)
-		log.Debugf("Added NBP %s / %s to request", opt66, opt67)
+	if req.IsOptionRequested(dhcpv4.OptionTFTPServerName) && p.opt66 != nil {
+		resp.Options.Update(*p.opt66)
+		log.Debugf("Added NBP %s / %s to request", p.opt66, p.opt67)
 	}
-	if req.IsOptionRequested(dhcpv4.OptionBootfileName) && opt67 != nil {
-		resp.Options.Update(*opt67)
-		log.Debugf("Added NBP %s to request", opt67)
+	if req.IsOptionRequested(dhcpv4.OptionBootfileName) && p.opt67 != nil {
+		resp.Options.Update(*p.opt67)
+		log.Debugf("Added NBP %s to request", p.opt67)
 	}
 	return resp, true
 }
@@ -21,11 +21,15 @@ var log = logger.GetLogger("plugins/netmask")
 var Plugin = plugins.Plugin{
 	Name:   "netmask",
 	Setup4: setup4,
+	Args: []plugins.Arg{
+		{Name: "netmask", Type: "IPv4 mask", Help: "network mask of the assigned addresses"},
+	},
 }
 
-var (
-	netmask net.IPMask
-)
+// PluginState is the data held by an instance of the netmask plugin
+type PluginState struct {
+	Netmask net.IPMask
+}
 
 func setup4(args ...string) (handler.Handler4, error) {
 	log.Printf("loaded plugin for DHCPv4.")
@@ -40,17 +44,18 @@ func setup4(args ...string) (handler.Handler4, error) {
 	if netmaskIP == nil {
 		return nil, errors.New("expected an netmask address, got: " + args[0])
 	}
-	netmask = net.IPv4Mask(netmaskIP[0], netmaskIP[1], netmaskIP[2], netmaskIP[3])
+	netmask := net.IPv4Mask(netmaskIP[0], netmaskIP[1], netmaskIP[2], netmaskIP[3])
 	if !checkValidNetmask(netmask) {
 		return nil, errors.New("netmask is not valid, got: " + args[0])
 	}
 	log.Printf("loaded client netmask")
-	return Handler4, nil
+	p := PluginState{Netmask: netmask}
+	return p.Handler4, nil
 }
 
 //Handler4 handles DHCPv4 packets for the netmask plugin
-func Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
-	resp.Options.Update(dhcpv4.OptSubnetMask(netmask))
+func (p *PluginState) Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	resp.Options.Update(dhcpv4.OptSubnetMask(p.Netmask))
 	return resp, false
 }
 
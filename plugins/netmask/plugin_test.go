@@ -8,6 +8,7 @@ import (
 	"net"
 	"testing"
 
+	"github.com/coredhcp/coredhcp/handler"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/stretchr/testify/assert"
 )
@@ -25,7 +26,7 @@ func TestCheckValidNetmask(t *testing.T) {
 
 func TestHandler4(t *testing.T) {
 	// set plugin netmask
-	netmask = net.IPv4Mask(255, 255, 255, 0)
+	p := PluginState{Netmask: net.IPv4Mask(255, 255, 255, 0)}
 
 	// prepare DHCPv4 request
 	req := &dhcpv4.DHCPv4{}
@@ -35,17 +36,18 @@ func TestHandler4(t *testing.T) {
 
 	// if we handle this DHCP request, the netmask should be one of the options
 	// of the result
-	result, stop := Handler4(req, resp)
+	result, stop := p.Handler4(&handler.PropagateState{}, req, resp)
 	assert.Same(t, result, resp)
 	assert.False(t, stop)
-	assert.EqualValues(t, netmask, resp.Options.Get(dhcpv4.OptionSubnetMask))
+	assert.EqualValues(t, p.Netmask, resp.Options.Get(dhcpv4.OptionSubnetMask))
 }
 
 func TestSetup4(t *testing.T) {
 	// valid configuration
-	_, err := setup4("255.255.255.0")
+	h, err := setup4("255.255.255.0")
 	assert.NoError(t, err)
-	assert.EqualValues(t, netmask, net.IPv4Mask(255, 255, 255, 0))
+	resp, _ := h(&handler.PropagateState{}, &dhcpv4.DHCPv4{}, &dhcpv4.DHCPv4{Options: dhcpv4.Options{}})
+	assert.EqualValues(t, net.IPv4Mask(255, 255, 255, 0), resp.Options.Get(dhcpv4.OptionSubnetMask))
 
 	// no configuration
 	_, err = setup4()
@@ -0,0 +1,75 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/logger"
+)
+
+// Terminal tells whether the handler of a plugin ends the chain (returns
+// true) for the requests it answers. Dropping invalid requests doesn't count.
+type Terminal int
+
+const (
+	// NotTerminal plugins let the next plugins run
+	NotTerminal Terminal = iota
+	// TerminalForSome plugins end the chain for some requests only, eg. the
+	// file plugin for the clients it knows
+	TerminalForSome
+	// AlwaysTerminal plugins end the chain for all the requests, so that the
+	// plugins after them never run, unless they have a guard
+	AlwaysTerminal
+)
+
+// checkChain checks the order of the plugins of a server section against
+// their Requires, MustPrecede and Terminal metadata. Unknown plugins are
+// ignored, as they are reported when loading them.
+func checkChain(confs []config.PluginConfig, ver int) []error {
+	var errs []error
+	// position of the first occurrence of each plugin
+	first := make(map[string]int)
+	for i := range confs {
+		if _, ok := first[confs[i].Name]; !ok {
+			first[confs[i].Name] = i
+		}
+	}
+	for i := range confs {
+		pc := &confs[i]
+		plugin, ok := RegisteredPlugins[pc.Name]
+		if !ok {
+			continue
+		}
+		for _, name := range plugin.Requires {
+			if _, ok := first[name]; !ok {
+				errs = append(errs, pluginError(pc, ver, fmt.Errorf("requires the %s plugin, which is not configured", name)))
+			}
+		}
+		for _, name := range plugin.MustPrecede {
+			if j, ok := first[name]; ok && j < i {
+				errs = append(errs, pluginError(pc, ver, fmt.Errorf("must come before the %s plugin", name)))
+			}
+		}
+		if i == len(confs)-1 {
+			continue
+		}
+		switch plugin.Terminal {
+		case AlwaysTerminal:
+			if pc.When == "" && pc.Condition == nil {
+				var next []string
+				for _, c := range confs[i+1:] {
+					next = append(next, c.Name)
+				}
+				errs = append(errs, pluginError(pc, ver, fmt.Errorf("ends the chain for all requests, so the next plugins never run: %s", strings.Join(next, ", "))))
+			}
+		case TerminalForSome:
+			log.WithField(logger.FieldPlugin, pc.Name).Infof("DHCPv%d: the plugins after %s don't run for the requests it answers", ver, pc.Name)
+		}
+	}
+	return errs
+}
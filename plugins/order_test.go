@@ -0,0 +1,69 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"testing"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckChain(t *testing.T) {
+	setup := func(args ...string) (handler.Handler4, error) { return noop4, nil }
+	for _, p := range []*Plugin{
+		{Name: "test_id", Setup4: setup, MustPrecede: []string{"test_addr"}},
+		{Name: "test_addr", Setup4: setup, Requires: []string{"test_id"}},
+		{Name: "test_static", Setup4: setup, Terminal: TerminalForSome},
+		{Name: "test_boot", Setup4: setup, Terminal: AlwaysTerminal},
+	} {
+		require.NoError(t, RegisterPlugin(p))
+		defer delete(RegisteredPlugins, p.Name)
+	}
+	chain := func(names ...string) []config.PluginConfig {
+		var confs []config.PluginConfig
+		for _, name := range names {
+			confs = append(confs, config.PluginConfig{Name: name})
+		}
+		return confs
+	}
+
+	for _, names := range [][]string{
+		{"test_id", "test_static", "test_addr", "test_boot"},
+		{"test_id", "test_addr", "unknown"},
+		{"test_boot"},
+	} {
+		assert.Empty(t, checkChain(chain(names...), 4), names)
+	}
+	for _, tc := range []struct {
+		names []string
+		errs  int
+	}{
+		{[]string{"test_addr"}, 1},
+		{[]string{"test_addr", "test_id"}, 1},
+		{[]string{"test_boot", "test_id", "test_addr"}, 1},
+		{[]string{"test_addr", "test_boot", "test_addr"}, 3},
+	} {
+		assert.Len(t, checkChain(chain(tc.names...), 4), tc.errs, tc.names)
+	}
+
+	// a guarded terminal plugin lets the other requests through
+	confs := chain("test_boot", "test_id", "test_addr")
+	confs[0].When = `arch == 7`
+	assert.Empty(t, checkChain(confs, 4))
+	confs[0].When = ""
+	confs[0].Condition = &config.Condition{Interface: []string{"eth1"}}
+	assert.Empty(t, checkChain(confs, 4))
+
+	// LoadPlugins rejects invalid chains
+	conf := config.New()
+	conf.Server4 = &config.ServerConfig{Plugins: chain("test_addr")}
+	_, _, err := LoadPlugins(conf)
+	assert.Error(t, err)
+	conf.Server4.Plugins = chain("test_boot", "test_id", "test_addr")
+	assert.Len(t, CheckPlugins(conf), 1)
+}
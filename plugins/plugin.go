@@ -6,10 +6,12 @@ package plugins
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/coredhcp/coredhcp/config"
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins/reservations"
 )
 
 var log = logger.GetLogger("plugins")
@@ -17,10 +19,33 @@ var log = logger.GetLogger("plugins")
 // Plugin represents a plugin object.
 // Setup6 and Setup4 are the setup functions for DHCPv6 and DHCPv4 handlers
 // respectively. Both setup functions can be nil.
+// Plugins taking structured arguments (a map in the configuration file)
+// set Setup6Config and Setup4Config instead, which take precedence over
+// Setup6 and Setup4 when they are set.
+// Args optionally documents the arguments of the plugin, see Usage.
+//
+// Requires, MustPrecede and Terminal describe how the plugin fits in a chain,
+// and are checked when loading the plugins of each server section: the
+// plugins named in Requires must be configured too, those in MustPrecede, if
+// configured, must come after this plugin, and an AlwaysTerminal plugin must
+// be the last one, unless it has a guard.
+//
+// A plugin can be listed several times in the configuration, eg. in both the
+// server4 and server6 sections, or once per subnet. The setup function is
+// called once for each occurrence, and each call must return a handler with
+// its own state: plugins keep their configuration in a value the returned
+// handler is bound to (typically a PluginState whose methods are the
+// handlers), never in package variables.
 type Plugin struct {
-	Name   string
-	Setup6 SetupFunc6
-	Setup4 SetupFunc4
+	Name         string
+	Setup6       SetupFunc6
+	Setup4       SetupFunc4
+	Setup6Config SetupConfigFunc6
+	Setup4Config SetupConfigFunc4
+	Args         []Arg
+	Requires     []string
+	MustPrecede  []string
+	Terminal     Terminal
 }
 
 // RegisteredPlugins maps a plugin name to a Plugin instance.
@@ -32,12 +57,42 @@ type SetupFunc6 func(args ...string) (handler.Handler6, error)
 // SetupFunc4 defines a plugin setup function for DHCPv6
 type SetupFunc4 func(args ...string) (handler.Handler4, error)
 
+// SetupConfigFunc6 defines a plugin setup function for DHCPv6, receiving the
+// whole plugin configuration rather than just string arguments
+type SetupConfigFunc6 func(conf *config.PluginConfig) (handler.Handler6, error)
+
+// SetupConfigFunc4 defines a plugin setup function for DHCPv4, receiving the
+// whole plugin configuration rather than just string arguments
+type SetupConfigFunc4 func(conf *config.PluginConfig) (handler.Handler4, error)
+
+// setup6 calls the setup function of the plugin matching the configuration
+func (p *Plugin) setup6(conf *config.PluginConfig) (handler.Handler6, error) {
+	if p.Setup6Config != nil {
+		return p.Setup6Config(conf)
+	}
+	if conf.IsMap() {
+		return nil, config.ConfigErrorFromString("plugin %s does not take structured arguments", conf.Name)
+	}
+	return p.Setup6(conf.Args...)
+}
+
+// setup4 calls the setup function of the plugin matching the configuration
+func (p *Plugin) setup4(conf *config.PluginConfig) (handler.Handler4, error) {
+	if p.Setup4Config != nil {
+		return p.Setup4Config(conf)
+	}
+	if conf.IsMap() {
+		return nil, config.ConfigErrorFromString("plugin %s does not take structured arguments", conf.Name)
+	}
+	return p.Setup4(conf.Args...)
+}
+
 // RegisterPlugin registers a plugin.
 func RegisterPlugin(plugin *Plugin) error {
 	if plugin == nil {
 		return errors.New("cannot register nil plugin")
 	}
-	log.Printf("Registering plugin '%s'", plugin.Name)
+	log.WithField(logger.FieldPlugin, plugin.Name).Info("Registering plugin")
 	if _, ok := RegisteredPlugins[plugin.Name]; ok {
 		// TODO this highlights that asking the plugins to register themselves
 		// is not the right approach. Need to register them in the main program.
@@ -47,20 +102,69 @@ func RegisterPlugin(plugin *Plugin) error {
 	return nil
 }
 
+// dryRun is set while checking the configuration, see DryRun
+var dryRun bool
+
+// DryRun returns whether the plugins are being set up only to check their
+// configuration. In that case, setup functions should validate their
+// arguments, but not open files, start goroutines or watchers, nor otherwise
+// change the state of the system. The handler they return is not called.
+func DryRun() bool {
+	return dryRun
+}
+
 // LoadPlugins reads a Config object and loads the plugins as specified in the
 // `plugins` section, in order. For a plugin to be available, it must have been
 // previously registered with plugins.RegisterPlugin. This is normally done at
 // plugin import time.
-// This function returns the list of loaded v6 plugins, the list of loaded v4
-// plugins, and an error if any.
-func LoadPlugins(conf *config.Config) ([]handler.Handler4, []handler.Handler6, error) {
+// This function returns the list of loaded v4 plugins, the list of loaded v6
+// plugins, and an error if any. The server runs the handler of each plugin in
+// turn, for the requests its guard matches (see Step6).
+// The plugin instances registered with Manage are then closed, reloaded and
+// checked with Close, Reload and Health.
+func LoadPlugins(conf *config.Config) ([]Step4, []Step6, error) {
 	log.Print("Loading plugins...")
-	handlers4 := make([]handler.Handler4, 0)
-	handlers6 := make([]handler.Handler6, 0)
+	handlers4, handlers6, errs := loadPlugins(conf, false)
+	if len(errs) > 0 {
+		// release what the plugins loaded so far hold
+		Close()
+		return nil, nil, errs[0]
+	}
+	return handlers4, handlers6, nil
+}
+
+// CheckPlugins sets up all the plugins of a configuration in dry-run mode
+// (see DryRun), and returns all the errors found rather than only the first
+// one.
+func CheckPlugins(conf *config.Config) []error {
+	dryRun = true
+	defer func() { dryRun = false }()
+	_, _, errs := loadPlugins(conf, true)
+	return errs
+}
+
+// pluginError prefixes an error with the location of the plugin in the
+// configuration
+func pluginError(pluginConf *config.PluginConfig, ver int, err error) error {
+	msg := fmt.Sprintf("DHCPv%d: plugin %s: %v", ver, pluginConf.Name, err)
+	if pos := pluginConf.Position(); pos != "" {
+		msg = pos + ": " + msg
+	}
+	return errors.New(msg)
+}
+
+// loadPlugins sets up the plugins. It stops at the first error, unless
+// keepGoing is set.
+func loadPlugins(conf *config.Config, keepGoing bool) ([]Step4, []Step6, []error) {
+	handlers4 := make([]Step4, 0)
+	handlers6 := make([]Step6, 0)
+	var errs []error
 
 	if conf.Server6 == nil && conf.Server4 == nil {
-		return nil, nil, errors.New("no configuration found for either DHCPv6 or DHCPv4")
+		return nil, nil, []error{errors.New("no configuration found for either DHCPv6 or DHCPv4")}
 	}
+	// the pools and reservations are registered again by the plugins
+	reservations.Reset()
 
 	// now load the plugins. We need to call its setup function with
 	// the arguments extracted above. The setup function is mapped in
@@ -68,47 +172,71 @@ func LoadPlugins(conf *config.Config) ([]handler.Handler4, []handler.Handler6, e
 
 	// Load DHCPv6 plugins.
 	if conf.Server6 != nil {
-		for _, pluginConf := range conf.Server6.Plugins {
-			if plugin, ok := RegisteredPlugins[pluginConf.Name]; ok {
-				log.Printf("DHCPv6: loading plugin `%s`", pluginConf.Name)
-				if plugin.Setup6 == nil {
-					log.Warningf("DHCPv6: plugin `%s` has no setup function for DHCPv6", pluginConf.Name)
-					continue
-				}
-				h6, err := plugin.Setup6(pluginConf.Args...)
+		errs = append(errs, checkChain(conf.Server6.Plugins, 6)...)
+		if len(errs) > 0 && !keepGoing {
+			return nil, nil, errs
+		}
+		for i := range conf.Server6.Plugins {
+			pluginConf := &conf.Server6.Plugins[i]
+			plugin, ok := RegisteredPlugins[pluginConf.Name]
+			if !ok {
+				errs = append(errs, pluginError(pluginConf, 6, errors.New("unknown plugin")))
+			} else if plugin.Setup6 == nil && plugin.Setup6Config == nil {
+				log.WithField(logger.FieldPlugin, pluginConf.Name).Warning("DHCPv6: plugin has no setup function for DHCPv6")
+			} else if guard, err := compileGuard(pluginConf); err != nil {
+				errs = append(errs, pluginError(pluginConf, 6, err))
+			} else {
+				log.WithField(logger.FieldPlugin, pluginConf.Name).Info("DHCPv6: loading plugin")
+				beginSetup(pluginConf, 6)
+				h6, err := plugin.setup6(pluginConf)
+				endSetup(err != nil || h6 == nil)
 				if err != nil {
-					return nil, nil, err
+					errs = append(errs, pluginError(pluginConf, 6, err))
 				} else if h6 == nil {
-					return nil, nil, config.ConfigErrorFromString("no DHCPv6 handler for plugin %s", pluginConf.Name)
+					errs = append(errs, pluginError(pluginConf, 6, errors.New("no DHCPv6 handler")))
+				} else {
+					handlers6 = append(handlers6, Step6{Name: pluginConf.Name, Guard: guard, Handler: h6})
 				}
-				handlers6 = append(handlers6, h6)
-			} else {
-				return nil, nil, config.ConfigErrorFromString("DHCPv6: unknown plugin `%s`", pluginConf.Name)
+			}
+			if len(errs) > 0 && !keepGoing {
+				return nil, nil, errs
 			}
 		}
 	}
 	// Load DHCPv4 plugins. Yes, duplicated code, there's not really much that
 	// can be deduplicated here.
 	if conf.Server4 != nil {
-		for _, pluginConf := range conf.Server4.Plugins {
-			if plugin, ok := RegisteredPlugins[pluginConf.Name]; ok {
-				log.Printf("DHCPv4: loading plugin `%s`", pluginConf.Name)
-				if plugin.Setup4 == nil {
-					log.Warningf("DHCPv4: plugin `%s` has no setup function for DHCPv4", pluginConf.Name)
-					continue
-				}
-				h4, err := plugin.Setup4(pluginConf.Args...)
+		errs = append(errs, checkChain(conf.Server4.Plugins, 4)...)
+		if len(errs) > 0 && !keepGoing {
+			return nil, nil, errs
+		}
+		for i := range conf.Server4.Plugins {
+			pluginConf := &conf.Server4.Plugins[i]
+			plugin, ok := RegisteredPlugins[pluginConf.Name]
+			if !ok {
+				errs = append(errs, pluginError(pluginConf, 4, errors.New("unknown plugin")))
+			} else if plugin.Setup4 == nil && plugin.Setup4Config == nil {
+				log.WithField(logger.FieldPlugin, pluginConf.Name).Warning("DHCPv4: plugin has no setup function for DHCPv4")
+			} else if guard, err := compileGuard(pluginConf); err != nil {
+				errs = append(errs, pluginError(pluginConf, 4, err))
+			} else {
+				log.WithField(logger.FieldPlugin, pluginConf.Name).Info("DHCPv4: loading plugin")
+				beginSetup(pluginConf, 4)
+				h4, err := plugin.setup4(pluginConf)
+				endSetup(err != nil || h4 == nil)
 				if err != nil {
-					return nil, nil, err
+					errs = append(errs, pluginError(pluginConf, 4, err))
 				} else if h4 == nil {
-					return nil, nil, config.ConfigErrorFromString("no DHCPv4 handler for plugin %s", pluginConf.Name)
+					errs = append(errs, pluginError(pluginConf, 4, errors.New("no DHCPv4 handler")))
+				} else {
+					handlers4 = append(handlers4, Step4{Name: pluginConf.Name, Guard: guard, Handler: h4})
 				}
-				handlers4 = append(handlers4, h4)
-			} else {
-				return nil, nil, config.ConfigErrorFromString("DHCPv4: unknown plugin `%s`", pluginConf.Name)
+			}
+			if len(errs) > 0 && !keepGoing {
+				return nil, nil, errs
 			}
 		}
 	}
 
-	return handlers4, handlers6, nil
+	return handlers4, handlers6, errs
 }
@@ -0,0 +1,68 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func noop4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	return resp, false
+}
+
+var testPlugin = Plugin{
+	Name: "test_check",
+	Setup4: func(args ...string) (handler.Handler4, error) {
+		if len(args) != 1 {
+			return nil, errors.New("want one argument")
+		}
+		if !DryRun() {
+			return nil, errors.New("not in dry-run mode")
+		}
+		return noop4, nil
+	},
+	Args: []Arg{
+		{Name: "value", Type: "string", Help: "some value"},
+		{Name: "more", Help: "more values", Optional: true, Repeated: true},
+	},
+}
+
+func TestCheckPlugins(t *testing.T) {
+	require.NoError(t, RegisterPlugin(&testPlugin))
+	defer delete(RegisteredPlugins, testPlugin.Name)
+
+	conf := config.New()
+	conf.Server4 = &config.ServerConfig{
+		Plugins: []config.PluginConfig{
+			{Name: "test_check", Args: []string{"ok"}, File: "config.yml", Line: 3},
+			{Name: "test_check", File: "config.yml", Line: 4},
+			{Name: "test_unknown", File: "config.yml", Line: 5},
+			{Name: "test_check", Value: map[string]interface{}{"value": "ok"}},
+		},
+	}
+	errs := CheckPlugins(conf)
+	require.Len(t, errs, 3)
+	assert.Equal(t, "config.yml:4: DHCPv4: plugin test_check: want one argument", errs[0].Error())
+	assert.Equal(t, "config.yml:5: DHCPv4: plugin test_unknown: unknown plugin", errs[1].Error())
+	assert.Contains(t, errs[2].Error(), "does not take structured arguments")
+	assert.False(t, DryRun())
+
+	// outside of dry-run mode, loading stops at the first error
+	_, _, err := LoadPlugins(conf)
+	assert.EqualError(t, err, "config.yml:3: DHCPv4: plugin test_check: not in dry-run mode")
+}
+
+func TestUsage(t *testing.T) {
+	assert.Equal(t, `test_check (DHCPv4): <value> [<more> ...]
+    value  some value (string)
+    more   more values`, testPlugin.Usage())
+}
@@ -9,6 +9,9 @@
 // - prefix: The base prefix from which assigned prefixes are carved
 // - max: maximum size of the prefix delegated to clients. When a client requests a larger prefix
 // than this, this is the size of the offered prefix
+// - store: optionally, where the leases are stored so that they survive restarts, see
+// leasestore.Open
+// - decline time: optionally, how long a prefix declined by a client is kept out of the pool
 package prefix
 
 // FIXME: various settings will be hardcoded (default size, minimum size, lease times) pending a
@@ -16,10 +19,12 @@ package prefix
 
 import (
 	"bytes"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -27,28 +32,59 @@ import (
 	dhcpIana "github.com/insomniacslk/dhcp/iana"
 	"github.com/bits-and-blooms/bitset"
 
+	"github.com/coredhcp/coredhcp/events"
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
 	"github.com/coredhcp/coredhcp/plugins/allocators"
 	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+	"github.com/coredhcp/coredhcp/plugins/leasestore"
 )
 
 var log = logger.GetLogger("plugins/prefix")
 
+const pluginName = "prefix"
+
 // Plugin registers the prefix. Prefix delegation only exists for DHCPv6
 var Plugin = plugins.Plugin{
-	Name:   "prefix",
+	Name:   pluginName,
 	Setup6: setupPrefix,
+	Args: []plugins.Arg{
+		{Name: "prefix", Type: "IPv6 CIDR", Help: "pool the delegated prefixes are carved from"},
+		{Name: "size", Type: "integer", Help: "length of the delegated prefixes"},
+		{Name: "store", Type: "lease store", Help: "where the leases are stored, eg. leases.txt or bolt:leases.db (default: in memory only)", Optional: true},
+		{Name: "decline_time", Type: "duration", Help: "how long a declined prefix is kept out of the pool (default: 1h)", Optional: true},
+	},
+	Requires: []string{"server_id"},
 }
 
 const leaseDuration = 3600 * time.Second
 
+// defaultDeclineTime is how long a declined prefix is kept out of the pool by
+// default
+const defaultDeclineTime = time.Hour
+
+// declinedKeyPrefix starts the keys of the declined prefixes in the store,
+// which expire at the end of their quarantine
+const declinedKeyPrefix = "declined,"
+
 func setupPrefix(args ...string) (handler.Handler6, error) {
-	// - prefix: 2001:db8::/48 64
+	h, err := newHandler(args...)
+	if err != nil {
+		return nil, err
+	}
+	return h.Handle, nil
+}
+
+// newHandler sets up an instance of the plugin
+func newHandler(args ...string) (*Handler, error) {
+	// - prefix: 2001:db8::/48 64 [leases.txt [1h]]
 	if len(args) < 2 {
 		return nil, errors.New("Need both a subnet and an allocation max size")
 	}
+	if len(args) > 4 {
+		return nil, fmt.Errorf("Too many arguments, want at most 4, got %d", len(args))
+	}
 
 	_, prefix, err := net.ParseCIDR(args[0])
 	if err != nil {
@@ -57,7 +93,7 @@ func setupPrefix(args ...string) (handler.Handler6, error) {
 
 	allocSize, err := strconv.Atoi(args[1])
 	if err != nil || allocSize > 128 || allocSize < 0 {
-		return nil, fmt.Errorf("Invalid prefix length: %v", err)
+		return nil, fmt.Errorf("Invalid prefix length: %v", args[1])
 	}
 
 	// TODO: select allocators based on heuristics or user configuration
@@ -66,10 +102,150 @@ func setupPrefix(args ...string) (handler.Handler6, error) {
 		return nil, fmt.Errorf("Could not initialize prefix allocator: %v", err)
 	}
 
-	return (&Handler{
-		Records:   make(map[string][]lease),
-		allocator: alloc,
-	}).Handle, nil
+	h := &Handler{
+		Records:     make(map[string][]lease),
+		declined:    make(map[string]time.Time),
+		DeclineTime: defaultDeclineTime,
+		allocator:   alloc,
+	}
+	if len(args) > 3 {
+		h.DeclineTime, err = time.ParseDuration(args[3])
+		if err != nil || h.DeclineTime < 0 {
+			return nil, fmt.Errorf("Invalid decline time: %v", args[3])
+		}
+	}
+	if len(args) < 3 {
+		return h, nil
+	}
+	if _, _, err := leasestore.ParseSpec(args[2]); err != nil {
+		return nil, err
+	}
+	if plugins.DryRun() {
+		return h, nil
+	}
+	if h.store, err = leasestore.Open(args[2]); err != nil {
+		return nil, err
+	}
+	if err := h.load(); err != nil {
+		h.store.Close()
+		return nil, fmt.Errorf("Could not load leases from %s: %w", args[2], err)
+	}
+	log.Printf("Loaded %d delegated prefixes from %s", len(h.Records), args[2])
+	plugins.Manage(h)
+	return h, nil
+}
+
+// storeKey is the key of a lease in the store: a client can have several
+// prefixes, so this is the client ID in hexadecimal followed by the prefix
+func storeKey(client string, l *lease) string {
+	return hex.EncodeToString([]byte(client)) + "," + l.Prefix.String()
+}
+
+// load reads the leases and the declined prefixes from the store, and
+// reserves their prefixes in the allocator
+func (h *Handler) load() error {
+	now := time.Now()
+	return h.store.Iterate(func(sl *leasestore.Lease) error {
+		if strings.HasPrefix(sl.Key, declinedKeyPrefix) {
+			return h.loadDeclined(sl, now)
+		}
+		i := strings.IndexByte(sl.Key, ',')
+		if i < 0 {
+			return fmt.Errorf("malformed lease key %s", sl.Key)
+		}
+		client, err := hex.DecodeString(sl.Key[:i])
+		if err != nil {
+			return fmt.Errorf("malformed client ID in lease key %s", sl.Key)
+		}
+		_, prefix, err := net.ParseCIDR(sl.Address)
+		if err != nil {
+			return err
+		}
+		allocated, err := h.allocator.Allocate(*prefix)
+		if err != nil {
+			return fmt.Errorf("failed to re-allocate leased prefix %s: %w", prefix, err)
+		}
+		if !samePrefix(&allocated, prefix) {
+			return fmt.Errorf("allocator did not re-allocate leased prefix %s: %s", prefix, &allocated)
+		}
+		key := string(client)
+		h.Records[key] = append(h.Records[key], lease{Prefix: *prefix, Expire: sl.Expires})
+		return nil
+	})
+}
+
+// loadDeclined reserves a declined prefix read from the store until the end of
+// its quarantine. Prefixes which are out of quarantine are removed.
+func (h *Handler) loadDeclined(sl *leasestore.Lease, now time.Time) error {
+	if !sl.Expires.After(now) {
+		return h.store.Delete(sl.Key)
+	}
+	_, prefix, err := net.ParseCIDR(sl.Address)
+	if err != nil {
+		return err
+	}
+	allocated, err := h.allocator.Allocate(*prefix)
+	if err != nil {
+		return fmt.Errorf("failed to re-allocate declined prefix %s: %w", prefix, err)
+	}
+	if !samePrefix(&allocated, prefix) {
+		return fmt.Errorf("allocator did not re-allocate declined prefix %s: %s", prefix, &allocated)
+	}
+	h.declined[prefix.String()] = sl.Expires
+	return nil
+}
+
+// save persists the lease of a client, if the leases are stored. The lock
+// must be held.
+func (h *Handler) save(client string, l *lease) {
+	if h.store == nil {
+		return
+	}
+	err := h.store.Put(&leasestore.Lease{
+		Key:     storeKey(client, l),
+		Address: l.Prefix.String(),
+		Expires: l.Expire,
+	})
+	if err != nil {
+		log.Errorf("Could not persist lease of %s: %v", &l.Prefix, err)
+	}
+}
+
+// remove persists the removal of the lease of a client, if the leases are
+// stored. The lock must be held.
+func (h *Handler) remove(client string, l *lease) {
+	if h.store == nil {
+		return
+	}
+	if err := h.store.Delete(storeKey(client, l)); err != nil {
+		log.Errorf("Could not persist the removal of the lease of %s: %v", &l.Prefix, err)
+	}
+}
+
+// Close closes the lease store. It implements plugins.Closer.
+func (h *Handler) Close() error {
+	h.Lock()
+	defer h.Unlock()
+	if h.store == nil {
+		return nil
+	}
+	err := h.store.Close()
+	h.store = nil
+	return err
+}
+
+// Health checks that leases can still be saved, if the lease store can tell.
+// It implements plugins.HealthChecker.
+func (h *Handler) Health() error {
+	h.Lock()
+	defer h.Unlock()
+	if h.store == nil {
+		return errors.New("lease store is closed")
+	}
+	if hc, ok := h.store.(plugins.HealthChecker); ok {
+		return hc.Health()
+	}
+	return nil
 }
 
 type lease struct {
@@ -84,8 +260,14 @@ type Handler struct {
 	sync.Mutex
 	// Records has a string'd []byte as key, because []byte can't be a key itself
 	// Since it's not valid utf-8 we can't use any other string function though
-	Records   map[string][]lease
-	allocator allocators.Allocator
+	Records map[string][]lease
+	// declined holds the prefixes declined by clients, until when they are
+	// kept out of the pool
+	declined    map[string]time.Time
+	DeclineTime time.Duration
+	allocator   allocators.Allocator
+	// store is nil if the leases are not stored
+	store leasestore.Store
 }
 
 // samePrefix returns true if both prefixes are defined and equal
@@ -116,6 +298,15 @@ func (h *Handler) Handle(state *handler.PropagateState, req, resp dhcpv6.DHCPv6)
 		return nil, true
 	}
 
+	switch msg.Type() {
+	case dhcpv6.MessageTypeRelease, dhcpv6.MessageTypeDecline:
+		h.release(state, msg, client, resp)
+		return resp, false
+	}
+	h.Lock()
+	h.endQuarantines(time.Now())
+	h.Unlock()
+
 	// Each request IA_PD requires an IA_PD response
 	for _, iapd := range msg.Options.IAPD() {
 		if err != nil {
@@ -146,6 +337,8 @@ func (h *Handler) Handle(state *handler.PropagateState, req, resp dhcpv6.DHCPv6)
 		knownLeases := h.Records[recordKey(client)]
 		// Bitmap to track which leases are already given in this exchange
 		givenOut := bitset.New(uint(len(knownLeases)))
+		// Leases given out or extended, to persist
+		var changed []*lease
 
 		// This is, for now, a set of heuristics, to reconcile the requests (prefix hints asked
 		// by the clients) with what's on offer (existing leases for this client, plus new blocks)
@@ -163,6 +356,7 @@ func (h *Handler) Handle(state *handler.PropagateState, req, resp dhcpv6.DHCPv6)
 					satisfied.Set(uint(hintIdx))
 					givenOut.Set(uint(leaseIdx))
 					addPrefix(iapdResp, knownLeases[leaseIdx])
+					changed = append(changed, &knownLeases[leaseIdx])
 				}
 			}
 		}
@@ -194,6 +388,7 @@ func (h *Handler) Handle(state *handler.PropagateState, req, resp dhcpv6.DHCPv6)
 				satisfied.Set(uint(hintIdx))
 				givenOut.Set(uint(leaseIdx))
 				addPrefix(iapdResp, knownLeases[leaseIdx])
+				changed = append(changed, &knownLeases[leaseIdx])
 			}
 		}
 
@@ -229,12 +424,16 @@ func (h *Handler) Handle(state *handler.PropagateState, req, resp dhcpv6.DHCPv6)
 
 			addPrefix(iapdResp, l)
 			newLeases = append(knownLeases, l)
+			changed = append(changed, &l)
 			log.Debugf("Allocated %s to %s (IAID: %x)", &allocated, client, iapd.IaId)
 		}
 
 		if newLeases != nil {
 			h.Records[recordKey(client)] = newLeases
 		}
+		for _, l := range changed {
+			h.save(recordKey(client), l)
+		}
 		h.Unlock()
 
 		if len(iapdResp.Options.Options) == 0 {
@@ -245,6 +444,19 @@ func (h *Handler) Handle(state *handler.PropagateState, req, resp dhcpv6.DHCPv6)
 		}
 
 		resp.AddOption(iapdResp)
+
+		if evType, ok := events.TypeFromMessage6(msg); ok {
+			for _, p := range iapdResp.Options.Prefixes() {
+				events.Publish(events.Event{
+					Type:      evType,
+					Plugin:    pluginName,
+					DUID:      hex.EncodeToString(client.ToBytes()),
+					Prefix:    p.Prefix.String(),
+					Interface: state.InterfaceName,
+					Expiry:    time.Now().Add(p.ValidLifetime),
+				})
+			}
+		}
 	}
 
 	return resp, false
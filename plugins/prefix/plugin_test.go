@@ -5,11 +5,19 @@
 package prefix
 
 import (
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/plugins/leasestore"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	dhcpIana "github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRoundTrip(t *testing.T) {
@@ -20,8 +28,7 @@ func TestRoundTrip(t *testing.T) {
 		t.Fatal(err)
 	}
 	req.AddOption(dhcpv6.OptClientID(&dhcpv6.DUIDLL{
-		Type:          dhcpv6.DUID_LL,
-		HwType:        dhcpIana.HWTypeEthernet,
+		HWType:        dhcpIana.HWTypeEthernet,
 		LinkLayerAddr: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
 	}))
 	req.AddOption(&dhcpv6.OptIAPD{
@@ -35,12 +42,12 @@ func TestRoundTrip(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	handler, err := setupPrefix("2001:db8::/48", "64")
+	h, err := setupPrefix("2001:db8::/48", "64")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	result, final := handler(req, resp)
+	result, final := h(&handler.PropagateState{}, req, resp)
 	if final {
 		t.Log("Handler declared final")
 	}
@@ -92,3 +99,132 @@ func TestDup(t *testing.T) {
 		t.Fatalf("dup doesn't work: got %v expected %v", dupPrefix, prefix)
 	}
 }
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test_plugin_prefix")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	spec := filepath.Join(dir, "leases.txt")
+
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.AddOption(dhcpv6.OptClientID(&dhcpv6.DUIDLL{
+		HWType:        dhcpIana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+	}))
+	req.AddOption(&dhcpv6.OptIAPD{IaId: [4]uint8{1, 2, 3, 4}})
+	delegated := func(h handler.Handler6) *net.IPNet {
+		resp, err := dhcpv6.NewAdvertiseFromSolicit(req)
+		require.NoError(t, err)
+		result, _ := h(&handler.PropagateState{}, req, resp)
+		prefixes := result.(*dhcpv6.Message).Options.IAPD()[0].Options.Prefixes()
+		require.Len(t, prefixes, 1)
+		return prefixes[0].Prefix
+	}
+
+	// use another prefix than the first one the allocator would give out
+	h, err := newHandler("2001:db8::/48", "64", spec)
+	require.NoError(t, err)
+	_, err = h.allocator.Allocate(net.IPNet{})
+	require.NoError(t, err)
+	first := delegated(h.Handle)
+	require.NoError(t, h.Close())
+
+	// the lease is loaded again after a restart
+	h, err = newHandler("2001:db8::/48", "64", spec)
+	require.NoError(t, err)
+	defer h.Close()
+	leases := h.Records[recordKey(req.Options.ClientID())]
+	if assert.Len(t, leases, 1) {
+		assert.Equal(t, first.String(), leases[0].Prefix.String())
+	}
+	// and its prefix is not given out again
+	again, err := h.allocator.Allocate(*first)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.String(), again.String())
+
+	_, err = setupPrefix("2001:db8::/48", "64", "bolt:")
+	assert.Error(t, err)
+}
+
+func TestReleaseDecline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test_plugin_prefix")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	spec := filepath.Join(dir, "leases.txt")
+
+	h, err := newHandler("2001:db8::/62", "64", spec, "1h")
+	require.NoError(t, err)
+	defer h.Close()
+
+	duid := &dhcpv6.DUIDLL{
+		HWType:        dhcpIana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+	}
+	// exchange sends a message of the given type with the IA_PD, and returns
+	// the IA_PDs of the reply
+	exchange := func(typ dhcpv6.MessageType, iapd *dhcpv6.OptIAPD) []*dhcpv6.OptIAPD {
+		req, err := dhcpv6.NewMessage()
+		require.NoError(t, err)
+		req.MessageType = typ
+		req.AddOption(dhcpv6.OptClientID(duid))
+		req.AddOption(iapd)
+		resp := &dhcpv6.Message{MessageType: dhcpv6.MessageTypeReply, TransactionID: req.TransactionID}
+		result, _ := h.Handle(&handler.PropagateState{}, req, resp)
+		return result.(*dhcpv6.Message).Options.IAPD()
+	}
+	iaid := [4]uint8{1, 2, 3, 4}
+	request := func() *net.IPNet {
+		iapds := exchange(dhcpv6.MessageTypeRequest, &dhcpv6.OptIAPD{IaId: iaid})
+		require.Len(t, iapds, 1)
+		prefixes := iapds[0].Options.Prefixes()
+		require.Len(t, prefixes, 1)
+		return prefixes[0].Prefix
+	}
+	with := func(p *net.IPNet) *dhcpv6.OptIAPD {
+		return &dhcpv6.OptIAPD{IaId: iaid, Options: dhcpv6.PDOptions{Options: dhcpv6.Options{
+			&dhcpv6.OptIAPrefix{Prefix: p},
+		}}}
+	}
+
+	// releasing a prefix frees it
+	first := request()
+	iapds := exchange(dhcpv6.MessageTypeRelease, with(first))
+	if assert.Len(t, iapds, 1) {
+		assert.Equal(t, dhcpIana.StatusSuccess, iapds[0].Options.Status().StatusCode)
+	}
+	assert.Empty(t, h.Records)
+	_, err = h.store.Get(storeKey(recordKey(duid), &lease{Prefix: *first}))
+	assert.Equal(t, leasestore.ErrNotFound, err)
+	again, err := h.allocator.Allocate(*first)
+	require.NoError(t, err)
+	assert.Equal(t, first.String(), again.String())
+	require.NoError(t, h.allocator.Free(again))
+
+	// releasing an unknown binding isn't answered, the server does it
+	assert.Empty(t, exchange(dhcpv6.MessageTypeRelease, with(first)))
+
+	// declining a prefix quarantines it
+	declined := request()
+	iapds = exchange(dhcpv6.MessageTypeDecline, with(declined))
+	if assert.Len(t, iapds, 1) {
+		assert.Equal(t, dhcpIana.StatusSuccess, iapds[0].Options.Status().StatusCode)
+	}
+	assert.Contains(t, h.declined, declined.String())
+	assert.NotEqual(t, declined.String(), request().String())
+	require.NoError(t, h.Close())
+
+	// even after a restart
+	h, err = newHandler("2001:db8::/62", "64", spec, "1h")
+	require.NoError(t, err)
+	assert.Contains(t, h.declined, declined.String())
+	other, err := h.allocator.Allocate(*declined)
+	require.NoError(t, err)
+	assert.NotEqual(t, declined.String(), other.String())
+
+	// until its quarantine ends
+	h.endQuarantines(time.Now().Add(time.Hour))
+	assert.Empty(t, h.declined)
+	_, err = h.store.Get(declinedKeyPrefix + declined.String())
+	assert.Equal(t, leasestore.ErrNotFound, err)
+}
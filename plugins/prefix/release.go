@@ -0,0 +1,129 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package prefix
+
+import (
+	"encoding/hex"
+	"net"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	dhcpIana "github.com/insomniacslk/dhcp/iana"
+
+	"github.com/coredhcp/coredhcp/events"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/plugins/leasestore"
+)
+
+// release handles Release and Decline messages: the released prefixes are
+// freed, and the declined ones kept out of the pool for the decline time. The
+// IA_PDs holding prefixes leased to the client get a Success status, the
+// server answers the others with NoBinding.
+func (h *Handler) release(state *handler.PropagateState, msg *dhcpv6.Message, client dhcpv6.DUID, resp dhcpv6.DHCPv6) {
+	decline := msg.Type() == dhcpv6.MessageTypeDecline
+	evType, _ := events.TypeFromMessage6(msg)
+	key := recordKey(client)
+	now := time.Now()
+
+	h.Lock()
+	defer h.Unlock()
+	for _, iapd := range msg.Options.IAPD() {
+		bound := false
+		for _, p := range iapd.Options.Prefixes() {
+			l, ok := h.take(key, p.Prefix)
+			if !ok {
+				continue
+			}
+			bound = true
+			h.remove(key, &l)
+			if decline {
+				log.Warningf("Client %s declined %s, quarantining it for %s", client, &l.Prefix, h.DeclineTime)
+				h.quarantine(&l.Prefix, now)
+			} else {
+				log.Debugf("Client %s released %s", client, &l.Prefix)
+				if err := h.allocator.Free(l.Prefix); err != nil {
+					log.Warningf("Could not free released prefix %s: %v", &l.Prefix, err)
+				}
+			}
+			events.Publish(events.Event{
+				Type:      evType,
+				Plugin:    pluginName,
+				DUID:      hex.EncodeToString(client.ToBytes()),
+				Prefix:    l.Prefix.String(),
+				Interface: state.InterfaceName,
+				Expiry:    l.Expire,
+			})
+		}
+		if bound {
+			resp.AddOption(&dhcpv6.OptIAPD{
+				IaId: iapd.IaId,
+				Options: dhcpv6.PDOptions{Options: dhcpv6.Options{
+					&dhcpv6.OptStatusCode{StatusCode: dhcpIana.StatusSuccess},
+				}},
+			})
+		}
+	}
+}
+
+// take removes a prefix from the leases of a client, and returns its lease.
+// The lock must be held.
+func (h *Handler) take(key string, prefix *net.IPNet) (lease, bool) {
+	leases := h.Records[key]
+	for i := range leases {
+		if !samePrefix(prefix, &leases[i].Prefix) {
+			continue
+		}
+		l := leases[i]
+		leases = append(leases[:i], leases[i+1:]...)
+		if len(leases) == 0 {
+			delete(h.Records, key)
+		} else {
+			h.Records[key] = leases
+		}
+		return l, true
+	}
+	return lease{}, false
+}
+
+// quarantine keeps a declined prefix reserved in the allocator for the decline
+// time, and persists it. The lock must be held.
+func (h *Handler) quarantine(prefix *net.IPNet, now time.Time) {
+	until := now.Add(h.DeclineTime)
+	h.declined[prefix.String()] = until
+	if h.store == nil {
+		return
+	}
+	err := h.store.Put(&leasestore.Lease{
+		Key:     declinedKeyPrefix + prefix.String(),
+		Address: prefix.String(),
+		Expires: until,
+	})
+	if err != nil {
+		log.Errorf("Could not persist declined prefix %s: %v", prefix, err)
+	}
+}
+
+// endQuarantines frees the declined prefixes whose quarantine ended before now.
+// The lock must be held.
+func (h *Handler) endQuarantines(now time.Time) {
+	for p, until := range h.declined {
+		if now.Before(until) {
+			continue
+		}
+		delete(h.declined, p)
+		_, prefix, err := net.ParseCIDR(p)
+		if err != nil {
+			continue
+		}
+		if err := h.allocator.Free(*prefix); err != nil {
+			log.Warningf("Could not free declined prefix %s: %v", p, err)
+		}
+		if h.store != nil {
+			if err := h.store.Delete(declinedKeyPrefix + p); err != nil {
+				log.Errorf("Could not persist the end of the quarantine of %s: %v", p, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// The kinds of identifiers a lease key is made of
+const (
+	keyMAC       = "mac"
+	keyClientID  = "client-id"
+	keyCircuitID = "circuit-id"
+)
+
+// keySpec tells how the key identifying the lease of a client is made. Keys
+// are written "<kind>=<value>", eg. "mac=02:00:00:00:00:01" or
+// "client-id+circuit-id=01020000000001,657468302f31", so that the store
+// records how each lease was keyed.
+type keySpec struct {
+	// clientID uses the client identifier (option 61) when the client
+	// sends one, rather than its hardware address
+	clientID bool
+	// circuitID adds the circuit ID of the relay agent (option 82), when the
+	// request was relayed
+	circuitID bool
+}
+
+// parseKeySpec parses a lease key specification: "mac" (the default),
+// "client-id", "mac+circuit-id" or "client-id+circuit-id"
+func parseKeySpec(s string) (keySpec, error) {
+	var spec keySpec
+	switch s {
+	case "", keyMAC:
+	case keyClientID:
+		spec.clientID = true
+	case keyMAC + "+" + keyCircuitID:
+		spec.circuitID = true
+	case keyClientID + "+" + keyCircuitID:
+		spec.clientID = true
+		spec.circuitID = true
+	default:
+		return spec, fmt.Errorf("invalid lease key %q, want one of %s, %s, %s+%s or %s+%s",
+			s, keyMAC, keyClientID, keyMAC, keyCircuitID, keyClientID, keyCircuitID)
+	}
+	return spec, nil
+}
+
+// leaseKey returns the key of the lease of the client sending a request
+func (k keySpec) leaseKey(req *dhcpv4.DHCPv4) string {
+	kind, value := keyMAC, req.ClientHWAddr.String()
+	if k.clientID {
+		if id := req.Options.Get(dhcpv4.OptionClientIdentifier); len(id) > 0 {
+			kind, value = keyClientID, hex.EncodeToString(id)
+		}
+	}
+	if k.circuitID {
+		if rai := req.RelayAgentInfo(); rai != nil {
+			if id := rai.Get(dhcpv4.AgentCircuitIDSubOption); len(id) > 0 {
+				kind += "+" + keyCircuitID
+				value += "," + hex.EncodeToString(id)
+			}
+		}
+	}
+	return kind + "=" + value
+}
+
+// macKey returns the key of a lease identified by the hardware address only,
+// which is how all the leases were keyed before the kind was recorded
+func macKey(mac net.HardwareAddr) string {
+	return keyMAC + "=" + mac.String()
+}
+
+// parseKey checks a key read from the lease store, and converts the keys
+// written before the kind was recorded, which are bare hardware addresses
+func parseKey(key string) (string, error) {
+	i := strings.IndexByte(key, '=')
+	if i < 0 {
+		mac, err := net.ParseMAC(key)
+		if err != nil {
+			return "", fmt.Errorf("malformed hardware address: %s", key)
+		}
+		return macKey(mac), nil
+	}
+	if i == 0 || i == len(key)-1 {
+		return "", fmt.Errorf("malformed lease key: %s", key)
+	}
+	return key, nil
+}
+
+// keyMACAddress returns the hardware address in a lease key, or an empty
+// string if the lease isn't keyed by hardware address
+func keyMACAddress(key string) string {
+	i := strings.IndexByte(key, '=')
+	if i < 0 {
+		return ""
+	}
+	kind, value := key[:i], key[i+1:]
+	if kind != keyMAC && !strings.HasPrefix(kind, keyMAC+"+") {
+		return ""
+	}
+	if j := strings.IndexByte(value, ','); j >= 0 {
+		value = value[:j]
+	}
+	return value
+}
+
+// keySpecs are all the ways lease keys can be made, tried in this order when
+// looking for the lease of a client stored under another key
+var keySpecs = []keySpec{
+	{},
+	{clientID: true},
+	{circuitID: true},
+	{clientID: true, circuitID: true},
+}
+
+// migrateKey moves the lease of the client sending a request to the
+// configured key, when it is stored under another key identifying the same
+// client, eg. before the key was configured or changed. The lock must be
+// held.
+func (p *PluginState) migrateKey(key string, req *dhcpv4.DHCPv4) {
+	if _, ok := p.Recordsv4[key]; ok {
+		return
+	}
+	for _, spec := range keySpecs {
+		old := spec.leaseKey(req)
+		if old == key {
+			continue
+		}
+		rec, ok := p.Recordsv4[old]
+		if !ok {
+			continue
+		}
+		delete(p.Recordsv4, old)
+		p.Recordsv4[key] = rec
+		if err := p.saveIPAddress(key, rec); err != nil {
+			log.Errorf("Could not persist lease of %s as %s: %v", old, key, err)
+			return
+		}
+		if err := p.saveRemoval(old); err != nil {
+			log.Errorf("Could not persist the removal of lease %s: %v", old, err)
+		}
+		log.Infof("Lease of %s is now keyed by %s", old, key)
+		return
+	}
+}
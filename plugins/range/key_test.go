@@ -0,0 +1,96 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaseKey(t *testing.T) {
+	mac := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	clientID := dhcpv4.OptClientIdentifier([]byte{1, 2, 0, 0, 0, 0, 1})
+	circuitID := dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth0")))
+
+	for _, tt := range []struct {
+		spec string
+		opts []dhcpv4.Option
+		key  string
+	}{
+		{"", nil, "mac=02:00:00:00:00:01"},
+		{"mac", []dhcpv4.Option{clientID, circuitID}, "mac=02:00:00:00:00:01"},
+		{"client-id", nil, "mac=02:00:00:00:00:01"},
+		{"client-id", []dhcpv4.Option{clientID}, "client-id=01020000000001"},
+		{"mac+circuit-id", nil, "mac=02:00:00:00:00:01"},
+		{"mac+circuit-id", []dhcpv4.Option{circuitID}, "mac+circuit-id=02:00:00:00:00:01,65746830"},
+		{"client-id+circuit-id", []dhcpv4.Option{circuitID}, "mac+circuit-id=02:00:00:00:00:01,65746830"},
+		{"client-id+circuit-id", []dhcpv4.Option{clientID, circuitID}, "client-id+circuit-id=01020000000001,65746830"},
+	} {
+		spec, err := parseKeySpec(tt.spec)
+		require.NoError(t, err)
+		modifiers := []dhcpv4.Modifier{dhcpv4.WithHwAddr(mac)}
+		for _, o := range tt.opts {
+			modifiers = append(modifiers, dhcpv4.WithOption(o))
+		}
+		req, err := dhcpv4.New(modifiers...)
+		require.NoError(t, err)
+		key := spec.leaseKey(req)
+		assert.Equal(t, tt.key, key, tt.spec)
+		parsed, err := parseKey(key)
+		assert.NoError(t, err)
+		assert.Equal(t, key, parsed)
+	}
+
+	_, err := parseKeySpec("circuit-id")
+	assert.Error(t, err)
+}
+
+func TestKeyMACAddress(t *testing.T) {
+	assert.Equal(t, "02:00:00:00:00:01", keyMACAddress("mac=02:00:00:00:00:01"))
+	assert.Equal(t, "02:00:00:00:00:01", keyMACAddress("mac+circuit-id=02:00:00:00:00:01,65746830"))
+	assert.Equal(t, "", keyMACAddress("client-id=01020000000001"))
+	assert.Equal(t, "", keyMACAddress("02:00:00:00:00:01"))
+}
+
+func TestClientIDKey(t *testing.T) {
+	p, filename := newTestState(t)
+	defer os.Remove(filename)
+	defer p.Close()
+	mac := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+
+	// a lease keyed by hardware address is moved to the client identifier
+	ip := lease(t, p, mac)
+	require.NotNil(t, ip)
+	p.key.clientID = true
+	clientID := dhcpv4.OptClientIdentifier([]byte{1, 2, 0, 0, 0, 0, 1})
+	assert.Equal(t, ip, exchange(t, p, dhcpv4.MessageTypeDiscover, mac, clientID))
+	assert.NotContains(t, p.Recordsv4, macKey(mac))
+	assert.Contains(t, p.Recordsv4, "client-id=01020000000001")
+	_, err := p.store.Get(macKey(mac))
+	assert.Error(t, err)
+	_, err = p.store.Get("client-id=01020000000001")
+	assert.NoError(t, err)
+
+	// the same hardware address with another client identifier is another
+	// client
+	other := dhcpv4.OptClientIdentifier([]byte{1, 2, 0, 0, 0, 0, 2})
+	ip2 := exchange(t, p, dhcpv4.MessageTypeDiscover, mac, other)
+	require.NotNil(t, ip2)
+	assert.NotEqual(t, ip, ip2)
+
+	// a lease keyed by client identifier is moved back when the key changes
+	p.key = keySpec{circuitID: true}
+	circuitID := dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth0")))
+	assert.Equal(t, ip, exchange(t, p, dhcpv4.MessageTypeDiscover, mac, clientID, circuitID))
+	assert.NotContains(t, p.Recordsv4, "client-id=01020000000001")
+	assert.Contains(t, p.Recordsv4, "mac+circuit-id=02:00:00:00:00:01,65746830")
+	_, err = p.store.Get("client-id=01020000000001")
+	assert.Error(t, err)
+}
@@ -0,0 +1,95 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// leaseTime returns the lease time given to a client: the shortest lease
+// time of its classes if it is in classes with one, or else the lease time
+// it requested (option 51) within the bounds, or else the default one
+func (p *PluginState) leaseTime(state *handler.PropagateState, req *dhcpv4.DHCPv4) time.Duration {
+	var (
+		d       time.Duration
+		inClass bool
+	)
+	for _, class := range state.Classes {
+		if c, ok := p.classLeaseTimes[class]; ok && (!inClass || c < d) {
+			d, inClass = c, true
+		}
+	}
+	if inClass {
+		return d
+	}
+	d = req.IPAddressLeaseTime(p.LeaseTime)
+	if d < p.MinLeaseTime {
+		d = p.MinLeaseTime
+	}
+	if d > p.MaxLeaseTime {
+		d = p.MaxLeaseTime
+	}
+	return d
+}
+
+// setLeaseTime sets the lease time option of a response, and the renewal
+// (T1) and rebinding (T2) times when they are configured
+func (p *PluginState) setLeaseTime(resp *dhcpv4.DHCPv4, d time.Duration) {
+	resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(d.Round(time.Second)))
+	if p.T1Ratio > 0 {
+		t1 := time.Duration(float64(d) * p.T1Ratio).Round(time.Second)
+		resp.Options.Update(dhcpv4.Option{Code: dhcpv4.OptionRenewTimeValue, Value: dhcpv4.Duration(t1)})
+	}
+	if p.T2Ratio > 0 {
+		t2 := time.Duration(float64(d) * p.T2Ratio).Round(time.Second)
+		resp.Options.Update(dhcpv4.Option{Code: dhcpv4.OptionRebindingTimeValue, Value: dhcpv4.Duration(t2)})
+	}
+}
+
+// setupLeaseTimes checks the lease time policy of a configuration, and
+// applies it with its defaults
+func (p *PluginState) setupLeaseTimes(conf *Config) error {
+	if conf.LeaseTime <= 0 {
+		return fmt.Errorf("invalid lease duration: %v", conf.LeaseTime)
+	}
+	p.LeaseTime = conf.LeaseTime
+	p.MinLeaseTime, p.MaxLeaseTime = conf.MinLeaseTime, conf.MaxLeaseTime
+	if p.MinLeaseTime == 0 {
+		p.MinLeaseTime = p.LeaseTime
+	}
+	if p.MaxLeaseTime == 0 {
+		p.MaxLeaseTime = p.LeaseTime
+	}
+	if p.MinLeaseTime < 0 || p.MinLeaseTime > p.LeaseTime {
+		return fmt.Errorf("invalid minimum lease time %v, want at most the lease time %v", conf.MinLeaseTime, p.LeaseTime)
+	}
+	if p.MaxLeaseTime < p.LeaseTime {
+		return fmt.Errorf("invalid maximum lease time %v, want at least the lease time %v", conf.MaxLeaseTime, p.LeaseTime)
+	}
+
+	if conf.T1Ratio < 0 || conf.T1Ratio >= 1 {
+		return fmt.Errorf("invalid T1 ratio %v, want between 0 and 1", conf.T1Ratio)
+	}
+	if conf.T2Ratio < 0 || conf.T2Ratio >= 1 {
+		return fmt.Errorf("invalid T2 ratio %v, want between 0 and 1", conf.T2Ratio)
+	}
+	if conf.T1Ratio > 0 && conf.T2Ratio > 0 && conf.T1Ratio >= conf.T2Ratio {
+		return fmt.Errorf("invalid T1 ratio %v, want lower than the T2 ratio %v", conf.T1Ratio, conf.T2Ratio)
+	}
+	p.T1Ratio, p.T2Ratio = conf.T1Ratio, conf.T2Ratio
+
+	p.classLeaseTimes = make(map[string]time.Duration, len(conf.ClassLeaseTimes))
+	for class, d := range conf.ClassLeaseTimes {
+		if d <= 0 {
+			return fmt.Errorf("invalid lease time of class %s: %v", class, d)
+		}
+		p.classLeaseTimes[class] = d
+	}
+	return nil
+}
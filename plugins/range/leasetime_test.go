@@ -0,0 +1,101 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupLeaseTimes(t *testing.T) {
+	var p PluginState
+	require.NoError(t, p.setupLeaseTimes(&Config{LeaseTime: time.Hour}))
+	assert.Equal(t, time.Hour, p.MinLeaseTime)
+	assert.Equal(t, time.Hour, p.MaxLeaseTime)
+
+	for _, conf := range []Config{
+		{},
+		{LeaseTime: time.Hour, MinLeaseTime: 2 * time.Hour},
+		{LeaseTime: time.Hour, MinLeaseTime: -time.Minute},
+		{LeaseTime: time.Hour, MaxLeaseTime: time.Minute},
+		{LeaseTime: time.Hour, T1Ratio: 1},
+		{LeaseTime: time.Hour, T2Ratio: -0.5},
+		{LeaseTime: time.Hour, T1Ratio: 0.9, T2Ratio: 0.5},
+		{LeaseTime: time.Hour, ClassLeaseTimes: map[string]time.Duration{"guests": 0}},
+	} {
+		assert.Error(t, p.setupLeaseTimes(&conf), conf)
+	}
+}
+
+func TestLeaseTime(t *testing.T) {
+	p, filename := newTestState(t)
+	defer os.Remove(filename)
+	defer p.Close()
+	require.NoError(t, p.setupLeaseTimes(&Config{
+		LeaseTime:       time.Hour,
+		MinLeaseTime:    10 * time.Minute,
+		MaxLeaseTime:    24 * time.Hour,
+		T1Ratio:         0.5,
+		T2Ratio:         0.875,
+		ClassLeaseTimes: map[string]time.Duration{"guests": 30 * time.Minute, "wired": 24 * time.Hour},
+	}))
+
+	for _, tt := range []struct {
+		classes   []string
+		requested time.Duration
+		want      time.Duration
+	}{
+		{nil, 0, time.Hour},
+		{nil, 2 * time.Hour, 2 * time.Hour},
+		{nil, time.Minute, 10 * time.Minute},
+		{nil, 48 * time.Hour, 24 * time.Hour},
+		{[]string{"wired"}, 2 * time.Hour, 24 * time.Hour},
+		{[]string{"wired", "guests"}, 0, 30 * time.Minute},
+		{[]string{"lab"}, 0, time.Hour},
+	} {
+		mac := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+		modifiers := []dhcpv4.Modifier{dhcpv4.WithHwAddr(mac), dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover)}
+		if tt.requested > 0 {
+			modifiers = append(modifiers, dhcpv4.WithOption(dhcpv4.OptIPAddressLeaseTime(tt.requested)))
+		}
+		req, err := dhcpv4.New(modifiers...)
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		resp, _ = p.Handler4(&handler.PropagateState{Classes: tt.classes}, req, resp)
+		require.NotNil(t, resp)
+		assert.Equal(t, tt.want, resp.IPAddressLeaseTime(0), tt)
+		assert.Equal(t, tt.want/2, resp.IPAddressRenewalTime(0), tt)
+		assert.Equal(t, tt.want*7/8, resp.IPAddressRebindingTime(0), tt)
+	}
+
+	// the lease lasts as long as given
+	mac := net.HardwareAddr{2, 0, 0, 0, 0, 2}
+	require.NotNil(t, discover(t, p, mac))
+	before := time.Now()
+	require.NotNil(t, exchange(t, p, dhcpv4.MessageTypeRequest, mac,
+		dhcpv4.OptServerIdentifier(serverID), dhcpv4.OptIPAddressLeaseTime(20*time.Minute)))
+	expires := p.Recordsv4[macKey(mac)].expires
+	assert.WithinDuration(t, before.Add(20*time.Minute), expires, time.Second)
+}
+
+func TestNoRenewalTimes(t *testing.T) {
+	p, filename := newTestState(t)
+	defer os.Remove(filename)
+	defer p.Close()
+
+	resp := handle(t, p, dhcpv4.MessageTypeDiscover, net.HardwareAddr{2, 0, 0, 0, 0, 1})
+	require.NotNil(t, resp)
+	assert.Equal(t, time.Hour, resp.IPAddressLeaseTime(0))
+	assert.False(t, resp.Options.Has(dhcpv4.OptionRenewTimeValue))
+	assert.False(t, resp.Options.Has(dhcpv4.OptionRebindingTimeValue))
+}
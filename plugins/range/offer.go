@@ -0,0 +1,200 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"net"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultOfferTimeout is how long an offered address is held for the client
+// by default
+const defaultOfferTimeout = 30 * time.Second
+
+// offer is an address offered to a client, which holds it until the client
+// requests it or the offer expires. Offers are only kept in memory.
+type offer struct {
+	IP      net.IP
+	expires time.Time
+	// probed is set once the address was found free
+	probed bool
+}
+
+// allocate reserves a free address, the hint if it is free, skipping the
+// addresses reserved by other plugins. The lock must be held.
+func (p *PluginState) allocate(clog *logrus.Entry, hint net.IP, now time.Time) (net.IP, error) {
+	for {
+		ip, err := p.allocateAny(clog, hint, now)
+		if err != nil {
+			return nil, err
+		}
+		if !p.skip(clog, ip) {
+			return ip, nil
+		}
+		hint = nil
+	}
+}
+
+// allocateAny reserves a free address, the hint if it is free, reclaiming
+// expired offers, abandoned addresses and leases if the pool is full. The lock must be held.
+func (p *PluginState) allocateAny(clog *logrus.Entry, hint net.IP, now time.Time) (net.IP, error) {
+	ip, err := p.allocator.Allocate(net.IPNet{IP: hint})
+	if err == allocators.ErrNoAddrAvail && p.expireOffers(now)+p.releaseAbandoned(now) > 0 {
+		clog.Info("Pool is full, withdrew expired offers and abandoned addresses")
+		ip, err = p.allocator.Allocate(net.IPNet{IP: hint})
+	}
+	if err == allocators.ErrNoAddrAvail && p.reclaimOldest(now) {
+		clog.Info("Pool is full, reclaimed the oldest expired lease")
+		ip, err = p.allocator.Allocate(net.IPNet{IP: hint})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ip.IP.To4(), nil
+}
+
+// makeOffer returns the address offered to a client without a lease: the
+// address of its pending offer, or a newly allocated one, preferably the hint
+// the client requested. Nothing is persisted. The lock must be held.
+func (p *PluginState) makeOffer(clog *logrus.Entry, key string, hint net.IP, now time.Time) (net.IP, error) {
+	if o, ok := p.offers[key]; ok && now.Before(o.expires) {
+		o.expires = now.Add(p.OfferTimeout)
+		return o.IP, nil
+	}
+	p.withdrawOffer(key)
+	ip, err := p.allocate(clog, hint, now)
+	if err != nil {
+		return nil, err
+	}
+	p.offers[key] = &offer{IP: ip, expires: now.Add(p.OfferTimeout)}
+	return ip, nil
+}
+
+// withdrawOffer frees the address offered to a client, if any. The lock must
+// be held.
+func (p *PluginState) withdrawOffer(key string) {
+	o, ok := p.offers[key]
+	if !ok {
+		return
+	}
+	delete(p.offers, key)
+	if err := p.allocator.Free(net.IPNet{IP: o.IP}); err != nil {
+		log.Warningf("Could not free offered address %s: %v", o.IP, err)
+	}
+}
+
+// expireOffers withdraws the offers that expired before now, and returns how
+// many were. The lock must be held.
+func (p *PluginState) expireOffers(now time.Time) int {
+	n := 0
+	for key, o := range p.offers {
+		if !now.Before(o.expires) {
+			p.withdrawOffer(key)
+			n++
+		}
+	}
+	return n
+}
+
+// commit returns the lease of a client requesting an address: its current
+// lease, extended, or a new lease for the address it was offered, or else for
+// a newly allocated address. The lease is persisted. The lock must be held.
+func (p *PluginState) commit(clog *logrus.Entry, key string, leaseTime time.Duration, now time.Time) (*Record, error) {
+	record, ok := p.Recordsv4[key]
+	if ok {
+		// Ensure we extend the existing lease at least past when the one we're giving expires
+		if record.expires.Before(now.Add(leaseTime)) {
+			record.expires = now.Add(leaseTime).Round(time.Second)
+			if err := p.saveIPAddress(key, record); err != nil {
+				clog.Errorf("Could not persist lease: %v", err)
+			}
+		}
+		return record, nil
+	}
+	var ip net.IP
+	if o, ok := p.offers[key]; ok {
+		// the address stays allocated, for the lease now
+		delete(p.offers, key)
+		ip = o.IP
+	} else {
+		clog.Info("Client is new, leasing new IPv4 address")
+		var err error
+		if ip, err = p.allocate(clog, nil, now); err != nil {
+			return nil, err
+		}
+	}
+	record = &Record{
+		IP:      ip,
+		expires: now.Add(leaseTime),
+	}
+	if err := p.saveIPAddress(key, record); err != nil {
+		clog.Errorf("SaveIPAddress failed: %v", err)
+	}
+	p.Recordsv4[key] = record
+	return record, nil
+}
+
+// selectedUs tells whether a DHCPREQUEST is for us: a client in SELECTING
+// state names the server whose offer it accepts in the server identifier
+// option, which must then be the identifier in our response. Requests without
+// it (renewals, INIT-REBOOT) are for us. The same goes for DHCPRELEASE and
+// DHCPDECLINE, which name the server of the lease.
+func selectedUs(req, resp *dhcpv4.DHCPv4) bool {
+	requested := req.ServerIdentifier()
+	ours := resp.ServerIdentifier()
+	if requested == nil || ours == nil {
+		return true
+	}
+	return requested.Equal(ours)
+}
+
+// requestedAddress returns the address a DHCPREQUEST is for: the requested IP
+// address option in SELECTING and INIT-REBOOT states, or ciaddr when renewing
+// or rebinding. It is nil if the request names no address.
+func requestedAddress(req *dhcpv4.DHCPv4) net.IP {
+	if ip := req.RequestedIPAddress(); ip != nil && !ip.IsUnspecified() {
+		return ip.To4()
+	}
+	if ip := req.ClientIPAddr; ip != nil && !ip.IsUnspecified() {
+		return ip.To4()
+	}
+	return nil
+}
+
+// isOurs tells whether the address a DHCPREQUEST is for is the one leased or
+// offered to the client with the lease key. Requests naming no address are
+// let through, and get a new lease. The lock must be held.
+func (p *PluginState) isOurs(req *dhcpv4.DHCPv4, key string) bool {
+	requested := requestedAddress(req)
+	if requested == nil {
+		return true
+	}
+	if rec, ok := p.Recordsv4[key]; ok {
+		return requested.Equal(rec.IP)
+	}
+	if o, ok := p.offers[key]; ok {
+		return requested.Equal(o.IP)
+	}
+	return false
+}
+
+// nak turns a response into a DHCPNAK, keeping only the options RFC 2131
+// allows in it
+func nak(resp *dhcpv4.DHCPv4) *dhcpv4.DHCPv4 {
+	serverID := resp.ServerIdentifier()
+	resp.Options = dhcpv4.Options{}
+	resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeNak))
+	if serverID != nil {
+		resp.UpdateOption(dhcpv4.OptServerIdentifier(serverID))
+	}
+	resp.YourIPAddr = net.IPv4zero
+	resp.ClientIPAddr = net.IPv4zero
+	resp.ServerIPAddr = net.IPv4zero
+	return resp
+}
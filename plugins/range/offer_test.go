@@ -0,0 +1,146 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/leasestore"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffer(t *testing.T) {
+	p, filename := newTestState(t)
+	defer os.Remove(filename)
+	defer p.Close()
+
+	mac1 := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	ip1 := discover(t, p, mac1)
+	require.NotNil(t, ip1)
+	// the offer is held, but not leased nor persisted
+	assert.Equal(t, ip1, discover(t, p, mac1))
+	assert.NotContains(t, p.Recordsv4, macKey(mac1))
+	_, err := p.store.Get(macKey(mac1))
+	assert.Equal(t, leasestore.ErrNotFound, err)
+
+	// the offered address isn't offered to others
+	mac2 := net.HardwareAddr{2, 0, 0, 0, 0, 2}
+	ip2 := discover(t, p, mac2)
+	require.NotNil(t, ip2)
+	assert.NotEqual(t, ip1, ip2)
+
+	// a request selecting us commits the offer
+	assert.Equal(t, ip1, exchange(t, p, dhcpv4.MessageTypeRequest, mac1,
+		dhcpv4.OptServerIdentifier(serverID)))
+	assert.NotContains(t, p.offers, macKey(mac1))
+	assert.Contains(t, p.Recordsv4, macKey(mac1))
+	l, err := p.store.Get(macKey(mac1))
+	require.NoError(t, err)
+	assert.Equal(t, ip1.String(), l.Address)
+
+	// a request selecting another server withdraws the offer
+	assert.Nil(t, exchange(t, p, dhcpv4.MessageTypeRequest, mac2,
+		dhcpv4.OptServerIdentifier(net.IPv4(10, 0, 0, 253))))
+	assert.NotContains(t, p.offers, macKey(mac2))
+	assert.NotContains(t, p.Recordsv4, macKey(mac2))
+	mac3 := net.HardwareAddr{2, 0, 0, 0, 0, 3}
+	assert.Equal(t, ip2, discover(t, p, mac3))
+}
+
+func TestOfferExpiry(t *testing.T) {
+	p, filename := newTestState(t)
+	defer os.Remove(filename)
+	defer p.Close()
+
+	mac1 := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	mac2 := net.HardwareAddr{2, 0, 0, 0, 0, 2}
+	mac3 := net.HardwareAddr{2, 0, 0, 0, 0, 3}
+	ip1 := discover(t, p, mac1)
+	require.NotNil(t, ip1)
+	require.NotNil(t, discover(t, p, mac2))
+
+	// the pool is full of pending offers
+	assert.Nil(t, discover(t, p, mac3))
+
+	// an expired offer is withdrawn to make room
+	p.offers[macKey(mac1)].expires = time.Now().Add(-time.Second)
+	assert.Equal(t, ip1, discover(t, p, mac3))
+	assert.NotContains(t, p.offers, macKey(mac1))
+
+	// and by the maintenance
+	p.maintain(time.Now().Add(2 * p.OfferTimeout))
+	assert.Empty(t, p.offers)
+}
+
+func TestRequestedAddress(t *testing.T) {
+	p, filename := newTestState(t)
+	defer os.Remove(filename)
+	defer p.Close()
+
+	mac1 := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	mac2 := net.HardwareAddr{2, 0, 0, 0, 0, 2}
+	ip1 := net.IPv4(10, 0, 0, 1).To4()
+	ip2 := net.IPv4(10, 0, 0, 2).To4()
+
+	// the requested address is offered if it is free
+	assert.Equal(t, ip2, exchange(t, p, dhcpv4.MessageTypeDiscover, mac1,
+		dhcpv4.OptRequestedIPAddress(ip2)).To4())
+	assert.Equal(t, ip1, exchange(t, p, dhcpv4.MessageTypeDiscover, mac2,
+		dhcpv4.OptRequestedIPAddress(ip2)).To4())
+
+	// SELECTING for an address that wasn't offered is ignored
+	assert.Nil(t, handle(t, p, dhcpv4.MessageTypeRequest, mac1,
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(serverID)),
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(ip1))))
+	// or refused by an authoritative server
+	p.Authoritative = true
+	resp := handle(t, p, dhcpv4.MessageTypeRequest, mac1,
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(serverID)),
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(ip1)))
+	require.NotNil(t, resp)
+	assert.Equal(t, dhcpv4.MessageTypeNak, resp.MessageType())
+	assert.True(t, resp.YourIPAddr.IsUnspecified())
+	assert.Nil(t, resp.Options.Get(dhcpv4.OptionIPAddressLeaseTime))
+	assert.Equal(t, serverID.To4(), resp.ServerIdentifier().To4())
+	assert.NotContains(t, p.Recordsv4, macKey(mac1))
+
+	// SELECTING for the offered address is acknowledged
+	resp = handle(t, p, dhcpv4.MessageTypeRequest, mac1,
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(serverID)),
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(ip2)))
+	require.NotNil(t, resp)
+	assert.Equal(t, ip2, resp.YourIPAddr.To4())
+
+	// INIT-REBOOT for the leased address is acknowledged, for another one
+	// refused
+	resp = handle(t, p, dhcpv4.MessageTypeRequest, mac1,
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(ip2)))
+	require.NotNil(t, resp)
+	assert.Equal(t, ip2, resp.YourIPAddr.To4())
+	resp = handle(t, p, dhcpv4.MessageTypeRequest, mac1,
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.IPv4(192, 168, 0, 1))))
+	require.NotNil(t, resp)
+	assert.Equal(t, dhcpv4.MessageTypeNak, resp.MessageType())
+
+	// as is INIT-REBOOT of an unknown client
+	mac3 := net.HardwareAddr{2, 0, 0, 0, 0, 3}
+	resp = handle(t, p, dhcpv4.MessageTypeRequest, mac3,
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(ip1)))
+	require.NotNil(t, resp)
+	assert.Equal(t, dhcpv4.MessageTypeNak, resp.MessageType())
+
+	// RENEWING is checked against ciaddr
+	resp = handle(t, p, dhcpv4.MessageTypeRequest, mac1, dhcpv4.WithClientIP(ip2))
+	require.NotNil(t, resp)
+	assert.Equal(t, ip2, resp.YourIPAddr.To4())
+	resp = handle(t, p, dhcpv4.MessageTypeRequest, mac1, dhcpv4.WithClientIP(ip1))
+	require.NotNil(t, resp)
+	assert.Equal(t, dhcpv4.MessageTypeNak, resp.MessageType())
+}
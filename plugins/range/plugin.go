@@ -9,24 +9,127 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"os"
 	"sync"
 	"time"
 
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/events"
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
 	"github.com/coredhcp/coredhcp/plugins/allocators"
-	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+	"github.com/coredhcp/coredhcp/plugins/leasestore"
+	"github.com/coredhcp/coredhcp/plugins/reservations"
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/sirupsen/logrus"
 )
 
 var log = logger.GetLogger("plugins/range")
 
+const pluginName = "range"
+
+// authoritativeArg is the keyword making the plugin authoritative
+const authoritativeArg = "authoritative"
+
 // Plugin wraps plugin registration information
 var Plugin = plugins.Plugin{
-	Name:   "range",
-	Setup4: setupRange,
+	Name:         pluginName,
+	Setup4:       setupRange,
+	Setup4Config: setupRangeConfig,
+	Args: []plugins.Arg{
+		{Name: "file", Type: "path", Help: "file where the leases are stored, prefixed with the lease store backend if not text, eg. bolt:leases.db"},
+		{Name: "start", Type: "IPv4", Help: "first address of the range"},
+		{Name: "end", Type: "IPv4", Help: "last address of the range"},
+		{Name: "lease_time", Type: "duration", Help: "lease time given to the clients"},
+		{Name: "grace_period", Type: "duration", Help: "how long expired leases are kept before their address is freed (default: 0)", Optional: true},
+		{Name: "offer_timeout", Type: "duration", Help: "how long an address offered to a client is held for it (default: 30s)", Optional: true},
+		{Name: "probe_timeout", Type: "duration", Help: "how long to wait for an answer when probing an address before offering it (default: 0, no probing)", Optional: true},
+		{Name: "abandon_time", Type: "duration", Help: "how long an address found in use or declined is kept out of the pool (default: 1h)", Optional: true},
+		{Name: "lease_key", Type: "mac, client-id, mac+circuit-id or client-id+circuit-id", Help: "what identifies the lease of a client (default: mac)", Optional: true},
+		{Name: "authoritative", Type: "keyword", Help: "refuse the requests for addresses not leased to the client with a DHCPNAK", Optional: true},
+	},
+	Requires: []string{"server_id"},
+}
+
+// Config is the configuration of the range plugin. It can be given as a map:
+//
+//  - range:
+//      file: leases.txt
+//      start: 10.10.10.100
+//      end: 10.10.10.200
+//      ranges: [10.10.10.220-10.10.10.240]
+//      exclude: [10.10.10.150, 10.10.10.160-10.10.10.169]
+//      lease_time: 60s
+//      min_lease_time: 30s
+//      max_lease_time: 1h
+//      t1_ratio: 0.5
+//      t2_ratio: 0.875
+//      class_lease_time:
+//        guests: 30m
+//      grace_period: 1h
+//      offer_timeout: 30s
+//      probe_timeout: 500ms
+//      abandon_time: 1h
+//      lease_key: client-id
+//      authoritative: true
+//
+// or as positional arguments, in the same order without ranges, exclude and
+// the lease time policy, with authoritative given as a keyword.
+type Config struct {
+	// File is where the leases are stored, see leasestore.Open
+	File  string `mapstructure:"file"`
+	Start net.IP `mapstructure:"start"`
+	End   net.IP `mapstructure:"end"`
+	// Ranges are more ranges of the pool, besides Start-End, each given as
+	// "<first>-<last>" or a single address
+	Ranges []string `mapstructure:"ranges"`
+	// Exclude are the ranges of addresses never handed out, in the same
+	// format as Ranges. The addresses reserved in the file plugin are
+	// excluded as well.
+	Exclude []string `mapstructure:"exclude"`
+	// LeaseTime is the default lease time, given to the clients which don't
+	// request one
+	LeaseTime time.Duration `mapstructure:"lease_time"`
+	// MinLeaseTime and MaxLeaseTime bound the lease times the clients
+	// request. They default to LeaseTime, so that requests are ignored.
+	MinLeaseTime time.Duration `mapstructure:"min_lease_time"`
+	MaxLeaseTime time.Duration `mapstructure:"max_lease_time"`
+	// T1Ratio and T2Ratio are the fractions of the lease time after which
+	// the clients renew (option 58) and rebind (option 59) their lease. The
+	// options are only sent when set.
+	T1Ratio float64 `mapstructure:"t1_ratio"`
+	T2Ratio float64 `mapstructure:"t2_ratio"`
+	// ClassLeaseTimes are the lease times of the clients in a class (see
+	// the class plugin), given whatever they request. A client in several
+	// of these classes gets the shortest lease time.
+	ClassLeaseTimes map[string]time.Duration `mapstructure:"class_lease_time"`
+	// GracePeriod is how long an expired lease stays reserved to its client
+	// before the address is freed. A full pool reclaims expired leases
+	// regardless.
+	GracePeriod time.Duration `mapstructure:"grace_period"`
+	// OfferTimeout is how long an address offered in a DHCPOFFER is held
+	// for the client, waiting for its DHCPREQUEST. Offers are not persisted.
+	OfferTimeout time.Duration `mapstructure:"offer_timeout"`
+	// ProbeTimeout enables conflict detection: a new address is probed
+	// before it is offered, with ARP for on-link clients and ICMP echo for
+	// relayed ones, waiting this long for an answer.
+	ProbeTimeout time.Duration `mapstructure:"probe_timeout"`
+	// AbandonTime is how long an address that answered a probe, or that a
+	// client declined, is kept out of the pool
+	AbandonTime time.Duration `mapstructure:"abandon_time"`
+	// LeaseKey is what identifies the lease of a client: "mac", its hardware
+	// address (the default), "client-id", its client identifier (option 61)
+	// or its hardware address if it sends none, and either one followed by
+	// "+circuit-id" to also tell apart the relay agent circuits, for relayed
+	// requests. Leases stored under another kind of key, eg. before the key
+	// was changed, are moved to the configured key when their client comes
+	// back.
+	LeaseKey string `mapstructure:"lease_key"`
+	// Authoritative makes the plugin answer the DHCPREQUESTs for an address
+	// it didn't lease to the client with a DHCPNAK, rather than ignore them,
+	// so that the client restarts its configuration. Only one server of a
+	// network should be authoritative.
+	Authoritative bool `mapstructure:"authoritative"`
 }
 
 //Record holds an IP lease record
@@ -37,109 +140,329 @@ type Record struct {
 
 // PluginState is the data held by an instance of the range plugin
 type PluginState struct {
-	// Rough lock for the whole plugin, we'll get better performance once we use leasestorage
+	// Rough lock for the whole plugin, which also keeps the records and the
+	// lease store consistent
 	sync.Mutex
-	// Recordsv4 holds a MAC -> IP address and lease time mapping
+	// Recordsv4 holds a lease key -> IP address and lease time mapping
 	Recordsv4 map[string]*Record
-	LeaseTime time.Duration
-	leasefile *os.File
-	allocator allocators.Allocator
+	// key tells how the lease keys of the clients are made
+	key keySpec
+	// offers holds the addresses offered to clients without a lease, by
+	// lease key
+	offers map[string]*offer
+	// abandoned holds the addresses found in use, until when they are kept
+	// out of the pool
+	abandoned map[string]time.Time
+	// skipped holds the addresses of the pool reserved by other plugins,
+	// which are kept allocated while they are
+	skipped      map[string]struct{}
+	LeaseTime    time.Duration
+	MinLeaseTime time.Duration
+	MaxLeaseTime time.Duration
+	T1Ratio      float64
+	T2Ratio      float64
+	// classLeaseTimes holds the lease times of the classes having one
+	classLeaseTimes map[string]time.Duration
+	GracePeriod     time.Duration
+	OfferTimeout    time.Duration
+	AbandonTime     time.Duration
+	store           leasestore.Store
+	allocator       allocators.Allocator
+	// prober checks the addresses before they are offered, when set
+	prober Prober
+	// Authoritative makes the plugin NAK the requests for other addresses
+	Authoritative bool
+	// stopMaintenance stops the goroutine freeing the expired leases
+	stopMaintenance chan struct{}
 }
 
 // Handler4 handles DHCPv4 packets for the range plugin
 func (p *PluginState) Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	key := p.key.leaseKey(req)
+	clog := log.WithFields(logrus.Fields{
+		logger.FieldMAC:       req.ClientHWAddr.String(),
+		logger.FieldInterface: state.InterfaceName,
+	})
+	if key != macKey(req.ClientHWAddr) {
+		clog = clog.WithField(logger.FieldLease, key)
+	}
 	p.Lock()
 	defer p.Unlock()
-	record, ok := p.Recordsv4[req.ClientHWAddr.String()]
-	if !ok {
-		// Allocating new address since there isn't one allocated
-		log.Printf("MAC address %s is new, leasing new IPv4 address", req.ClientHWAddr.String())
-		ip, err := p.allocator.Allocate(net.IPNet{})
+	now := time.Now()
+	p.migrateKey(key, req)
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeRelease, dhcpv4.MessageTypeDecline:
+		// these are never answered, the server drops the response
+		if !selectedUs(req, resp) {
+			return resp, false
+		}
+		if req.MessageType() == dhcpv4.MessageTypeRelease {
+			p.release(clog, req, key)
+		} else {
+			p.decline(clog, req, key, now)
+		}
+		return resp, false
+	}
+	leaseTime := p.leaseTime(state, req)
+	record, ok := p.Recordsv4[key]
+	switch {
+	case req.MessageType() == dhcpv4.MessageTypeDiscover && !ok:
+		// Only offer an address, the client may choose another server
+		onLink := req.GatewayIPAddr == nil || req.GatewayIPAddr.IsUnspecified()
+		ip, err := p.probeOffer(clog, key, req.RequestedIPAddress(), state.InterfaceName, onLink)
 		if err != nil {
-			log.Errorf("Could not allocate IP for MAC %s: %v", req.ClientHWAddr.String(), err)
+			clog.Errorf("Could not allocate IP: %v", err)
 			return nil, true
 		}
-		rec := Record{
-			IP:      ip.IP.To4(),
-			expires: time.Now().Add(p.LeaseTime),
+		resp.YourIPAddr = ip
+		p.setLeaseTime(resp, leaseTime)
+		clog.WithField(logger.FieldIP, ip.String()).Info("offering IP address")
+		return resp, false
+	case req.MessageType() == dhcpv4.MessageTypeDiscover:
+		// Offer the current lease, it is extended when requested
+	case req.MessageType() == dhcpv4.MessageTypeRequest && !selectedUs(req, resp):
+		clog.Infof("Client selected server %s", req.ServerIdentifier())
+		p.withdrawOffer(key)
+		return nil, true
+	case req.MessageType() == dhcpv4.MessageTypeRequest && !p.isOurs(req, key):
+		requested := requestedAddress(req)
+		clog = clog.WithField(logger.FieldIP, requested.String())
+		if !p.Authoritative {
+			clog.Info("Ignoring request for an address not leased to the client")
+			return nil, true
 		}
-		err = p.saveIPAddress(req.ClientHWAddr, &rec)
+		clog.Info("Refusing request for an address not leased to the client")
+		return nak(resp), true
+	default:
+		var err error
+		record, err = p.commit(clog, key, leaseTime, now)
 		if err != nil {
-			log.Errorf("SaveIPAddress for MAC %s failed: %v", req.ClientHWAddr.String(), err)
-		}
-		p.Recordsv4[req.ClientHWAddr.String()] = &rec
-		record = &rec
-	} else {
-		// Ensure we extend the existing lease at least past when the one we're giving expires
-		if record.expires.Before(time.Now().Add(p.LeaseTime)) {
-			record.expires = time.Now().Add(p.LeaseTime).Round(time.Second)
-			err := p.saveIPAddress(req.ClientHWAddr, record)
-			if err != nil {
-				log.Errorf("Could not persist lease for MAC %s: %v", req.ClientHWAddr.String(), err)
-			}
+			clog.Errorf("Could not allocate IP: %v", err)
+			return nil, true
 		}
 	}
 	resp.YourIPAddr = record.IP
-	resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(p.LeaseTime.Round(time.Second)))
-	log.Printf("found IP address %s for MAC %s", record.IP, req.ClientHWAddr.String())
+	p.setLeaseTime(resp, leaseTime)
+	clog.WithField(logger.FieldIP, record.IP.String()).Info("found IP address")
+	if req.MessageType() == dhcpv4.MessageTypeRequest {
+		events.Publish(events.Event{
+			Type:      events.TypeFromRequest4(req),
+			Plugin:    pluginName,
+			MAC:       req.ClientHWAddr.String(),
+			IP:        record.IP,
+			Hostname:  req.HostName(),
+			Interface: state.InterfaceName,
+			Expiry:    record.expires,
+		})
+	}
 	return resp, false
 }
 
+func setupRangeConfig(pc *config.PluginConfig) (handler.Handler4, error) {
+	if !pc.IsMap() {
+		return setupRange(pc.Args...)
+	}
+	var conf Config
+	if err := pc.Decode(&conf); err != nil {
+		return nil, err
+	}
+	return setupFromConfig(&conf)
+}
+
 func setupRange(args ...string) (handler.Handler4, error) {
 	var (
-		err error
-		p   PluginState
+		err  error
+		conf Config
 	)
 
-	if len(args) < 4 {
-		return nil, fmt.Errorf("invalid number of arguments, want: 4 (file name, start IP, end IP, lease time), got: %d", len(args))
+	if len(args) > 0 && args[len(args)-1] == authoritativeArg {
+		conf.Authoritative = true
+		args = args[:len(args)-1]
 	}
-	filename := args[0]
-	if filename == "" {
-		return nil, errors.New("file name cannot be empty")
+	optional := []struct {
+		name string
+		d    *time.Duration
+	}{
+		{"grace period", &conf.GracePeriod},
+		{"offer timeout", &conf.OfferTimeout},
+		{"probe timeout", &conf.ProbeTimeout},
+		{"abandon time", &conf.AbandonTime},
 	}
-	ipRangeStart := net.ParseIP(args[1])
-	if ipRangeStart.To4() == nil {
+	if len(args) < 4 || len(args) > 5+len(optional) {
+		return nil, fmt.Errorf("invalid number of arguments, want: 4 to 9 (file name, start IP, end IP, lease time, [grace period], [offer timeout], [probe timeout], [abandon time], [lease key]) and optionally %s, got: %d", authoritativeArg, len(args))
+	}
+	conf.File = args[0]
+	conf.Start = net.ParseIP(args[1])
+	if conf.Start.To4() == nil {
 		return nil, fmt.Errorf("invalid IPv4 address: %v", args[1])
 	}
-	ipRangeEnd := net.ParseIP(args[2])
-	if ipRangeEnd.To4() == nil {
+	conf.End = net.ParseIP(args[2])
+	if conf.End.To4() == nil {
 		return nil, fmt.Errorf("invalid IPv4 address: %v", args[2])
 	}
-	if binary.BigEndian.Uint32(ipRangeStart.To4()) >= binary.BigEndian.Uint32(ipRangeEnd.To4()) {
-		return nil, errors.New("start of IP range has to be lower than the end of an IP range")
+	conf.LeaseTime, err = time.ParseDuration(args[3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid lease duration: %v", args[3])
+	}
+	for i, arg := range args[4:] {
+		if i == len(optional) {
+			conf.LeaseKey = arg
+			break
+		}
+		*optional[i].d, err = time.ParseDuration(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", optional[i].name, arg)
+		}
+	}
+	return setupFromConfig(&conf)
+}
+
+func setupFromConfig(conf *Config) (handler.Handler4, error) {
+	var (
+		err error
+		p   PluginState
+	)
+
+	if _, _, err := leasestore.ParseSpec(conf.File); err != nil {
+		return nil, err
+	}
+	var ranges []reservations.Range
+	if conf.Start != nil || conf.End != nil || len(conf.Ranges) == 0 {
+		ipRangeStart := conf.Start.To4()
+		if ipRangeStart == nil {
+			return nil, fmt.Errorf("invalid IPv4 range start: %v", conf.Start)
+		}
+		ipRangeEnd := conf.End.To4()
+		if ipRangeEnd == nil {
+			return nil, fmt.Errorf("invalid IPv4 range end: %v", conf.End)
+		}
+		if binary.BigEndian.Uint32(ipRangeStart) >= binary.BigEndian.Uint32(ipRangeEnd) {
+			return nil, errors.New("start of IP range has to be lower than the end of an IP range")
+		}
+		ranges = append(ranges, reservations.Range{Start: ipRangeStart, End: ipRangeEnd})
+	}
+	for _, s := range conf.Ranges {
+		r, err := reservations.ParseRange(s)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	var exclude []reservations.Range
+	for _, s := range conf.Exclude {
+		r, err := reservations.ParseRange(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclusion: %w", err)
+		}
+		exclude = append(exclude, r)
+	}
+	if err := p.setupLeaseTimes(conf); err != nil {
+		return nil, err
+	}
+	if conf.GracePeriod < 0 {
+		return nil, fmt.Errorf("invalid grace period: %v", conf.GracePeriod)
+	}
+	p.GracePeriod = conf.GracePeriod
+	switch {
+	case conf.OfferTimeout < 0:
+		return nil, fmt.Errorf("invalid offer timeout: %v", conf.OfferTimeout)
+	case conf.OfferTimeout == 0:
+		p.OfferTimeout = defaultOfferTimeout
+	default:
+		p.OfferTimeout = conf.OfferTimeout
+	}
+	p.offers = make(map[string]*offer)
+	switch {
+	case conf.ProbeTimeout < 0:
+		return nil, fmt.Errorf("invalid probe timeout: %v", conf.ProbeTimeout)
+	case conf.ProbeTimeout > 0:
+		p.prober = netProber{timeout: conf.ProbeTimeout}
+	}
+	switch {
+	case conf.AbandonTime < 0:
+		return nil, fmt.Errorf("invalid abandon time: %v", conf.AbandonTime)
+	case conf.AbandonTime == 0:
+		p.AbandonTime = defaultAbandonTime
+	default:
+		p.AbandonTime = conf.AbandonTime
+	}
+	p.abandoned = make(map[string]time.Time)
+	p.skipped = make(map[string]struct{})
+	p.Authoritative = conf.Authoritative
+	if p.key, err = parseKeySpec(conf.LeaseKey); err != nil {
+		return nil, err
 	}
 
-	p.allocator, err = bitmap.NewIPv4Allocator(ipRangeStart, ipRangeEnd)
+	pool, err := newPool(ranges, exclude)
 	if err != nil {
 		return nil, fmt.Errorf("could not create an allocator: %w", err)
 	}
+	p.allocator = pool
+	if err := reservations.AddPool(&p, conf.File, pool.ranges); err != nil {
+		return nil, err
+	}
 
-	p.LeaseTime, err = time.ParseDuration(args[3])
-	if err != nil {
-		return nil, fmt.Errorf("invalid lease duration: %v", args[3])
+	if plugins.DryRun() {
+		return p.Handler4, nil
 	}
 
-	p.Recordsv4, err = loadRecordsFromFile(filename)
+	// Close releases what was set up so far on errors
+	if err := p.openStore(conf.File); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("could not setup lease storage: %w", err)
+	}
+	p.Recordsv4, err = loadRecords(p.store)
 	if err != nil {
-		return nil, fmt.Errorf("could not load records from file: %v", err)
+		p.Close()
+		return nil, fmt.Errorf("could not load records from %s: %v", conf.File, err)
 	}
 
-	log.Printf("Loaded %d DHCPv4 leases from %s", len(p.Recordsv4), filename)
+	log.Printf("Loaded %d DHCPv4 leases from %s", len(p.Recordsv4), conf.File)
 
-	for _, v := range p.Recordsv4 {
+	for key, v := range p.Recordsv4 {
+		if !pool.contains(v.IP) && inRanges(ranges, v.IP) {
+			log.WithFields(logrus.Fields{
+				logger.FieldLease: key,
+				logger.FieldIP:    v.IP.String(),
+			}).Warning("Dropping lease of an excluded address")
+			delete(p.Recordsv4, key)
+			if err := p.saveRemoval(key); err != nil {
+				p.Close()
+				return nil, fmt.Errorf("could not drop lease %s: %w", key, err)
+			}
+			continue
+		}
+		if mac, ok := reservations.ReservedFor(v.IP); ok && mac != keyMACAddress(key) {
+			p.Close()
+			return nil, fmt.Errorf("leased address %v of %s is reserved for %s", v.IP, key, mac)
+		}
 		ip, err := p.allocator.Allocate(net.IPNet{IP: v.IP})
 		if err != nil {
+			p.Close()
 			return nil, fmt.Errorf("failed to re-allocate leased ip %v: %v", v.IP.String(), err)
 		}
 		if ip.IP.String() != v.IP.String() {
+			p.Close()
 			return nil, fmt.Errorf("allocator did not re-allocate requested leased ip %v: %v", v.IP.String(), ip.String())
 		}
 	}
-
-	if err := p.registerBackingFile(filename); err != nil {
-		return nil, fmt.Errorf("could not setup lease storage: %w", err)
+	if err := p.restoreAbandoned(time.Now()); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("could not load abandoned addresses from %s: %v", conf.File, err)
 	}
 
+	p.startMaintenance()
+	plugins.Manage(&p)
+
 	return p.Handler4, nil
 }
+
+// inRanges tells whether an address is in one of the ranges
+func inRanges(ranges []reservations.Range, ip net.IP) bool {
+	for _, r := range ranges {
+		if r.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
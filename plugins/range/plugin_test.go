@@ -0,0 +1,177 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/plugins/leasestore"
+	"github.com/coredhcp/coredhcp/plugins/reservations"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setup sets up an instance of the plugin, forgetting the pools of the
+// instances set up before, which are not closed
+func setup(pc *config.PluginConfig) (handler.Handler4, error) {
+	reservations.Reset()
+	return setupRangeConfig(pc)
+}
+
+func TestSetupConfig(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "test_plugin_range")
+	require.NoError(t, err)
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	h, err := setup(&config.PluginConfig{
+		Name: pluginName,
+		Value: map[string]interface{}{
+			"file":       tmp.Name(),
+			"start":      "10.0.0.1",
+			"end":        "10.0.0.100",
+			"lease_time": "60s",
+		},
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, h)
+
+	h, err = setup(&config.PluginConfig{
+		Name: pluginName,
+		Args: []string{tmp.Name(), "10.0.0.1", "10.0.0.100", "60s"},
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, h)
+
+	h, err = setup(&config.PluginConfig{
+		Name: pluginName,
+		Args: []string{tmp.Name(), "10.0.0.1", "10.0.0.100", "60s", "1h"},
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, h)
+
+	_, err = setup(&config.PluginConfig{
+		Name: pluginName,
+		Args: []string{tmp.Name(), "10.0.0.1", "10.0.0.100", "60s", "-1h"},
+	})
+	assert.Error(t, err)
+
+	h, err = setup(&config.PluginConfig{
+		Name: pluginName,
+		Args: []string{tmp.Name(), "10.0.0.1", "10.0.0.100", "60s", "1h", "10s", authoritativeArg},
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, h)
+
+	_, err = setup(&config.PluginConfig{
+		Name: pluginName,
+		Args: []string{tmp.Name(), "10.0.0.1", "10.0.0.100", "60s", authoritativeArg, "1h"},
+	})
+	assert.Error(t, err)
+
+	h, err = setup(&config.PluginConfig{
+		Name: pluginName,
+		Value: map[string]interface{}{
+			"file":             tmp.Name(),
+			"start":            "10.0.0.1",
+			"end":              "10.0.0.100",
+			"lease_time":       "1h",
+			"min_lease_time":   "10m",
+			"max_lease_time":   "24h",
+			"t1_ratio":         0.5,
+			"t2_ratio":         "0.875",
+			"class_lease_time": map[string]interface{}{"guests": "30m"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, h)
+
+	_, err = setup(&config.PluginConfig{
+		Name: pluginName,
+		Value: map[string]interface{}{
+			"file":  tmp.Name(),
+			"start": "10.0.0.100",
+			"end":   "10.0.0.1",
+		},
+	})
+	assert.Error(t, err)
+}
+
+func TestCloseAndHealth(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "test_plugin_range")
+	require.NoError(t, err)
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	var p PluginState
+	require.NoError(t, p.openStore(tmp.Name()))
+	assert.NoError(t, p.Health())
+
+	// a lease file replaced behind our back can't be written to anymore
+	require.NoError(t, os.Remove(tmp.Name()))
+	require.NoError(t, ioutil.WriteFile(tmp.Name(), nil, 0644))
+	assert.Error(t, p.Health())
+
+	assert.NoError(t, p.Close())
+	assert.NoError(t, p.Close())
+	assert.EqualError(t, p.Health(), "lease store is closed")
+}
+
+func TestSetupBolt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test_plugin_range")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	spec := "bolt:" + filepath.Join(dir, "leases.db")
+
+	store, err := leasestore.Open(spec)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(&leasestore.Lease{Key: "02:00:00:00:00:01", Address: "10.0.0.7", Expires: time.Now().Add(time.Hour)}))
+	require.NoError(t, store.Close())
+
+	h, err := setup(&config.PluginConfig{
+		Name:  pluginName,
+		Value: map[string]interface{}{"file": spec, "start": "10.0.0.1", "end": "10.0.0.100", "lease_time": "60s"},
+	})
+	require.NoError(t, err)
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{2, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, _ = h(&handler.PropagateState{}, req, resp)
+	require.NotNil(t, resp)
+	assert.Equal(t, "10.0.0.7", resp.YourIPAddr.String())
+}
+
+func TestSetupCompacts(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "test_plugin_range")
+	require.NoError(t, err)
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString(`mac=02:00:00:00:00:01 10.0.0.1 2000-01-01T00:00:00Z
+mac=02:00:00:00:00:01 10.0.0.1 2000-01-01T01:00:00Z
+mac=02:00:00:00:00:02 10.0.0.2 2000-01-01T00:00:00Z
+mac=02:00:00:00:00:02 - 2000-01-01T00:00:00Z
+mac=02:00:00:00:00:03 10.0.0.3 2000-01`)
+	require.NoError(t, err)
+	tmp.Close()
+
+	h, err := setup(&config.PluginConfig{
+		Name: pluginName,
+		Args: []string{tmp.Name(), "10.0.0.1", "10.0.0.100", "60s"},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, h)
+
+	written, err := ioutil.ReadFile(tmp.Name())
+	require.NoError(t, err)
+	assert.Equal(t, "mac=02:00:00:00:00:01 10.0.0.1 2000-01-01T01:00:00Z\n", string(written))
+}
@@ -0,0 +1,167 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+	"github.com/coredhcp/coredhcp/plugins/reservations"
+	"github.com/sirupsen/logrus"
+)
+
+// pool allocates the addresses of several ranges, less the excluded ones.
+// It implements allocators.Allocator.
+type pool struct {
+	// ranges are the addresses handed out, sorted and disjoint
+	ranges     []reservations.Range
+	allocators []*bitmap.IPv4Allocator
+}
+
+// newPool creates the allocator of the addresses of the ranges, without the
+// excluded ones. The ranges must not overlap.
+func newPool(ranges, exclude []reservations.Range) (*pool, error) {
+	sorted := append([]reservations.Range(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].Start.To4(), sorted[j].Start.To4()) < 0
+	})
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i-1].Overlaps(sorted[i]) {
+			return nil, fmt.Errorf("ranges %s and %s overlap", sorted[i-1], sorted[i])
+		}
+	}
+	for _, x := range exclude {
+		sorted = subtract(sorted, x)
+	}
+	if len(sorted) == 0 {
+		return nil, fmt.Errorf("all the addresses of %v are excluded", ranges)
+	}
+
+	p := &pool{ranges: sorted}
+	for _, r := range sorted {
+		a, err := bitmap.NewIPv4Allocator(r.Start, r.End)
+		if err != nil {
+			return nil, err
+		}
+		p.allocators = append(p.allocators, a)
+	}
+	return p, nil
+}
+
+// subtract removes the excluded addresses from sorted, disjoint ranges
+func subtract(ranges []reservations.Range, x reservations.Range) []reservations.Range {
+	var left []reservations.Range
+	for _, r := range ranges {
+		if !r.Overlaps(x) {
+			left = append(left, r)
+			continue
+		}
+		if r.Contains(x.Start) && !r.Start.Equal(x.Start) {
+			left = append(left, reservations.Range{Start: r.Start, End: addIP(x.Start, -1)})
+		}
+		if r.Contains(x.End) && !r.End.Equal(x.End) {
+			left = append(left, reservations.Range{Start: addIP(x.End, 1), End: r.End})
+		}
+	}
+	return left
+}
+
+// addIP returns the IPv4 address n addresses after ip
+func addIP(ip net.IP, n int) net.IP {
+	r := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(r, uint32(int64(binary.BigEndian.Uint32(ip.To4()))+int64(n)))
+	return r
+}
+
+// Allocate implements allocators.Allocator. The hint is allocated if it is
+// free, otherwise the first free address of the ranges is.
+func (p *pool) Allocate(hint net.IPNet) (net.IPNet, error) {
+	if i := p.index(hint.IP); i >= 0 {
+		if n, err := p.allocators[i].Allocate(hint); err == nil {
+			return n, nil
+		}
+	}
+	for _, a := range p.allocators {
+		n, err := a.Allocate(net.IPNet{})
+		if err == allocators.ErrNoAddrAvail {
+			continue
+		}
+		return n, err
+	}
+	return net.IPNet{}, allocators.ErrNoAddrAvail
+}
+
+// Free implements allocators.Allocator
+func (p *pool) Free(n net.IPNet) error {
+	i := p.index(n.IP)
+	if i < 0 {
+		return fmt.Errorf("address %s is not in the pool", n.IP)
+	}
+	return p.allocators[i].Free(n)
+}
+
+// contains tells whether an address is handed out by the pool
+func (p *pool) contains(ip net.IP) bool {
+	return p.index(ip) >= 0
+}
+
+// index returns the index of the range an address is in, or -1
+func (p *pool) index(ip net.IP) int {
+	for i, r := range p.ranges {
+		if r.Contains(ip) {
+			return i
+		}
+	}
+	return -1
+}
+
+// skip tells whether an allocated address is reserved by another plugin, in
+// which case it stays allocated until it isn't anymore. The lock must be held.
+func (p *PluginState) skip(clog *logrus.Entry, ip net.IP) bool {
+	mac, ok := reservations.ReservedFor(ip)
+	if !ok {
+		return false
+	}
+	clog.WithField(logger.FieldIP, ip.String()).Debugf("Skipping address reserved for %s", mac)
+	p.skipped[ip.String()] = struct{}{}
+	return true
+}
+
+// releaseSkipped frees the skipped addresses which are no longer reserved,
+// and returns how many were. The lock must be held.
+func (p *PluginState) releaseSkipped() int {
+	n := 0
+	for addr := range p.skipped {
+		ip := net.ParseIP(addr)
+		if _, ok := reservations.ReservedFor(ip); ok {
+			continue
+		}
+		delete(p.skipped, addr)
+		if err := p.allocator.Free(net.IPNet{IP: ip}); err != nil {
+			log.Warningf("Could not free address %s: %v", addr, err)
+		}
+		n++
+	}
+	return n
+}
+
+// LeasedTo tells whether an address is leased, and to which hardware address.
+// It implements reservations.Pool.
+func (p *PluginState) LeasedTo(ip net.IP) (string, bool) {
+	p.Lock()
+	defer p.Unlock()
+	for key, rec := range p.Recordsv4 {
+		if rec.IP.Equal(ip) {
+			return keyMACAddress(key), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,149 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+	"github.com/coredhcp/coredhcp/plugins/leasestore"
+	"github.com/coredhcp/coredhcp/plugins/reservations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseRanges(t *testing.T, ss ...string) []reservations.Range {
+	var ranges []reservations.Range
+	for _, s := range ss {
+		r, err := reservations.ParseRange(s)
+		require.NoError(t, err)
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+func TestPool(t *testing.T) {
+	p, err := newPool(
+		parseRanges(t, "10.0.0.20-10.0.0.22", "10.0.0.1-10.0.0.10"),
+		parseRanges(t, "10.0.0.2-10.0.0.9", "10.0.0.21", "10.0.0.100"),
+	)
+	require.NoError(t, err)
+	var left []string
+	for _, r := range p.ranges {
+		left = append(left, r.String())
+	}
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.10", "10.0.0.20", "10.0.0.22"}, left)
+
+	// the hint first, then the ranges in order
+	n, err := p.Allocate(net.IPNet{IP: net.IPv4(10, 0, 0, 22)})
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.22", n.IP.String())
+	var got []string
+	for {
+		n, err := p.Allocate(net.IPNet{IP: net.IPv4(10, 0, 0, 5)})
+		if err == allocators.ErrNoAddrAvail {
+			break
+		}
+		require.NoError(t, err)
+		got = append(got, n.IP.String())
+	}
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.10", "10.0.0.20"}, got)
+
+	assert.NoError(t, p.Free(net.IPNet{IP: net.IPv4(10, 0, 0, 10)}))
+	assert.Error(t, p.Free(net.IPNet{IP: net.IPv4(10, 0, 0, 10)}))
+	assert.Error(t, p.Free(net.IPNet{IP: net.IPv4(10, 0, 0, 5)}))
+
+	_, err = newPool(parseRanges(t, "10.0.0.1-10.0.0.10", "10.0.0.10-10.0.0.20"), nil)
+	assert.Error(t, err)
+	_, err = newPool(parseRanges(t, "10.0.0.1-10.0.0.10"), parseRanges(t, "10.0.0.0-10.0.0.20"))
+	assert.Error(t, err)
+}
+
+func TestSetupRanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test_plugin_range")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	reservations.Reset()
+	defer reservations.Reset()
+
+	// a lease on an excluded address is dropped
+	file1 := filepath.Join(dir, "leases1.txt")
+	store, err := leasestore.Open(file1)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(&leasestore.Lease{Key: "mac=02:00:00:00:00:01", Address: "10.0.0.5", Expires: time.Now().Add(time.Hour)}))
+	require.NoError(t, store.Close())
+	h, err := setupRangeConfig(&config.PluginConfig{
+		Name: pluginName,
+		Value: map[string]interface{}{
+			"file":       file1,
+			"ranges":     []string{"10.0.0.1-10.0.0.10", "10.0.0.20-10.0.0.30"},
+			"exclude":    []string{"10.0.0.5"},
+			"lease_time": "60s",
+		},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, h)
+	store, err = leasestore.Open(file1)
+	require.NoError(t, err)
+	_, err = store.Get("mac=02:00:00:00:00:01")
+	assert.Equal(t, leasestore.ErrNotFound, err)
+	require.NoError(t, store.Close())
+
+	// pools can't overlap
+	file2 := filepath.Join(dir, "leases2.txt")
+	_, err = setupRangeConfig(&config.PluginConfig{
+		Name:  pluginName,
+		Value: map[string]interface{}{"file": file2, "ranges": []string{"10.0.0.30-10.0.0.40"}, "lease_time": "60s"},
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "overlaps range 10.0.0.20-10.0.0.30")
+	}
+	h, err = setupRangeConfig(&config.PluginConfig{
+		Name:  pluginName,
+		Value: map[string]interface{}{"file": file2, "ranges": []string{"10.0.0.31-10.0.0.40"}, "lease_time": "60s"},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, h)
+
+	// a lease on an address reserved for another client is refused
+	reservations.Reset()
+	require.NoError(t, reservations.Reserve(t, map[string]string{"10.0.0.35": "02:00:00:00:00:02"}))
+	store, err = leasestore.Open(file2)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(&leasestore.Lease{Key: "mac=02:00:00:00:00:01", Address: "10.0.0.35", Expires: time.Now().Add(time.Hour)}))
+	require.NoError(t, store.Close())
+	_, err = setupRangeConfig(&config.PluginConfig{
+		Name:  pluginName,
+		Value: map[string]interface{}{"file": file2, "ranges": []string{"10.0.0.31-10.0.0.40"}, "lease_time": "60s"},
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is reserved for 02:00:00:00:00:02")
+	}
+}
+
+func TestSkipReserved(t *testing.T) {
+	p, filename := newTestState(t)
+	defer os.Remove(filename)
+	defer p.Close()
+	reservations.Reset()
+	defer reservations.Reset()
+
+	require.NoError(t, reservations.Reserve(t, map[string]string{"10.0.0.1": "02:00:00:00:00:09"}))
+	mac := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	assert.Equal(t, net.IPv4(10, 0, 0, 2).To4(), discover(t, p, mac).To4())
+	assert.Contains(t, p.skipped, "10.0.0.1")
+	assert.Nil(t, discover(t, p, net.HardwareAddr{2, 0, 0, 0, 0, 2}))
+
+	// the address is handed out again once it isn't reserved anymore
+	reservations.Release(t)
+	assert.Equal(t, 1, p.releaseSkipped())
+	assert.Equal(t, net.IPv4(10, 0, 0, 1).To4(), discover(t, p, net.HardwareAddr{2, 0, 0, 0, 0, 2}).To4())
+}
@@ -0,0 +1,208 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"time"
+
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// defaultAbandonTime is how long an address found in use is kept out of the
+// pool by default
+const defaultAbandonTime = time.Hour
+
+// maxProbes bounds the number of addresses probed for a single offer
+const maxProbes = 4
+
+// Prober checks whether an address is in use before it is offered
+type Prober interface {
+	// Probe tells whether a host answers at the address. onLink is set when
+	// the client is on the link of the interface the request was received
+	// on, rather than relayed.
+	Probe(ip net.IP, iface string, onLink bool) (bool, error)
+}
+
+// netProber probes the addresses of on-link clients with ARP, and the others
+// with ICMP echo requests
+type netProber struct {
+	timeout time.Duration
+}
+
+// Probe implements Prober
+func (n netProber) Probe(ip net.IP, iface string, onLink bool) (bool, error) {
+	if onLink && iface != "" {
+		return arpProbe(ip, iface, n.timeout)
+	}
+	return icmpProbe(ip, n.timeout)
+}
+
+// icmpProbe sends an ICMP echo request to the address, and tells whether it
+// was answered before the timeout
+func icmpProbe(ip net.IP, timeout time.Duration) (bool, error) {
+	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	echo := &icmp.Echo{
+		ID:   os.Getpid() & 0xffff,
+		Seq:  rand.Intn(1 << 16),
+		Data: []byte("coredhcp"),
+	}
+	msg, err := (&icmp.Message{Type: ipv4.ICMPTypeEcho, Body: echo}).Marshal(nil)
+	if err != nil {
+		return false, err
+	}
+	if _, err := conn.WriteTo(msg, &net.IPAddr{IP: ip}); err != nil {
+		return false, err
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return false, err
+	}
+	// the socket receives all the ICMP messages, look for our reply
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				return false, nil
+			}
+			return false, err
+		}
+		if addr, ok := peer.(*net.IPAddr); !ok || !addr.IP.Equal(ip) {
+			continue
+		}
+		reply, err := icmp.ParseMessage(1, buf[:n])
+		if err != nil || reply.Type != ipv4.ICMPTypeEchoReply {
+			continue
+		}
+		if body, ok := reply.Body.(*icmp.Echo); ok && body.ID == echo.ID && body.Seq == echo.Seq {
+			return true, nil
+		}
+	}
+}
+
+// probeOffer makes an offer to a client like makeOffer, and probes the newly
+// offered addresses, abandoning the ones in use. The lock must be held, it is
+// released while probing.
+func (p *PluginState) probeOffer(clog *logrus.Entry, key string, hint net.IP, iface string, onLink bool) (net.IP, error) {
+	for i := 0; ; i++ {
+		ip, err := p.makeOffer(clog, key, hint, time.Now())
+		if err != nil || p.prober == nil {
+			return ip, err
+		}
+		o := p.offers[key]
+		if o.probed {
+			return ip, nil
+		}
+		if i == maxProbes {
+			p.withdrawOffer(key)
+			return nil, fmt.Errorf("no free address found after probing %d addresses", maxProbes)
+		}
+		p.Unlock()
+		inUse, err := p.prober.Probe(ip, iface, onLink)
+		p.Lock()
+		if err != nil {
+			clog.Warningf("Could not probe %s, offering it anyway: %v", ip, err)
+		}
+		if p.offers[key] != o {
+			// the offer was committed, expired or was replaced meanwhile
+			if record, ok := p.Recordsv4[key]; ok {
+				return record.IP, nil
+			}
+			if o, ok := p.offers[key]; ok {
+				return o.IP, nil
+			}
+			continue
+		}
+		if !inUse {
+			o.probed = true
+			return ip, nil
+		}
+		clog.Warningf("Address %s is already in use, abandoning it for %s", ip, p.AbandonTime)
+		p.abandon(key, time.Now())
+		hint = nil
+	}
+}
+
+// abandon takes back the address offered to a client, which another host
+// uses. The lock must be held.
+func (p *PluginState) abandon(key string, now time.Time) {
+	o, ok := p.offers[key]
+	if !ok {
+		return
+	}
+	delete(p.offers, key)
+	p.abandonAddress(o.IP, now)
+}
+
+// abandonAddress keeps an allocated address reserved in the allocator for the
+// abandon time, and persists it. The lock must be held.
+func (p *PluginState) abandonAddress(ip net.IP, now time.Time) {
+	until := now.Add(p.AbandonTime)
+	p.abandoned[ip.String()] = until
+	if err := p.saveAbandoned(ip.String(), until); err != nil {
+		log.WithField(logger.FieldIP, ip.String()).Errorf("Could not persist abandoned address: %v", err)
+	}
+}
+
+// releaseAbandoned frees the abandoned addresses whose cool-down ended before
+// now, and returns how many were. The lock must be held.
+func (p *PluginState) releaseAbandoned(now time.Time) int {
+	n := 0
+	for addr, until := range p.abandoned {
+		if now.Before(until) {
+			continue
+		}
+		delete(p.abandoned, addr)
+		if err := p.allocator.Free(net.IPNet{IP: net.ParseIP(addr)}); err != nil {
+			log.Warningf("Could not free abandoned address %s: %v", addr, err)
+		}
+		if err := p.saveRemoval(abandonedKeyPrefix + addr); err != nil {
+			log.Errorf("Could not persist the end of the cool-down of %s: %v", addr, err)
+		}
+		n++
+	}
+	return n
+}
+
+// restoreAbandoned reserves the addresses abandoned before a restart, until the
+// end of their cool-down. The lock must be held.
+func (p *PluginState) restoreAbandoned(now time.Time) error {
+	abandoned, err := loadAbandoned(p.store)
+	if err != nil {
+		return err
+	}
+	for addr, until := range abandoned {
+		if !now.Before(until) {
+			// the store forgets it when the leases expiring then are reaped
+			continue
+		}
+		ip := net.ParseIP(addr)
+		allocated, err := p.allocator.Allocate(net.IPNet{IP: ip})
+		if err != nil {
+			return fmt.Errorf("failed to re-allocate abandoned address %s: %w", addr, err)
+		}
+		if !allocated.IP.Equal(ip) {
+			// out of the range, or leased
+			log.Warningf("Ignoring abandoned address %s, which can't be reserved", addr)
+			if err := p.allocator.Free(allocated); err != nil {
+				return err
+			}
+			continue
+		}
+		p.abandoned[addr] = until
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build linux
+
+package rangeplugin
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// htons converts a short to network byte order
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// arpProbe sends an ARP probe for the address on the interface, as described
+// in RFC 5227, and tells whether a host answered before the timeout
+func arpProbe(ip net.IP, ifname string, timeout time.Duration) (bool, error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return false, err
+	}
+	if len(iface.HardwareAddr) != 6 {
+		return false, fmt.Errorf("interface %s has no Ethernet address", ifname)
+	}
+
+	eth := layers.Ethernet{
+		SrcMAC:       iface.HardwareAddr,
+		DstMAC:       layers.EthernetBroadcast,
+		EthernetType: layers.EthernetTypeARP,
+	}
+	arp := layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   iface.HardwareAddr,
+		SourceProtAddress: net.IPv4zero.To4(),
+		DstHwAddress:      make([]byte, 6),
+		DstProtAddress:    ip.To4(),
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, &eth, &arp); err != nil {
+		return false, err
+	}
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(syscall.ETH_P_ARP)))
+	if err != nil {
+		return false, fmt.Errorf("cannot open socket: %v", err)
+	}
+	defer syscall.Close(fd)
+	addr := syscall.SockaddrLinklayer{
+		Protocol: htons(syscall.ETH_P_ARP),
+		Ifindex:  iface.Index,
+	}
+	if err := syscall.Bind(fd, &addr); err != nil {
+		return false, fmt.Errorf("cannot bind socket to %s: %v", ifname, err)
+	}
+	if err := syscall.Sendto(fd, buf.Bytes(), 0, &addr); err != nil {
+		return false, fmt.Errorf("cannot send ARP probe: %v", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	frame := make([]byte, 1500)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false, nil
+		}
+		tv := syscall.NsecToTimeval(remaining.Nanoseconds())
+		if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+			return false, err
+		}
+		n, _, err := syscall.Recvfrom(fd, frame, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		packet := gopacket.NewPacket(frame[:n], layers.LayerTypeEthernet, gopacket.NoCopy)
+		reply, ok := packet.Layer(layers.LayerTypeARP).(*layers.ARP)
+		if !ok {
+			continue
+		}
+		// any host claiming the address, whether answering or announcing it
+		if net.IP(reply.SourceProtAddress).Equal(ip) && !bytes.Equal(reply.SourceHwAddress, iface.HardwareAddr) {
+			return true, nil
+		}
+	}
+}
@@ -0,0 +1,18 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build !linux
+
+package rangeplugin
+
+import (
+	"net"
+	"time"
+)
+
+// arpProbe is only supported on Linux, on-link clients are probed with ICMP
+// elsewhere
+func arpProbe(ip net.IP, ifname string, timeout time.Duration) (bool, error) {
+	return icmpProbe(ip, timeout)
+}
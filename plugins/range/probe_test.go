@@ -0,0 +1,109 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProber answers the probes for the addresses in use
+type fakeProber struct {
+	inUse  map[string]bool
+	err    error
+	probes []string
+	onLink []bool
+	// during, if set, is called once while probing, without the lock
+	during func()
+}
+
+func (f *fakeProber) Probe(ip net.IP, iface string, onLink bool) (bool, error) {
+	f.probes = append(f.probes, ip.String())
+	f.onLink = append(f.onLink, onLink)
+	if during := f.during; during != nil {
+		f.during = nil
+		during()
+	}
+	return f.inUse[ip.String()], f.err
+}
+
+func TestProbe(t *testing.T) {
+	p, filename := newTestState(t)
+	defer os.Remove(filename)
+	defer p.Close()
+	prober := &fakeProber{inUse: map[string]bool{"10.0.0.1": true}}
+	p.prober = prober
+
+	// the address in use is abandoned, and another one offered
+	mac1 := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	assert.Equal(t, net.IPv4(10, 0, 0, 2).To4(), discover(t, p, mac1).To4())
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, prober.probes)
+	assert.Equal(t, []bool{true, true}, prober.onLink)
+	assert.Contains(t, p.abandoned, "10.0.0.1")
+
+	// a pending offer isn't probed again
+	assert.Equal(t, net.IPv4(10, 0, 0, 2).To4(), discover(t, p, mac1).To4())
+	assert.Len(t, prober.probes, 2)
+
+	// the abandoned address isn't offered during the cool-down
+	mac2 := net.HardwareAddr{2, 0, 0, 0, 0, 2}
+	assert.Nil(t, discover(t, p, mac2))
+	assert.Equal(t, 0, p.releaseAbandoned(time.Now()))
+	assert.Equal(t, 1, p.releaseAbandoned(time.Now().Add(p.AbandonTime)))
+	assert.Empty(t, p.abandoned)
+
+	// relayed clients are probed too, and failing probes don't block offers
+	prober.err = errors.New("probe failed")
+	delete(prober.inUse, "10.0.0.1")
+	req, err := dhcpv4.NewDiscovery(mac2, dhcpv4.WithGatewayIP(net.IPv4(192, 168, 0, 1)))
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, _ = p.Handler4(&handler.PropagateState{}, req, resp)
+	require.NotNil(t, resp)
+	assert.Equal(t, net.IPv4(10, 0, 0, 1).To4(), resp.YourIPAddr.To4())
+	assert.False(t, prober.onLink[len(prober.onLink)-1])
+}
+
+func TestProbeAllInUse(t *testing.T) {
+	p, filename := newTestState(t)
+	defer os.Remove(filename)
+	defer p.Close()
+	p.prober = &fakeProber{inUse: map[string]bool{"10.0.0.1": true, "10.0.0.2": true}}
+
+	assert.Nil(t, discover(t, p, net.HardwareAddr{2, 0, 0, 0, 0, 1}))
+	assert.Len(t, p.abandoned, 2)
+	assert.Empty(t, p.offers)
+}
+
+func TestProbeCommitted(t *testing.T) {
+	p, filename := newTestState(t)
+	defer os.Remove(filename)
+	defer p.Close()
+	mac1 := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	prober := &fakeProber{}
+	p.prober = prober
+
+	// the client requests the offered address while it is probed: the
+	// lease is offered, without allocating another address
+	prober.during = func() {
+		require.NotNil(t, exchange(t, p, dhcpv4.MessageTypeRequest, mac1, dhcpv4.OptServerIdentifier(serverID)))
+	}
+	assert.Equal(t, net.IPv4(10, 0, 0, 1).To4(), discover(t, p, mac1).To4())
+	assert.Empty(t, p.offers)
+	require.Contains(t, p.Recordsv4, macKey(mac1))
+	assert.Equal(t, net.IPv4(10, 0, 0, 1).To4(), p.Recordsv4[macKey(mac1)].IP.To4())
+
+	// the other address is still free
+	assert.Equal(t, net.IPv4(10, 0, 0, 2).To4(), discover(t, p, net.HardwareAddr{2, 0, 0, 0, 0, 2}).To4())
+}
@@ -0,0 +1,129 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"net"
+	"time"
+
+	"github.com/coredhcp/coredhcp/events"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// maintenanceInterval is how often the expired leases are looked for
+const maintenanceInterval = time.Minute
+
+// startMaintenance frees the leases expired for longer than the grace period
+// in the background, until Close is called
+func (p *PluginState) startMaintenance() {
+	p.stopMaintenance = make(chan struct{})
+	go func(stop <-chan struct{}) {
+		ticker := time.NewTicker(maintenanceInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				p.maintain(now)
+			}
+		}
+	}(p.stopMaintenance)
+}
+
+// maintain runs the periodic maintenance of the leases, offers, abandoned
+// and reserved addresses
+func (p *PluginState) maintain(now time.Time) {
+	p.Lock()
+	defer p.Unlock()
+	n, err := p.reap(now)
+	if err != nil {
+		log.Errorf("Could not free expired leases: %v", err)
+	}
+	if n > 0 {
+		log.Infof("Freed %d expired leases", n)
+	}
+	if n := p.expireOffers(now); n > 0 {
+		log.Debugf("Withdrew %d expired offers", n)
+	}
+	if n := p.releaseAbandoned(now); n > 0 {
+		log.Infof("Returned %d abandoned addresses to the pool", n)
+	}
+	if n := p.releaseSkipped(); n > 0 {
+		log.Infof("Returned %d addresses no longer reserved to the pool", n)
+	}
+}
+
+// reap removes the leases which expired before now minus the grace period
+// from the store, frees their addresses, and returns how many were freed. The
+// lock must be held.
+func (p *PluginState) reap(now time.Time) (int, error) {
+	if p.store == nil {
+		return 0, nil
+	}
+	expired, err := p.store.ExpireBefore(now.Add(-p.GracePeriod))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, l := range expired {
+		if rec, ok := p.Recordsv4[l.Key]; ok {
+			p.dropLease(l.Key, rec, events.Expired)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// reclaimOldest frees the lease that expired first, ignoring the grace
+// period, to make room in a full pool. It returns false if no lease expired.
+// The lock must be held.
+func (p *PluginState) reclaimOldest(now time.Time) bool {
+	var (
+		oldestKey string
+		oldest    *Record
+	)
+	for key, rec := range p.Recordsv4 {
+		if rec.expires.Before(now) && (oldest == nil || rec.expires.Before(oldest.expires)) {
+			oldestKey, oldest = key, rec
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	p.freeLease(oldestKey, oldest, events.Expired)
+	return true
+}
+
+// freeLease removes a lease, returns its address to the allocator and
+// persists the removal. The lock must be held.
+func (p *PluginState) freeLease(key string, rec *Record, typ events.Type) {
+	p.dropLease(key, rec, typ)
+	if err := p.saveRemoval(key); err != nil {
+		log.WithField(logger.FieldLease, key).Errorf("Could not persist the removal of the lease: %v", err)
+	}
+}
+
+// dropLease removes a lease from memory, returns its address to the allocator
+// and publishes an event of the given type. The lock must be held.
+func (p *PluginState) dropLease(key string, rec *Record, typ events.Type) {
+	clog := log.WithFields(logrus.Fields{
+		logger.FieldLease: key,
+		logger.FieldIP:    rec.IP.String(),
+	})
+	delete(p.Recordsv4, key)
+	if err := p.allocator.Free(net.IPNet{IP: rec.IP}); err != nil {
+		clog.Warningf("Could not free lease: %v", err)
+	}
+	clog.Debugf("freed %s lease", typ)
+	events.Publish(events.Event{
+		Type:   typ,
+		Plugin: pluginName,
+		MAC:    keyMACAddress(key),
+		IP:     rec.IP,
+		Expiry: rec.expires,
+	})
+}
@@ -0,0 +1,156 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+	"github.com/coredhcp/coredhcp/plugins/leasestore"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestState returns a plugin state for the range 10.0.0.1-10.0.0.2, with
+// its lease file
+func newTestState(t *testing.T) (*PluginState, string) {
+	tmp, err := ioutil.TempFile("", "test_plugin_range")
+	require.NoError(t, err)
+	tmp.Close()
+
+	p := &PluginState{
+		Recordsv4:    make(map[string]*Record),
+		offers:       make(map[string]*offer),
+		abandoned:    make(map[string]time.Time),
+		skipped:      make(map[string]struct{}),
+		LeaseTime:    time.Hour,
+		MinLeaseTime: time.Hour,
+		MaxLeaseTime: time.Hour,
+		OfferTimeout: time.Minute,
+		AbandonTime:  time.Hour,
+	}
+	p.allocator, err = bitmap.NewIPv4Allocator(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2))
+	require.NoError(t, err)
+	require.NoError(t, p.openStore(tmp.Name()))
+	return p, tmp.Name()
+}
+
+// serverID is the server identifier set in the responses, as by the
+// server_id plugin
+var serverID = net.IPv4(10, 0, 0, 254)
+
+// handle sends a request of the given type for the client, modified by the
+// modifiers, and returns the response
+func handle(t *testing.T, p *PluginState, typ dhcpv4.MessageType, mac net.HardwareAddr, modifiers ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+	modifiers = append([]dhcpv4.Modifier{dhcpv4.WithHwAddr(mac), dhcpv4.WithMessageType(typ)}, modifiers...)
+	req, err := dhcpv4.New(modifiers...)
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithOption(dhcpv4.OptServerIdentifier(serverID)))
+	require.NoError(t, err)
+	resp, _ = p.Handler4(&handler.PropagateState{}, req, resp)
+	return resp
+}
+
+// exchange sends a request of the given type for the client, with the
+// options, and returns the offered or leased address
+func exchange(t *testing.T, p *PluginState, typ dhcpv4.MessageType, mac net.HardwareAddr, opts ...dhcpv4.Option) net.IP {
+	var modifiers []dhcpv4.Modifier
+	for _, o := range opts {
+		modifiers = append(modifiers, dhcpv4.WithOption(o))
+	}
+	resp := handle(t, p, typ, mac, modifiers...)
+	if resp == nil {
+		return nil
+	}
+	return resp.YourIPAddr
+}
+
+// discover returns the address offered to the client
+func discover(t *testing.T, p *PluginState, mac net.HardwareAddr) net.IP {
+	return exchange(t, p, dhcpv4.MessageTypeDiscover, mac)
+}
+
+// lease returns the address leased to the client after a DISCOVER and a
+// REQUEST selecting us
+func lease(t *testing.T, p *PluginState, mac net.HardwareAddr) net.IP {
+	if discover(t, p, mac) == nil {
+		return nil
+	}
+	return exchange(t, p, dhcpv4.MessageTypeRequest, mac,
+		dhcpv4.OptServerIdentifier(serverID))
+}
+
+func TestReap(t *testing.T) {
+	p, filename := newTestState(t)
+	defer os.Remove(filename)
+	defer p.Close()
+	p.GracePeriod = time.Hour
+
+	now := time.Now()
+	mac1 := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	mac2 := net.HardwareAddr{2, 0, 0, 0, 0, 2}
+	ip1 := lease(t, p, mac1)
+	require.NotNil(t, ip1)
+	require.NotNil(t, lease(t, p, mac2))
+	// expired, but within the grace period
+	p.Recordsv4[macKey(mac1)].expires = now.Add(-time.Minute)
+	require.NoError(t, p.saveIPAddress(macKey(mac1), p.Recordsv4[macKey(mac1)]))
+	n, err := p.reap(now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	// expired for longer than the grace period
+	p.Recordsv4[macKey(mac1)].expires = now.Add(-2 * time.Hour)
+	require.NoError(t, p.saveIPAddress(macKey(mac1), p.Recordsv4[macKey(mac1)]))
+	n, err = p.reap(now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NotContains(t, p.Recordsv4, macKey(mac1))
+	assert.Contains(t, p.Recordsv4, macKey(mac2))
+
+	// the address is free again
+	mac3 := net.HardwareAddr{2, 0, 0, 0, 0, 3}
+	assert.Equal(t, ip1, lease(t, p, mac3))
+
+	// and the removal is persisted
+	require.NoError(t, p.Close())
+	store, err := leasestore.Open(filename)
+	require.NoError(t, err)
+	defer store.Close()
+	loaded, err := loadRecords(store)
+	require.NoError(t, err)
+	assert.NotContains(t, loaded, macKey(mac1))
+	assert.Contains(t, loaded, macKey(mac2))
+	assert.Contains(t, loaded, macKey(mac3))
+}
+
+func TestReclaimOldest(t *testing.T) {
+	p, filename := newTestState(t)
+	defer os.Remove(filename)
+	defer p.Close()
+	p.GracePeriod = time.Hour
+
+	mac1 := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	mac2 := net.HardwareAddr{2, 0, 0, 0, 0, 2}
+	mac3 := net.HardwareAddr{2, 0, 0, 0, 0, 3}
+	require.NotNil(t, lease(t, p, mac1))
+	ip2 := lease(t, p, mac2)
+	require.NotNil(t, ip2)
+
+	// the pool is full and no lease expired
+	assert.Nil(t, lease(t, p, mac3))
+
+	// the grace period doesn't apply when the pool is full
+	p.Recordsv4[macKey(mac1)].expires = time.Now().Add(-time.Second)
+	p.Recordsv4[macKey(mac2)].expires = time.Now().Add(-time.Minute)
+	assert.Equal(t, ip2, lease(t, p, mac3))
+	assert.NotContains(t, p.Recordsv4, macKey(mac2))
+	assert.Contains(t, p.Recordsv4, macKey(mac1))
+}
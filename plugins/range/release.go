@@ -0,0 +1,57 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"time"
+
+	"github.com/coredhcp/coredhcp/events"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/sirupsen/logrus"
+)
+
+// release frees the lease of a client sending a DHCPRELEASE for it. The lock
+// must be held.
+func (p *PluginState) release(clog *logrus.Entry, req *dhcpv4.DHCPv4, key string) {
+	rec, ok := p.Recordsv4[key]
+	if !ok || !rec.IP.Equal(req.ClientIPAddr) {
+		clog.WithField(logger.FieldIP, req.ClientIPAddr.String()).Info("Ignoring release of an address not leased to the client")
+		return
+	}
+	clog.WithField(logger.FieldIP, rec.IP.String()).Info("Client released its lease")
+	p.freeLease(key, rec, events.Released)
+}
+
+// decline removes the lease of a client sending a DHCPDECLINE for it, as the
+// address is in use by another host, and abandons the address. The lock must
+// be held.
+func (p *PluginState) decline(clog *logrus.Entry, req *dhcpv4.DHCPv4, key string, now time.Time) {
+	declined := req.RequestedIPAddress()
+	clog = clog.WithField(logger.FieldIP, declined.String())
+	rec, ok := p.Recordsv4[key]
+	if !ok || !rec.IP.Equal(declined) {
+		if o, ok := p.offers[key]; ok && o.IP.Equal(declined) {
+			clog.Warningf("Client declined its offer, abandoning the address for %s", p.AbandonTime)
+			p.abandon(key, now)
+			return
+		}
+		clog.Info("Ignoring decline of an address not leased to the client")
+		return
+	}
+	clog.Warningf("Client declined its lease, abandoning the address for %s", p.AbandonTime)
+	delete(p.Recordsv4, key)
+	if err := p.saveRemoval(key); err != nil {
+		clog.Errorf("Could not persist the removal of the lease: %v", err)
+	}
+	p.abandonAddress(rec.IP, now)
+	events.Publish(events.Event{
+		Type:   events.Declined,
+		Plugin: pluginName,
+		MAC:    req.ClientHWAddr.String(),
+		IP:     rec.IP,
+		Expiry: rec.expires,
+	})
+}
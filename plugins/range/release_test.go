@@ -0,0 +1,92 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+	"github.com/coredhcp/coredhcp/plugins/leasestore"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelease(t *testing.T) {
+	p, filename := newTestState(t)
+	defer os.Remove(filename)
+	defer p.Close()
+
+	mac1 := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	mac2 := net.HardwareAddr{2, 0, 0, 0, 0, 2}
+	ip1 := lease(t, p, mac1)
+	require.NotNil(t, ip1)
+	ip2 := lease(t, p, mac2)
+	require.NotNil(t, ip2)
+
+	// releases for another server or address are ignored
+	handle(t, p, dhcpv4.MessageTypeRelease, mac1, dhcpv4.WithClientIP(ip1),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IPv4(10, 0, 0, 253))))
+	handle(t, p, dhcpv4.MessageTypeRelease, mac1, dhcpv4.WithClientIP(ip2),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(serverID)))
+	assert.Contains(t, p.Recordsv4, macKey(mac1))
+
+	handle(t, p, dhcpv4.MessageTypeRelease, mac1, dhcpv4.WithClientIP(ip1),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(serverID)))
+	assert.NotContains(t, p.Recordsv4, macKey(mac1))
+	_, err := p.store.Get(macKey(mac1))
+	assert.Equal(t, leasestore.ErrNotFound, err)
+	// the address is free right away
+	mac3 := net.HardwareAddr{2, 0, 0, 0, 0, 3}
+	assert.Equal(t, ip1, lease(t, p, mac3))
+}
+
+func TestDecline(t *testing.T) {
+	p, filename := newTestState(t)
+	defer os.Remove(filename)
+	defer p.Close()
+
+	mac1 := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	ip1 := lease(t, p, mac1)
+	require.NotNil(t, ip1)
+
+	handle(t, p, dhcpv4.MessageTypeDecline, mac1,
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(ip1)),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(serverID)))
+	assert.NotContains(t, p.Recordsv4, macKey(mac1))
+	assert.Contains(t, p.abandoned, ip1.String())
+	_, err := p.store.Get(macKey(mac1))
+	assert.Equal(t, leasestore.ErrNotFound, err)
+
+	// the address is quarantined, the client gets another one
+	ip2 := lease(t, p, mac1)
+	require.NotNil(t, ip2)
+	assert.NotEqual(t, ip1, ip2)
+
+	// and the quarantine survives a restart
+	require.NoError(t, p.Close())
+	store, err := leasestore.Open(filename)
+	require.NoError(t, err)
+	p.store = store
+	p.Recordsv4, err = loadRecords(store)
+	require.NoError(t, err)
+	assert.Len(t, p.Recordsv4, 1)
+	p.abandoned = make(map[string]time.Time)
+	p.allocator, err = bitmap.NewIPv4Allocator(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2))
+	require.NoError(t, err)
+	_, err = p.allocator.Allocate(net.IPNet{IP: ip2})
+	require.NoError(t, err)
+	require.NoError(t, p.restoreAbandoned(time.Now()))
+	assert.Contains(t, p.abandoned, ip1.String())
+	assert.Nil(t, discover(t, p, net.HardwareAddr{2, 0, 0, 0, 0, 2}))
+
+	// until the end of the cool-down
+	assert.Equal(t, 1, p.releaseAbandoned(time.Now().Add(p.AbandonTime)))
+	_, err = p.store.Get(abandonedKeyPrefix + ip1.String())
+	assert.Equal(t, leasestore.ErrNotFound, err)
+}
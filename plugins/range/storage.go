@@ -5,86 +5,164 @@
 package rangeplugin
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
-	"io"
 	"net"
-	"os"
 	"strings"
 	"time"
+
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/leasestore"
+	"github.com/coredhcp/coredhcp/plugins/reservations"
 )
 
-// loadRecords loads the DHCPv6/v4 Records global map with records stored on
-// the specified file. The records have to be one per line, a mac address and an
-// IP address.
-func loadRecords(r io.Reader) (map[string]*Record, error) {
-	sc := bufio.NewScanner(r)
+// abandonedKeyPrefix starts the keys of the abandoned addresses in the lease
+// store, which expire at the end of their cool-down
+const abandonedKeyPrefix = "abandoned:"
+
+// loadRecords loads the records from a lease store. The keys of the leases are
+// lease keys, see keySpec, and their addresses IPv4 addresses. Leases stored
+// by hardware address only, before the kind of key was recorded, are stored
+// again with their new key.
+func loadRecords(store leasestore.Store) (map[string]*Record, error) {
 	records := make(map[string]*Record)
-	for sc.Scan() {
-		line := sc.Text()
-		if len(line) == 0 {
-			continue
+	var legacy []string
+	err := store.Iterate(func(l *leasestore.Lease) error {
+		if strings.HasPrefix(l.Key, abandonedKeyPrefix) {
+			return nil
 		}
-		tokens := strings.Fields(line)
-		if len(tokens) != 3 {
-			return nil, fmt.Errorf("malformed line, want 3 fields, got %d: %s", len(tokens), line)
-		}
-		hwaddr, err := net.ParseMAC(tokens[0])
+		key, err := parseKey(l.Key)
 		if err != nil {
-			return nil, fmt.Errorf("malformed hardware address: %s", tokens[0])
+			return err
 		}
-		ipaddr := net.ParseIP(tokens[1])
+		ipaddr := net.ParseIP(l.Address)
 		if ipaddr.To4() == nil {
-			return nil, fmt.Errorf("expected an IPv4 address, got: %v", ipaddr)
+			return fmt.Errorf("expected an IPv4 address, got: %v", l.Address)
+		}
+		if key != l.Key {
+			legacy = append(legacy, l.Key)
 		}
-		expires, err := time.Parse(time.RFC3339, tokens[2])
+		records[key] = &Record{IP: ipaddr, expires: l.Expires}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, old := range legacy {
+		key, _ := parseKey(old)
+		rec := records[key]
+		err := store.Put(&leasestore.Lease{Key: key, Address: rec.IP.String(), Expires: rec.expires})
 		if err != nil {
-			return nil, fmt.Errorf("expected time of exipry in RFC3339 format, got: %v", tokens[2])
+			return nil, fmt.Errorf("could not migrate lease %s: %w", old, err)
+		}
+		if err := store.Delete(old); err != nil {
+			return nil, fmt.Errorf("could not migrate lease %s: %w", old, err)
 		}
-		records[hwaddr.String()] = &Record{IP: ipaddr, expires: expires}
+	}
+	if len(legacy) > 0 {
+		log.Infof("Migrated %d leases to keys recording their kind", len(legacy))
 	}
 	return records, nil
 }
 
-func loadRecordsFromFile(filename string) (map[string]*Record, error) {
-	reader, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0640)
-	defer func() {
-		if err := reader.Close(); err != nil {
-			log.Warningf("Failed to close file %s: %v", filename, err)
+// loadAbandoned loads the abandoned addresses from a lease store, with the end
+// of their cool-down
+func loadAbandoned(store leasestore.Store) (map[string]time.Time, error) {
+	abandoned := make(map[string]time.Time)
+	err := store.Iterate(func(l *leasestore.Lease) error {
+		if !strings.HasPrefix(l.Key, abandonedKeyPrefix) {
+			return nil
+		}
+		ipaddr := net.ParseIP(l.Address)
+		if ipaddr.To4() == nil {
+			return fmt.Errorf("expected an IPv4 address, got: %v", l.Address)
 		}
-	}()
+		abandoned[ipaddr.String()] = l.Expires
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("cannot open lease file %s: %w", filename, err)
+		return nil, err
 	}
-	return loadRecords(reader)
+	return abandoned, nil
+}
+
+// saveAbandoned records an abandoned address, until the end of its cool-down
+func (p *PluginState) saveAbandoned(ip string, until time.Time) error {
+	if p.store == nil {
+		return errors.New("lease store is closed")
+	}
+	return p.store.Put(&leasestore.Lease{
+		Key:     abandonedKeyPrefix + ip,
+		Address: ip,
+		Expires: until,
+	})
 }
 
 // saveIPAddress writes out a lease to storage
-func (p *PluginState) saveIPAddress(mac net.HardwareAddr, record *Record) error {
-	_, err := p.leasefile.WriteString(mac.String() + " " + record.IP.String() + " " + record.expires.Format(time.RFC3339) + "\n")
-	if err != nil {
-		return err
+func (p *PluginState) saveIPAddress(key string, record *Record) error {
+	if p.store == nil {
+		return errors.New("lease store is closed")
+	}
+	return p.store.Put(&leasestore.Lease{
+		Key:     key,
+		Address: record.IP.String(),
+		Expires: record.expires,
+	})
+}
+
+// saveRemoval records the removal of a lease, or of another key
+func (p *PluginState) saveRemoval(key string) error {
+	if p.store == nil {
+		return errors.New("lease store is closed")
+	}
+	return p.store.Delete(key)
+}
+
+// openStore opens the lease store of the plugin, see leasestore.Open for the
+// format of spec
+func (p *PluginState) openStore(spec string) error {
+	if p.store != nil {
+		// This is TODO; swapping the store out is easy
+		// but maintaining consistency with the in-memory state isn't
+		return errors.New("cannot swap out a lease store while running")
 	}
-	err = p.leasefile.Sync()
+	// This is closed by Close when the server shuts down
+	store, err := leasestore.Open(spec)
 	if err != nil {
 		return err
 	}
+	p.store = store
 	return nil
 }
 
-// registerBackingFile installs a file as the backing store for leases
-func (p *PluginState) registerBackingFile(filename string) error {
-	if p.leasefile != nil {
-		// This is TODO; swapping the file out is easy
-		// but maintaining consistency with the in-memory state isn't
-		return errors.New("cannot swap out a lease storage file while running")
+// Close stops the maintenance of the leases, closes the lease store and
+// unregisters the pool. It implements plugins.Closer.
+func (p *PluginState) Close() error {
+	p.Lock()
+	defer p.Unlock()
+	reservations.RemovePool(p)
+	if p.stopMaintenance != nil {
+		close(p.stopMaintenance)
+		p.stopMaintenance = nil
 	}
-	// We never close this, but that's ok because plugins are never stopped/unregistered
-	newLeasefile, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open lease file %s: %w", filename, err)
+	if p.store == nil {
+		return nil
+	}
+	err := p.store.Close()
+	p.store = nil
+	return err
+}
+
+// Health checks that leases can still be saved, if the lease store can tell.
+// It implements plugins.HealthChecker.
+func (p *PluginState) Health() error {
+	p.Lock()
+	defer p.Unlock()
+	if p.store == nil {
+		return errors.New("lease store is closed")
+	}
+	if hc, ok := p.store.(plugins.HealthChecker); ok {
+		return hc.Health()
 	}
-	p.leasefile = newLeasefile
 	return nil
 }
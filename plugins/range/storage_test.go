@@ -8,14 +8,25 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
-	"strings"
 	"testing"
 	"time"
 
+	"github.com/coredhcp/coredhcp/plugins/leasestore"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-var leasefile string = `02:00:00:00:00:00 10.0.0.0 2000-01-01T00:00:00Z
+var leasefile string = `mac=02:00:00:00:00:00 10.0.0.0 2000-01-01T00:00:00Z
+mac=02:00:00:00:00:01 10.0.0.1 2000-01-01T00:00:00Z
+mac=02:00:00:00:00:02 10.0.0.2 2000-01-01T00:00:00Z
+mac=02:00:00:00:00:03 10.0.0.3 2000-01-01T00:00:00Z
+mac=02:00:00:00:00:04 10.0.0.4 2000-01-01T00:00:00Z
+mac=02:00:00:00:00:05 10.0.0.5 2000-01-01T00:00:00Z
+`
+
+// legacyLeasefile is keyed by bare hardware addresses, as before the key kind
+// was recorded
+var legacyLeasefile string = `02:00:00:00:00:00 10.0.0.0 2000-01-01T00:00:00Z
 02:00:00:00:00:01 10.0.0.1 2000-01-01T00:00:00Z
 02:00:00:00:00:02 10.0.0.2 2000-01-01T00:00:00Z
 02:00:00:00:00:03 10.0.0.3 2000-01-01T00:00:00Z
@@ -25,61 +36,107 @@ var leasefile string = `02:00:00:00:00:00 10.0.0.0 2000-01-01T00:00:00Z
 
 var expire = time.Date(2000, 01, 01, 00, 00, 00, 00, time.UTC)
 var records = []struct {
-	mac string
+	key string
 	ip  *Record
 }{
-	{"02:00:00:00:00:00", &Record{net.IPv4(10, 0, 0, 0), expire}},
-	{"02:00:00:00:00:01", &Record{net.IPv4(10, 0, 0, 1), expire}},
-	{"02:00:00:00:00:02", &Record{net.IPv4(10, 0, 0, 2), expire}},
-	{"02:00:00:00:00:03", &Record{net.IPv4(10, 0, 0, 3), expire}},
-	{"02:00:00:00:00:04", &Record{net.IPv4(10, 0, 0, 4), expire}},
-	{"02:00:00:00:00:05", &Record{net.IPv4(10, 0, 0, 5), expire}},
+	{"mac=02:00:00:00:00:00", &Record{net.IPv4(10, 0, 0, 0), expire}},
+	{"mac=02:00:00:00:00:01", &Record{net.IPv4(10, 0, 0, 1), expire}},
+	{"mac=02:00:00:00:00:02", &Record{net.IPv4(10, 0, 0, 2), expire}},
+	{"mac=02:00:00:00:00:03", &Record{net.IPv4(10, 0, 0, 3), expire}},
+	{"mac=02:00:00:00:00:04", &Record{net.IPv4(10, 0, 0, 4), expire}},
+	{"mac=02:00:00:00:00:05", &Record{net.IPv4(10, 0, 0, 5), expire}},
 }
 
 func TestLoadRecords(t *testing.T) {
-	parsedRec, err := loadRecords(strings.NewReader(leasefile))
+	tmpfile, err := ioutil.TempFile("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name())
+	_, err = tmpfile.WriteString(leasefile)
+	require.NoError(t, err)
+	tmpfile.Close()
+
+	store, err := leasestore.Open(tmpfile.Name())
+	require.NoError(t, err)
+	defer store.Close()
+	parsedRec, err := loadRecords(store)
 	if err != nil {
 		t.Fatalf("Failed to load records from file: %v", err)
 	}
 
 	mapRec := make(map[string]*Record)
 	for _, rec := range records {
-		mapRec[rec.mac] = rec.ip
+		mapRec[rec.key] = rec.ip
 	}
 
 	assert.Equal(t, mapRec, parsedRec, "Loaded records differ from what's in the file")
 }
 
+func TestLoadLegacyRecords(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name())
+	_, err = tmpfile.WriteString(legacyLeasefile)
+	require.NoError(t, err)
+	tmpfile.Close()
+
+	store, err := leasestore.Open(tmpfile.Name())
+	require.NoError(t, err)
+	parsedRec, err := loadRecords(store)
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	mapRec := make(map[string]*Record)
+	for _, rec := range records {
+		mapRec[rec.key] = rec.ip
+	}
+	assert.Equal(t, mapRec, parsedRec)
+
+	// the store was rewritten with the kind of the keys
+	store, err = leasestore.Open(tmpfile.Name())
+	require.NoError(t, err)
+	defer store.Close()
+	_, err = store.Get("02:00:00:00:00:01")
+	assert.Equal(t, leasestore.ErrNotFound, err)
+	l, err := store.Get("mac=02:00:00:00:00:01")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", l.Address)
+}
+
+func TestLoadInvalidRecords(t *testing.T) {
+	for _, l := range []leasestore.Lease{
+		{Key: "02:00:00:00:00", Address: "10.0.0.1"},
+		{Key: "02:00:00:00:00:01", Address: "2001:db8::1"},
+		{Key: "=02:00:00:00:00:01", Address: "10.0.0.1"},
+		{Key: "client-id=", Address: "10.0.0.1"},
+	} {
+		store := leasestore.NewMemory()
+		require.NoError(t, store.Put(&l))
+		_, err := loadRecords(store)
+		assert.Error(t, err, l)
+	}
+}
+
 func TestWriteRecords(t *testing.T) {
 	tmpfile, err := ioutil.TempFile("", "coredhcptest")
 	if err != nil {
 		t.Skipf("Could not setup file-based test: %v", err)
 	}
 	defer os.Remove(tmpfile.Name())
-	defer tmpfile.Close()
+	tmpfile.Close()
 
 	pl := PluginState{}
-	if err := pl.registerBackingFile(tmpfile.Name()); err != nil {
+	if err := pl.openStore(tmpfile.Name()); err != nil {
 		t.Fatalf("Could not setup file")
 	}
-	defer pl.leasefile.Close()
+	defer pl.Close()
 
 	for _, rec := range records {
-		hwaddr, err := net.ParseMAC(rec.mac)
-		if err != nil {
-			// bug in testdata
-			panic(err)
-		}
-		if err := pl.saveIPAddress(hwaddr, rec.ip); err != nil {
-			t.Errorf("Failed to save ip for %s: %v", hwaddr, err)
+		if err := pl.saveIPAddress(rec.key, rec.ip); err != nil {
+			t.Errorf("Failed to save ip for %s: %v", rec.key, err)
 		}
 	}
 
-	if _, err := tmpfile.Seek(0, 0); err != nil {
-		t.Fatal(err)
-	}
-
-	written, err := ioutil.ReadAll(tmpfile)
+	written, err := ioutil.ReadFile(tmpfile.Name())
 	if err != nil {
 		t.Fatalf("Could not read back temp file")
 	}
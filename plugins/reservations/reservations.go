@@ -0,0 +1,186 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package reservations keeps track of the IPv4 addresses handed out by the
+// plugins, so that the dynamic pools of the range plugin don't overlap each
+// other, and skip the addresses statically reserved by the file plugin.
+package reservations
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Range is an inclusive range of IPv4 addresses
+type Range struct {
+	Start net.IP
+	End   net.IP
+}
+
+// ParseRange parses a range of IPv4 addresses, given as "<first>-<last>", or
+// as a single address
+func ParseRange(s string) (Range, error) {
+	first, last := s, s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		first, last = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	}
+	r := Range{Start: net.ParseIP(first).To4(), End: net.ParseIP(last).To4()}
+	if r.Start == nil || r.End == nil {
+		return Range{}, fmt.Errorf("invalid IPv4 range: %s", s)
+	}
+	if bytes.Compare(r.Start, r.End) > 0 {
+		return Range{}, fmt.Errorf("invalid IPv4 range %s: the first address is after the last one", s)
+	}
+	return r, nil
+}
+
+// Contains tells whether an address is in the range
+func (r Range) Contains(ip net.IP) bool {
+	ip = ip.To4()
+	return ip != nil && bytes.Compare(r.Start.To4(), ip) <= 0 && bytes.Compare(ip, r.End.To4()) <= 0
+}
+
+// Overlaps tells whether two ranges have addresses in common
+func (r Range) Overlaps(o Range) bool {
+	return bytes.Compare(r.Start.To4(), o.End.To4()) <= 0 && bytes.Compare(o.Start.To4(), r.End.To4()) <= 0
+}
+
+func (r Range) String() string {
+	if r.Start.Equal(r.End) {
+		return r.Start.String()
+	}
+	return r.Start.String() + "-" + r.End.String()
+}
+
+// Pool is a dynamic pool of addresses, like the one of a range plugin
+// instance. Pools are told apart by comparing them, they are typically
+// pointers.
+type Pool interface {
+	// LeasedTo tells whether an address of the pool is leased, and to
+	// which hardware address, if the lease is keyed by it
+	LeasedTo(ip net.IP) (mac string, leased bool)
+}
+
+// pool is a registered pool, with the ranges it hands out addresses from
+type pool struct {
+	name   string
+	ranges []Range
+}
+
+var (
+	lock  sync.Mutex
+	pools = make(map[Pool]*pool)
+	// reserved holds the addresses reserved by each owner, mapped to the
+	// hardware address of the client they are reserved for
+	reserved = make(map[interface{}]map[string]string)
+)
+
+// AddPool registers the ranges a pool hands out addresses from, named after
+// its lease file in errors. It fails if another pool has addresses in common.
+func AddPool(p Pool, name string, ranges []Range) error {
+	lock.Lock()
+	defer lock.Unlock()
+	for other, o := range pools {
+		if other == p {
+			continue
+		}
+		for _, r := range ranges {
+			for _, or := range o.ranges {
+				if r.Overlaps(or) {
+					return fmt.Errorf("range %s overlaps range %s of the pool of %s", r, or, o.name)
+				}
+			}
+		}
+	}
+	pools[p] = &pool{name: name, ranges: ranges}
+	return nil
+}
+
+// RemovePool unregisters a pool
+func RemovePool(p Pool) {
+	lock.Lock()
+	defer lock.Unlock()
+	delete(pools, p)
+}
+
+// Reserve replaces the addresses reserved by an owner, mapping the addresses
+// to the hardware addresses of their clients. It fails, keeping the current
+// reservations, if a pool leased one of the addresses to another client.
+func Reserve(owner interface{}, addrs map[string]string) error {
+	lock.Lock()
+	var leased []Pool
+	names := make(map[Pool]string)
+	for p, o := range pools {
+		for addr := range addrs {
+			if o.contains(net.ParseIP(addr)) {
+				leased = append(leased, p)
+				names[p] = o.name
+				break
+			}
+		}
+	}
+	lock.Unlock()
+
+	// the pools are called without the lock, as they check the reservations
+	// while holding their own
+	for _, p := range leased {
+		for addr, mac := range addrs {
+			holder, ok := p.LeasedTo(net.ParseIP(addr))
+			if ok && holder != mac {
+				if holder == "" {
+					holder = "another client"
+				}
+				return fmt.Errorf("address %s reserved for %s is leased to %s in the pool of %s", addr, mac, holder, names[p])
+			}
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	reserved[owner] = addrs
+	return nil
+}
+
+// Release drops the addresses reserved by an owner
+func Release(owner interface{}) {
+	lock.Lock()
+	defer lock.Unlock()
+	delete(reserved, owner)
+}
+
+// ReservedFor tells whether an address is reserved, and for which hardware
+// address
+func ReservedFor(ip net.IP) (mac string, ok bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	addr := ip.String()
+	for _, addrs := range reserved {
+		if mac, ok := addrs[addr]; ok {
+			return mac, true
+		}
+	}
+	return "", false
+}
+
+// Reset forgets all the pools and reservations, before the plugins are set up
+// again
+func Reset() {
+	lock.Lock()
+	defer lock.Unlock()
+	pools = make(map[Pool]*pool)
+	reserved = make(map[interface{}]map[string]string)
+}
+
+// contains tells whether an address is in one of the ranges of the pool
+func (p *pool) contains(ip net.IP) bool {
+	for _, r := range p.ranges {
+		if r.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package reservations
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	r, err := ParseRange("10.0.0.1-10.0.0.10")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1-10.0.0.10", r.String())
+	assert.True(t, r.Contains(net.ParseIP("10.0.0.10")))
+	assert.False(t, r.Contains(net.ParseIP("10.0.0.11")))
+
+	r, err = ParseRange("10.0.0.5")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.5", r.String())
+
+	for _, s := range []string{"", "10.0.0.1-", "10.0.0.10-10.0.0.1", "2001:db8::1", "10.0.0.1-10.0.0.2-10.0.0.3"} {
+		_, err := ParseRange(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	r := Range{Start: net.IPv4(10, 0, 0, 10), End: net.IPv4(10, 0, 0, 20)}
+	assert.True(t, r.Overlaps(Range{Start: net.IPv4(10, 0, 0, 20), End: net.IPv4(10, 0, 0, 30)}))
+	assert.True(t, r.Overlaps(Range{Start: net.IPv4(10, 0, 0, 12), End: net.IPv4(10, 0, 0, 12)}))
+	assert.False(t, r.Overlaps(Range{Start: net.IPv4(10, 0, 0, 21), End: net.IPv4(10, 0, 0, 30)}))
+}
+
+// fakePool leases addresses to hardware addresses
+type fakePool struct {
+	leases map[string]string
+}
+
+func (f *fakePool) LeasedTo(ip net.IP) (string, bool) {
+	mac, ok := f.leases[ip.String()]
+	return mac, ok
+}
+
+func TestPools(t *testing.T) {
+	Reset()
+	defer Reset()
+
+	p1 := &fakePool{leases: map[string]string{"10.0.0.5": "02:00:00:00:00:01", "10.0.0.6": ""}}
+	require.NoError(t, AddPool(p1, "leases1.txt", []Range{{Start: net.IPv4(10, 0, 0, 1), End: net.IPv4(10, 0, 0, 10)}}))
+	// registering again replaces the ranges
+	require.NoError(t, AddPool(p1, "leases1.txt", []Range{{Start: net.IPv4(10, 0, 0, 1), End: net.IPv4(10, 0, 0, 10)}}))
+
+	p2 := &fakePool{}
+	err := AddPool(p2, "leases2.txt", []Range{{Start: net.IPv4(10, 0, 0, 10), End: net.IPv4(10, 0, 0, 20)}})
+	assert.EqualError(t, err, "range 10.0.0.10-10.0.0.20 overlaps range 10.0.0.1-10.0.0.10 of the pool of leases1.txt")
+	assert.NoError(t, AddPool(p2, "leases2.txt", []Range{{Start: net.IPv4(10, 0, 0, 11), End: net.IPv4(10, 0, 0, 20)}}))
+
+	// addresses leased to their client, or not leased, can be reserved
+	owner := new(int)
+	require.NoError(t, Reserve(owner, map[string]string{"10.0.0.5": "02:00:00:00:00:01", "10.0.0.7": "02:00:00:00:00:02"}))
+	mac, ok := ReservedFor(net.IPv4(10, 0, 0, 7))
+	assert.True(t, ok)
+	assert.Equal(t, "02:00:00:00:00:02", mac)
+
+	// the others can't, and the current reservations are kept
+	err = Reserve(owner, map[string]string{"10.0.0.5": "02:00:00:00:00:03"})
+	assert.EqualError(t, err, "address 10.0.0.5 reserved for 02:00:00:00:00:03 is leased to 02:00:00:00:00:01 in the pool of leases1.txt")
+	err = Reserve(owner, map[string]string{"10.0.0.6": "02:00:00:00:00:03"})
+	assert.EqualError(t, err, "address 10.0.0.6 reserved for 02:00:00:00:00:03 is leased to another client in the pool of leases1.txt")
+	_, ok = ReservedFor(net.IPv4(10, 0, 0, 7))
+	assert.True(t, ok)
+
+	// a removed pool doesn't conflict anymore
+	RemovePool(p1)
+	assert.NoError(t, Reserve(owner, map[string]string{"10.0.0.5": "02:00:00:00:00:03"}))
+	_, ok = ReservedFor(net.IPv4(10, 0, 0, 7))
+	assert.False(t, ok)
+
+	Release(owner)
+	_, ok = ReservedFor(net.IPv4(10, 0, 0, 5))
+	assert.False(t, ok)
+}
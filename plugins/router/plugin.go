@@ -20,30 +20,35 @@ var log = logger.GetLogger("plugins/router")
 var Plugin = plugins.Plugin{
 	Name:   "router",
 	Setup4: setup4,
+	Args: []plugins.Arg{
+		{Name: "router", Type: "IPv4", Help: "address of a default router", Repeated: true},
+	},
 }
 
-var (
-	routers []net.IP
-)
+// PluginState is the data held by an instance of the router plugin
+type PluginState struct {
+	Routers []net.IP
+}
 
 func setup4(args ...string) (handler.Handler4, error) {
 	log.Printf("Loaded plugin for DHCPv4.")
 	if len(args) < 1 {
 		return nil, errors.New("need at least one router IP address")
 	}
+	var p PluginState
 	for _, arg := range args {
 		router := net.ParseIP(arg)
 		if router.To4() == nil {
-			return Handler4, errors.New("expected an router IP address, got: " + arg)
+			return nil, errors.New("expected an router IP address, got: " + arg)
 		}
-		routers = append(routers, router)
+		p.Routers = append(p.Routers, router)
 	}
-	log.Infof("loaded %d router IP addresses.", len(routers))
-	return Handler4, nil
+	log.Infof("loaded %d router IP addresses.", len(p.Routers))
+	return p.Handler4, nil
 }
 
 //Handler4 handles DHCPv4 packets for the router plugin
-func Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
-	resp.Options.Update(dhcpv4.OptRouter(routers...))
+func (p *PluginState) Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	resp.Options.Update(dhcpv4.OptRouter(p.Routers...))
 	return resp, false
 }
@@ -0,0 +1,288 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package script runs a Starlark script (https://github.com/bazelbuild/starlark,
+// a dialect of Python) for every request.
+//
+// The script defines a function handle4(req, resp) for DHCPv4, and/or
+// handle6(req, resp) for DHCPv6, which is called with the request and the
+// response computed by the previous plugins. For example:
+//
+//  $ cat policy.star
+//  def handle4(req, resp):
+//      if req.hostname.startswith("printer-"):
+//          resp.set_option(42, ["10.0.0.1", "10.0.0.2"], kind="ip")
+//      if req.options.get(77) == "guest":
+//          resp.yiaddr = "10.1.0.10"
+//          resp.stop = True
+//      state["requests"] = state.get("requests", 0) + 1
+//
+//  $ cat config.yml
+//
+//  server4:
+//     ...
+//     plugins:
+//       - script: "policy.star"
+//     ...
+//
+// req is read-only, and has the fields interface, classes, message_type, xid,
+// mac, hostname, ciaddr, giaddr (v4), duid (v6) and options. Classes is the
+// list of the classes the previous plugins put the client in. Options map
+// option codes to their raw value as a string; for DHCPv6, to the list of the
+// values of all the options with that code.
+//
+// resp has the fields message_type, yiaddr (v4), and the flags stop, to send
+// the response without running the next plugins, and drop, to not answer at
+// all. Options are changed with get_option(code), set_option(code, value,
+// kind="string") and del_option(code), where kind is one of string, hex, ip
+// (an address or a list of addresses), uint8, uint16 or uint32.
+//
+// The global state is a dict kept across requests, and across reloads of the
+// script. The globals of the script itself are frozen once it is loaded, so
+// values that must change belong in state.
+//
+// The script is loaded again whenever the file changes, and on SIGHUP. If the
+// new version fails to load, the previous one is kept. Requests are handled
+// by the script one at a time. If the script fails, the error is logged and
+// the response is left unchanged.
+package script
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/fsnotify/fsnotify"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+)
+
+var log = logger.GetLogger("plugins/script")
+
+const pluginName = "script"
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:   pluginName,
+	Setup6: setup6,
+	Setup4: setup4,
+	Args: []plugins.Arg{
+		{Name: "file", Type: "path", Help: "Starlark script defining handle4(req, resp) and/or handle6(req, resp)"},
+	},
+}
+
+// Names of the functions the script defines
+const (
+	entryPoint4 = "handle4"
+	entryPoint6 = "handle6"
+)
+
+// PluginState is the data held by an instance of the script plugin
+type PluginState struct {
+	// Lock for the whole plugin, held while the script runs
+	sync.Mutex
+	filename string
+	// entryPoint is the name of the function called for every request
+	entryPoint string
+	handler    starlark.Callable
+	state      *starlark.Dict
+	watcher    *fsnotify.Watcher
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	p, err := setupScript(entryPoint4, args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	p, err := setupScript(entryPoint6, args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler6, nil
+}
+
+func setupScript(entryPoint string, args ...string) (*PluginState, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("want exactly one argument (script file), got %d", len(args))
+	}
+	if args[0] == "" {
+		return nil, errors.New("got empty file name")
+	}
+	p := &PluginState{
+		filename:   filepath.Clean(args[0]),
+		entryPoint: entryPoint,
+		state:      new(starlark.Dict),
+	}
+	// the script is loaded even in dry-run mode, to report errors in it
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	if plugins.DryRun() {
+		return p, nil
+	}
+
+	// watch the directory rather than the file, so that a script replaced by
+	// a rename, as many editors do, is still picked up
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create watcher: %w", err)
+	}
+	if err := watcher.Add(filepath.Dir(p.filename)); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("failed to watch %s: %w", p.filename, err)
+	}
+	p.watcher = watcher
+	go p.watch()
+
+	plugins.Manage(p)
+	log.Infof("loaded %s", p.filename)
+	return p, nil
+}
+
+// watch reloads the script whenever it changes. It stops when Close closes
+// the watcher.
+func (p *PluginState) watch() {
+	for {
+		select {
+		case ev, ok := <-p.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(ev.Name) != p.filename || ev.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			if err := p.Reload(); err != nil {
+				log.Warningf("keeping the previous version of %s: %v", p.filename, err)
+				continue
+			}
+			log.Infof("reloaded %s", p.filename)
+		case err, ok := <-p.watcher.Errors:
+			// the watcher stops sending events until its errors are read
+			if !ok {
+				return
+			}
+			log.Warningf("error watching %s: %v", p.filename, err)
+		}
+	}
+}
+
+// Reload loads the script again. If it cannot be loaded, the current version
+// is kept. It implements plugins.Reloader.
+func (p *PluginState) Reload() error {
+	src, err := ioutil.ReadFile(p.filename)
+	if err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	globals, err := starlark.ExecFile(p.thread(nil), p.filename, src, starlark.StringDict{"state": p.state})
+	if err != nil {
+		return describe(err)
+	}
+	fn, ok := globals[p.entryPoint].(starlark.Callable)
+	if !ok {
+		return fmt.Errorf("%s: %s is not defined, or is not a function", p.filename, p.entryPoint)
+	}
+	p.handler = fn
+	return nil
+}
+
+// Close stops watching the script. It implements plugins.Closer.
+func (p *PluginState) Close() error {
+	if p.watcher == nil {
+		return nil
+	}
+	return p.watcher.Close()
+}
+
+// thread returns a Starlark thread printing to clog, or the plugin logger if
+// nil
+func (p *PluginState) thread(clog logrus.FieldLogger) *starlark.Thread {
+	if clog == nil {
+		clog = log
+	}
+	return &starlark.Thread{
+		Name: p.filename,
+		Print: func(_ *starlark.Thread, msg string) {
+			clog.Info(msg)
+		},
+	}
+}
+
+// run calls the script with a request and a response
+func (p *PluginState) run(clog logrus.FieldLogger, req starlark.Value, resp *response) error {
+	p.Lock()
+	defer p.Unlock()
+	_, err := starlark.Call(p.thread(clog), p.handler, starlark.Tuple{req, resp}, nil)
+	return describe(err)
+}
+
+// describe adds the Starlark backtrace to evaluation errors
+func describe(err error) error {
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		return errors.New(evalErr.Backtrace())
+	}
+	return err
+}
+
+// Handler4 handles DHCPv4 packets for the script plugin
+func (p *PluginState) Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	clog := log.WithFields(logrus.Fields{
+		logger.FieldMAC:       req.ClientHWAddr.String(),
+		logger.FieldInterface: state.InterfaceName,
+	})
+	r := newResponse4(resp)
+	if err := p.run(clog, request4(state, req), r); err != nil {
+		clog.Errorf("%s failed: %v", p.filename, err)
+		return resp, false
+	}
+	if r.drop {
+		clog.Debugf("dropping request as asked by %s", p.filename)
+		return nil, true
+	}
+	resp.Options = r.options4
+	resp.YourIPAddr = r.yiaddr
+	return resp, r.stop
+}
+
+// Handler6 handles DHCPv6 packets for the script plugin
+func (p *PluginState) Handler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	m, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("BUG: could not decapsulate: %v", err)
+		return nil, true
+	}
+	msg, ok := resp.(*dhcpv6.Message)
+	if !ok {
+		log.Errorf("BUG: response is not a message: %T", resp)
+		return nil, true
+	}
+	clog := log.WithField(logger.FieldInterface, state.InterfaceName)
+	if mac, err := dhcpv6.ExtractMAC(req); err == nil {
+		clog = clog.WithField(logger.FieldMAC, mac.String())
+	}
+	r := newResponse6(msg)
+	if err := p.run(clog, request6(state, req, m), r); err != nil {
+		clog.Errorf("%s failed: %v", p.filename, err)
+		return resp, false
+	}
+	if r.drop {
+		clog.Debugf("dropping request as asked by %s", p.filename)
+		return nil, true
+	}
+	msg.Options.Options = r.options6
+	return msg, r.stop
+}
@@ -0,0 +1,185 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package script
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const script4 = `
+def handle4(req, resp):
+    state["count"] = state.get("count", 0) + 1
+    if req.hostname == "drop":
+        resp.drop = True
+        return
+    if req.hostname == "fail":
+        resp.set_option(42, "10.0.0.1", kind="ip")
+        fail("failing on purpose")
+    resp.set_option(42, ["10.0.0.1", "10.0.0.2"], kind="ip")
+    resp.set_option(26, 1400, kind="uint16")
+    resp.set_option(15, req.interface)
+    resp.set_option(17, ",".join(req.classes))
+    resp.del_option(3)
+    resp.yiaddr = "10.0.0." + str(state["count"])
+    resp.stop = req.options.get(12) == "stop"
+`
+
+func writeScript(t *testing.T, dir, src string) string {
+	path := filepath.Join(dir, "policy.star")
+	require.NoError(t, ioutil.WriteFile(path, []byte(src), 0644))
+	return path
+}
+
+func newRequest4(t *testing.T, hostname string) (*dhcpv4.DHCPv4, *dhcpv4.DHCPv4) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0, 1, 2, 3, 4, 5}, dhcpv4.WithOption(dhcpv4.OptHostName(hostname)))
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithRouter(net.IPv4(10, 0, 0, 254)))
+	require.NoError(t, err)
+	return req, resp
+}
+
+func TestHandler4(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, err := setupScript(entryPoint4, writeScript(t, dir, script4))
+	require.NoError(t, err)
+	defer p.Close()
+	state := &handler.PropagateState{InterfaceName: "eth0", Classes: []string{"lab", "wired"}}
+
+	req, resp := newRequest4(t, "host")
+	resp, stop := p.Handler4(state, req, resp)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+	assert.Equal(t, []byte{10, 0, 0, 1, 10, 0, 0, 2}, resp.Options.Get(dhcpv4.OptionNTPServers))
+	assert.Equal(t, []byte{0x05, 0x78}, resp.Options.Get(dhcpv4.OptionInterfaceMTU))
+	assert.Equal(t, "eth0", resp.DomainName())
+	assert.Equal(t, []byte("lab,wired"), resp.Options.Get(dhcpv4.OptionRootPath))
+	assert.False(t, resp.Options.Has(dhcpv4.OptionRouter))
+	assert.Equal(t, net.IPv4(10, 0, 0, 1).To4(), resp.YourIPAddr)
+
+	// state is kept across requests
+	req, resp = newRequest4(t, "stop")
+	resp, stop = p.Handler4(state, req, resp)
+	require.NotNil(t, resp)
+	assert.True(t, stop)
+	assert.Equal(t, net.IPv4(10, 0, 0, 2).To4(), resp.YourIPAddr)
+
+	req, resp = newRequest4(t, "drop")
+	resp, stop = p.Handler4(state, req, resp)
+	assert.Nil(t, resp)
+	assert.True(t, stop)
+
+	// a failing script leaves the response unchanged
+	req, resp = newRequest4(t, "fail")
+	resp, stop = p.Handler4(state, req, resp)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+	assert.False(t, resp.Options.Has(dhcpv4.OptionNTPServers))
+	assert.True(t, resp.Options.Has(dhcpv4.OptionRouter))
+}
+
+func TestHandler6(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := writeScript(t, dir, `
+def handle6(req, resp):
+    if req.mac != "00:01:02:03:04:05" or req.message_type != "SOLICIT" or not req.duid:
+        fail("unexpected request %s" % req)
+    if len(req.options[1]) != 1:
+        fail("expected one client ID")
+    if req.classes != ["guests"]:
+        fail("unexpected classes %s" % req.classes)
+    resp.set_option(23, ["2001:db8::53", "2001:db8::54"], kind="ip")
+    resp.stop = True
+`)
+	h, err := setup6(path)
+	require.NoError(t, err)
+	req, err := dhcpv6.NewSolicit(net.HardwareAddr{0, 1, 2, 3, 4, 5})
+	require.NoError(t, err)
+	resp, err := dhcpv6.NewAdvertiseFromSolicit(req)
+	require.NoError(t, err)
+
+	result, stop := h(&handler.PropagateState{InterfaceName: "eth0", Classes: []string{"guests"}}, req, resp)
+	require.NotNil(t, result)
+	assert.True(t, stop)
+	dns := result.(*dhcpv6.Message).Options.Get(dhcpv6.OptionDNSRecursiveNameServer)
+	if assert.Len(t, dns, 1) {
+		assert.Equal(t, []byte(append(net.ParseIP("2001:db8::53"), net.ParseIP("2001:db8::54")...)), dns[0].ToBytes())
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := writeScript(t, dir, script4)
+	p, err := setupScript(entryPoint4, path)
+	require.NoError(t, err)
+	defer p.Close()
+	state := &handler.PropagateState{InterfaceName: "eth0"}
+	req, resp := newRequest4(t, "host")
+	p.Handler4(state, req, resp)
+
+	// the new version is picked up when the file changes, and keeps the state
+	writeScript(t, dir, `
+def handle4(req, resp):
+    resp.yiaddr = "10.1.0.%d" % state["count"]
+`)
+	require.Eventually(t, func() bool {
+		req, resp := newRequest4(t, "host")
+		resp, _ = p.Handler4(state, req, resp)
+		return net.IPv4(10, 1, 0, 1).To4().Equal(resp.YourIPAddr)
+	}, time.Second, 10*time.Millisecond)
+
+	// a broken version is rejected
+	writeScript(t, dir, "def handle6(req, resp):\n    pass\n")
+	assert.Error(t, p.Reload())
+	writeScript(t, dir, "def handle4(req, resp)\n")
+	assert.Error(t, p.Reload())
+	req, resp = newRequest4(t, "host")
+	resp, _ = p.Handler4(state, req, resp)
+	assert.Equal(t, net.IPv4(10, 1, 0, 1).To4(), resp.YourIPAddr)
+}
+
+func TestEncodeOption(t *testing.T) {
+	for _, src := range []string{
+		`resp.set_option(42, "10.0.0", kind="ip")`,
+		`resp.set_option(42, "2001:db8::1", kind="ip")`,
+		`resp.set_option(26, 70000, kind="uint16")`,
+		`resp.set_option(26, -1, kind="uint8")`,
+		`resp.set_option(43, "zz", kind="hex")`,
+		`resp.set_option(43, "x", kind="float")`,
+		`resp.set_option(255, "x")`,
+		`resp.yiaddr = "2001:db8::1"`,
+		`resp.stop = 1`,
+		`resp.message_type = "ACK"`,
+	} {
+		dir, err := ioutil.TempDir("", "coredhcptest")
+		require.NoError(t, err)
+		p, err := setupScript(entryPoint4, writeScript(t, dir, "def handle4(req, resp):\n    "+src+"\n"))
+		require.NoError(t, err)
+		req, resp := newRequest4(t, "host")
+		r := newResponse4(resp)
+		assert.Error(t, p.run(log, request4(&handler.PropagateState{}, req), r), src)
+		p.Close()
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,344 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package script
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// request4 returns the read-only Starlark value describing a DHCPv4 request
+func request4(state *handler.PropagateState, req *dhcpv4.DHCPv4) starlark.Value {
+	opts := new(starlark.Dict)
+	for code, value := range req.Options {
+		_ = opts.SetKey(starlark.MakeInt(int(code)), starlark.String(value))
+	}
+	r := starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"interface":    starlark.String(state.InterfaceName),
+		"classes":      classesValue(state),
+		"message_type": starlark.String(req.MessageType().String()),
+		"xid":          starlark.MakeUint64(uint64(binary.BigEndian.Uint32(req.TransactionID[:]))),
+		"mac":          starlark.String(req.ClientHWAddr.String()),
+		"ciaddr":       ipValue(req.ClientIPAddr),
+		"giaddr":       ipValue(req.GatewayIPAddr),
+		"hostname":     starlark.String(req.HostName()),
+		"options":      opts,
+	})
+	r.Freeze()
+	return r
+}
+
+// request6 returns the read-only Starlark value describing a DHCPv6 request.
+// Options map option codes to the list of values of the options with that
+// code.
+func request6(state *handler.PropagateState, req dhcpv6.DHCPv6, msg *dhcpv6.Message) starlark.Value {
+	lists := make(map[dhcpv6.OptionCode]*starlark.List)
+	opts := new(starlark.Dict)
+	for _, opt := range msg.Options.Options {
+		l, ok := lists[opt.Code()]
+		if !ok {
+			l = starlark.NewList(nil)
+			lists[opt.Code()] = l
+			_ = opts.SetKey(starlark.MakeInt(int(opt.Code())), l)
+		}
+		_ = l.Append(starlark.String(opt.ToBytes()))
+	}
+	var duid, mac string
+	if id := msg.Options.ClientID(); id != nil {
+		duid = hex.EncodeToString(id.ToBytes())
+	}
+	if hwaddr, err := dhcpv6.ExtractMAC(req); err == nil {
+		mac = hwaddr.String()
+	}
+	r := starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"interface":    starlark.String(state.InterfaceName),
+		"classes":      classesValue(state),
+		"message_type": starlark.String(msg.MessageType.String()),
+		"xid":          starlark.MakeUint64(uint64(binary.BigEndian.Uint32(append([]byte{0}, msg.TransactionID[:]...)))),
+		"mac":          starlark.String(mac),
+		"duid":         starlark.String(duid),
+		"options":      opts,
+	})
+	r.Freeze()
+	return r
+}
+
+// classesValue returns the classes of the client as a frozen Starlark list
+func classesValue(state *handler.PropagateState) starlark.Value {
+	classes := make([]starlark.Value, 0, len(state.Classes))
+	for _, class := range state.Classes {
+		classes = append(classes, starlark.String(class))
+	}
+	l := starlark.NewList(classes)
+	l.Freeze()
+	return l
+}
+
+// ipValue returns ip as a Starlark string, or None for the unspecified address
+func ipValue(ip net.IP) starlark.Value {
+	if ip == nil || ip.IsUnspecified() {
+		return starlark.None
+	}
+	return starlark.String(ip.String())
+}
+
+// response is the Starlark value scripts modify a response through. Changes
+// are made on a copy of the options, and only applied to the response when
+// the script succeeds.
+type response struct {
+	messageType string
+	// v4 options, and yiaddr
+	options4 dhcpv4.Options
+	yiaddr   net.IP
+	// v6 options
+	options6 dhcpv6.Options
+	v6       bool
+	stop     bool
+	drop     bool
+}
+
+func newResponse4(resp *dhcpv4.DHCPv4) *response {
+	r := &response{
+		messageType: resp.MessageType().String(),
+		options4:    make(dhcpv4.Options, len(resp.Options)),
+		yiaddr:      resp.YourIPAddr,
+	}
+	for code, value := range resp.Options {
+		r.options4[code] = value
+	}
+	return r
+}
+
+func newResponse6(msg *dhcpv6.Message) *response {
+	return &response{
+		messageType: msg.MessageType.String(),
+		options6:    append(dhcpv6.Options(nil), msg.Options.Options...),
+		v6:          true,
+	}
+}
+
+var (
+	_ starlark.HasSetField = (*response)(nil)
+)
+
+func (r *response) String() string        { return "response(" + r.messageType + ")" }
+func (r *response) Type() string          { return "response" }
+func (r *response) Freeze()               {}
+func (r *response) Truth() starlark.Bool  { return starlark.True }
+func (r *response) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: %s", r.Type()) }
+
+func (r *response) AttrNames() []string {
+	names := []string{"del_option", "drop", "get_option", "message_type", "set_option", "stop"}
+	if !r.v6 {
+		names = append(names, "yiaddr")
+		sort.Strings(names)
+	}
+	return names
+}
+
+func (r *response) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "message_type":
+		return starlark.String(r.messageType), nil
+	case "stop":
+		return starlark.Bool(r.stop), nil
+	case "drop":
+		return starlark.Bool(r.drop), nil
+	case "get_option":
+		return starlark.NewBuiltin(name, r.getOption), nil
+	case "set_option":
+		return starlark.NewBuiltin(name, r.setOption), nil
+	case "del_option":
+		return starlark.NewBuiltin(name, r.delOption), nil
+	case "yiaddr":
+		if !r.v6 {
+			return ipValue(r.yiaddr), nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *response) SetField(name string, val starlark.Value) error {
+	switch name {
+	case "stop", "drop":
+		b, ok := val.(starlark.Bool)
+		if !ok {
+			return fmt.Errorf("%s must be a bool, got %s", name, val.Type())
+		}
+		if name == "stop" {
+			r.stop = bool(b)
+		} else {
+			r.drop = bool(b)
+		}
+		return nil
+	case "yiaddr":
+		if r.v6 {
+			break
+		}
+		s, ok := starlark.AsString(val)
+		ip := net.ParseIP(s)
+		if !ok || ip.To4() == nil {
+			return fmt.Errorf("yiaddr must be an IPv4 address, got %s", val)
+		}
+		r.yiaddr = ip.To4()
+		return nil
+	}
+	return starlark.NoSuchAttrError(fmt.Sprintf("response has no writable field %s", name))
+}
+
+// optionCode checks an option code passed by a script
+func (r *response) optionCode(code int) error {
+	max := 254
+	if r.v6 {
+		max = 0xffff
+	}
+	if code < 1 || code > max {
+		return fmt.Errorf("invalid option code %d", code)
+	}
+	return nil
+}
+
+// getOption implements response.get_option(code): it returns the raw value of
+// an option, or None if it isn't set. For DHCPv6, it returns the value of the
+// first option with that code.
+func (r *response) getOption(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var code int
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &code); err != nil {
+		return nil, err
+	}
+	if r.v6 {
+		if opt := r.options6.GetOne(dhcpv6.OptionCode(code)); opt != nil {
+			return starlark.String(opt.ToBytes()), nil
+		}
+	} else if value, ok := r.options4[uint8(code)]; ok {
+		return starlark.String(value), nil
+	}
+	return starlark.None, nil
+}
+
+// setOption implements response.set_option(code, value, kind="string"), see
+// encodeOption for the kinds. For DHCPv6, it replaces all the options with
+// that code.
+func (r *response) setOption(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		code  int
+		value starlark.Value
+		kind  = "string"
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "code", &code, "value", &value, "kind?", &kind); err != nil {
+		return nil, err
+	}
+	if err := r.optionCode(code); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	data, err := encodeOption(kind, value, r.v6)
+	if err != nil {
+		return nil, fmt.Errorf("%s: option %d: %w", b.Name(), code, err)
+	}
+	if r.v6 {
+		r.options6.Del(dhcpv6.OptionCode(code))
+		r.options6.Add(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionCode(code), OptionData: data})
+	} else {
+		r.options4[uint8(code)] = data
+	}
+	return starlark.None, nil
+}
+
+// delOption implements response.del_option(code)
+func (r *response) delOption(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var code int
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &code); err != nil {
+		return nil, err
+	}
+	if err := r.optionCode(code); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	if r.v6 {
+		r.options6.Del(dhcpv6.OptionCode(code))
+	} else {
+		delete(r.options4, uint8(code))
+	}
+	return starlark.None, nil
+}
+
+// encodeOption encodes the value of an option given by a script. The kinds
+// are the same as for the execute plugin:
+//  string  the value is used as is
+//  hex     a hexadecimal string, optionally with ':' separators
+//  ip      an address, or a list of addresses
+//  uint8, uint16, uint32  an integer
+func encodeOption(kind string, value starlark.Value, ipv6 bool) ([]byte, error) {
+	switch kind {
+	case "string":
+		s, ok := starlark.AsString(value)
+		if !ok {
+			return nil, fmt.Errorf("want a string, got %s", value.Type())
+		}
+		return []byte(s), nil
+	case "hex":
+		s, ok := starlark.AsString(value)
+		if !ok {
+			return nil, fmt.Errorf("want a string, got %s", value.Type())
+		}
+		return hex.DecodeString(strings.Replace(s, ":", "", -1))
+	case "ip":
+		var addrs []starlark.Value
+		switch v := value.(type) {
+		case starlark.String:
+			addrs = []starlark.Value{v}
+		case starlark.Indexable:
+			for i := 0; i < v.Len(); i++ {
+				addrs = append(addrs, v.Index(i))
+			}
+		default:
+			return nil, fmt.Errorf("want an address or a list of addresses, got %s", value.Type())
+		}
+		var data []byte
+		for _, a := range addrs {
+			s, _ := starlark.AsString(a)
+			ip := net.ParseIP(s)
+			if ip == nil || (ip.To4() == nil) != ipv6 {
+				return nil, fmt.Errorf("invalid address %s", a)
+			}
+			if !ipv6 {
+				ip = ip.To4()
+			}
+			data = append(data, ip...)
+		}
+		return data, nil
+	case "uint8", "uint16", "uint32":
+		i, ok := value.(starlark.Int)
+		if !ok {
+			return nil, fmt.Errorf("want an int, got %s", value.Type())
+		}
+		n, ok := i.Uint64()
+		max := map[string]uint64{"uint8": 0xff, "uint16": 0xffff, "uint32": 0xffffffff}[kind]
+		if !ok || n > max {
+			return nil, fmt.Errorf("%s does not fit in %s", i, kind)
+		}
+		switch kind {
+		case "uint8":
+			return []byte{byte(n)}, nil
+		case "uint16":
+			data := make([]byte, 2)
+			binary.BigEndian.PutUint16(data, uint16(n))
+			return data, nil
+		}
+		data := make([]byte, 4)
+		binary.BigEndian.PutUint32(data, uint32(n))
+		return data, nil
+	}
+	return nil, fmt.Errorf("unknown kind %q", kind)
+}
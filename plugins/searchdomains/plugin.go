@@ -32,14 +32,19 @@ var Plugin = plugins.Plugin{
 	Name:   "searchdomains",
 	Setup6: setup6,
 	Setup4: setup4,
+	Args: []plugins.Arg{
+		{Name: "domain", Type: "domain name", Help: "DNS search domain", Repeated: true},
+	},
 }
 
-// These are the DNS search domains that are set by the plugin.
+// PluginState is the data held by an instance of the searchdomains plugin.
 // Note that DHCPv4 and DHCPv6 options are totally independent.
 // If you need the same settings for both, you'll need to configure
 // this plugin once for the v4 and once for the v6 server.
-var v4SearchList []string
-var v6SearchList []string
+type PluginState struct {
+	// SearchList holds the DNS search domains that are set by the plugin
+	SearchList []string
+}
 
 // copySlice creates a new copy of a string slice in memory.
 // This helps to ensure that downstream plugins can't corrupt
@@ -51,27 +56,27 @@ func copySlice(original []string) []string {
 }
 
 func setup6(args ...string) (handler.Handler6, error) {
-	v6SearchList = args
-	log.Printf("Registered domain search list (DHCPv6) %s", v6SearchList)
-	return domainSearchListHandler6, nil
+	p := PluginState{SearchList: copySlice(args)}
+	log.Printf("Registered domain search list (DHCPv6) %s", p.SearchList)
+	return p.domainSearchListHandler6, nil
 }
 
 func setup4(args ...string) (handler.Handler4, error) {
-	v4SearchList = args
-	log.Printf("Registered domain search list (DHCPv4) %s", v4SearchList)
-	return domainSearchListHandler4, nil
+	p := PluginState{SearchList: copySlice(args)}
+	log.Printf("Registered domain search list (DHCPv4) %s", p.SearchList)
+	return p.domainSearchListHandler4, nil
 }
 
-func domainSearchListHandler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+func (p *PluginState) domainSearchListHandler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 	resp.UpdateOption(dhcpv6.OptDomainSearchList(&rfc1035label.Labels{
-		Labels: copySlice(v6SearchList),
+		Labels: copySlice(p.SearchList),
 	}))
 	return resp, false
 }
 
-func domainSearchListHandler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+func (p *PluginState) domainSearchListHandler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	resp.UpdateOption(dhcpv4.OptDomainSearch(&rfc1035label.Labels{
-		Labels: copySlice(v4SearchList),
+		Labels: copySlice(p.SearchList),
 	}))
 	return resp, false
 }
@@ -8,6 +8,7 @@ import (
 	"net"
 	"testing"
 
+	"github.com/coredhcp/coredhcp/handler"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 
@@ -42,7 +43,7 @@ func TestAddDomains6(t *testing.T) {
 	stub.MessageType = dhcpv6.MessageTypeReply
 
 	// Call plugin
-	resp, stop := handler6(req, stub)
+	resp, stop := handler6(&handler.PropagateState{}, req, stub)
 	if resp == nil {
 		t.Fatal("plugin did not return a message")
 	}
@@ -82,7 +83,7 @@ func TestAddDomains4(t *testing.T) {
 	}
 
 	// Call plugin
-	resp, stop := handler4(req, stub)
+	resp, stop := handler4(&handler.PropagateState{}, req, stub)
 	if resp == nil {
 		t.Fatal("plugin did not return a message")
 	}
@@ -24,17 +24,26 @@ var Plugin = plugins.Plugin{
 	Name:   "server_id",
 	Setup6: setup6,
 	Setup4: setup4,
+	Args: []plugins.Arg{
+		{Name: "id", Type: "IPv4, or LL or LLT", Help: "server identifier, or DUID type for DHCPv6"},
+		{Name: "address", Type: "MAC", Help: "link-layer address of the DUID (DHCPv6 only)", Optional: true},
+	},
+	// requests for other servers must be dropped before addresses are
+	// allocated for them
+	MustPrecede: []string{"file", "range", "prefix"},
 }
 
-// v6ServerID is the DUID of the v6 server
-var (
+// PluginState is the data held by an instance of the server_id plugin
+type PluginState struct {
+	// v6ServerID is the DUID of the v6 server
 	v6ServerID dhcpv6.DUID
+	// v4ServerID is the address of the v4 server
 	v4ServerID net.IP
-)
+}
 
 // Handler6 handles DHCPv6 packets for the server_id plugin.
-func Handler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
-	if v6ServerID == nil {
+func (p *PluginState) Handler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	if p.v6ServerID == nil {
 		log.Fatal("BUG: Plugin is running uninitialized!")
 		return nil, true
 	}
@@ -56,8 +65,8 @@ func Handler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DH
 		}
 
 		// Approximately all others MUST be discarded if the ServerID doesn't match
-		if !sid.Equal(v6ServerID) {
-			log.Infof("requested server ID does not match this server's ID. Got %v, want %v", sid, v6ServerID)
+		if !sid.Equal(p.v6ServerID) {
+			log.Infof("requested server ID does not match this server's ID. Got %v, want %v", sid, p.v6ServerID)
 			return nil, true
 		}
 	} else if msg.MessageType == dhcpv6.MessageTypeRequest ||
@@ -68,13 +77,13 @@ func Handler6(state *handler.PropagateState, req, resp dhcpv6.DHCPv6) (dhcpv6.DH
 		// These message types MUST be discarded if they *don't* contain a ServerID option
 		return nil, true
 	}
-	dhcpv6.WithServerID(v6ServerID)(resp)
+	dhcpv6.WithServerID(p.v6ServerID)(resp)
 	return resp, false
 }
 
 // Handler4 handles DHCPv4 packets for the server_id plugin.
-func Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
-	if v4ServerID == nil {
+func (p *PluginState) Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if p.v4ServerID == nil {
 		log.Fatal("BUG: Plugin is running uninitialized!")
 		return nil, true
 	}
@@ -84,14 +93,14 @@ func Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.
 	}
 	if req.ServerIPAddr != nil &&
 		!req.ServerIPAddr.Equal(net.IPv4zero) &&
-		!req.ServerIPAddr.Equal(v4ServerID) {
+		!req.ServerIPAddr.Equal(p.v4ServerID) {
 		// This request is not for us, drop it.
-		log.Infof("requested server ID does not match this server's ID. Got %v, want %v", req.ServerIPAddr, v4ServerID)
+		log.Infof("requested server ID does not match this server's ID. Got %v, want %v", req.ServerIPAddr, p.v4ServerID)
 		return nil, true
 	}
 	resp.ServerIPAddr = make(net.IP, net.IPv4len)
-	copy(resp.ServerIPAddr[:], v4ServerID)
-	resp.UpdateOption(dhcpv4.OptServerIdentifier(v4ServerID))
+	copy(resp.ServerIPAddr[:], p.v4ServerID)
+	resp.UpdateOption(dhcpv4.OptServerIdentifier(p.v4ServerID))
 	return resp, false
 }
 
@@ -107,8 +116,8 @@ func setup4(args ...string) (handler.Handler4, error) {
 	if serverID.To4() == nil {
 		return nil, errors.New("not a valid IPv4 address")
 	}
-	v4ServerID = serverID.To4()
-	return Handler4, nil
+	p := PluginState{v4ServerID: serverID.To4()}
+	return p.Handler4, nil
 }
 
 func setup6(args ...string) (handler.Handler6, error) {
@@ -129,15 +138,16 @@ func setup6(args ...string) (handler.Handler6, error) {
 	if err != nil {
 		return nil, err
 	}
+	var p PluginState
 	switch duidType {
 	case "ll", "duid-ll", "duid_ll":
-		v6ServerID = &dhcpv6.DUIDLL{
+		p.v6ServerID = &dhcpv6.DUIDLL{
 			// sorry, only ethernet for now
 			HWType:        iana.HWTypeEthernet,
 			LinkLayerAddr: hwaddr,
 		}
 	case "llt", "duid-llt", "duid_llt":
-		v6ServerID = &dhcpv6.DUIDLLT{
+		p.v6ServerID = &dhcpv6.DUIDLLT{
 			// sorry, zero-time for now
 			Time: 0,
 			// sorry, only ethernet for now
@@ -151,5 +161,5 @@ func setup6(args ...string) (handler.Handler6, error) {
 	}
 	log.Printf("using %s %s", duidType, duidValue)
 
-	return Handler6, nil
+	return p.Handler6, nil
 }
@@ -8,6 +8,7 @@ import (
 	"net"
 	"testing"
 
+	"github.com/coredhcp/coredhcp/handler"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 )
 
@@ -25,7 +26,7 @@ func TestRejectBadServerIDV6(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	v6ServerID = makeTestDUID("0000000000000000")
+	p := PluginState{v6ServerID: makeTestDUID("0000000000000000")}
 
 	req.MessageType = dhcpv6.MessageTypeRenew
 	dhcpv6.WithClientID(makeTestDUID("1000000000000000"))(req)
@@ -36,7 +37,7 @@ func TestRejectBadServerIDV6(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	resp, stop := Handler6(req, stub)
+	resp, stop := p.Handler6(&handler.PropagateState{}, req, stub)
 	if resp != nil {
 		t.Error("server_id is sending a response message to a request with mismatched ServerID")
 	}
@@ -50,7 +51,7 @@ func TestRejectUnexpectedServerIDV6(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	v6ServerID = makeTestDUID("0000000000000000")
+	p := PluginState{v6ServerID: makeTestDUID("0000000000000000")}
 
 	req.MessageType = dhcpv6.MessageTypeSolicit
 	dhcpv6.WithClientID(makeTestDUID("1000000000000000"))(req)
@@ -61,7 +62,7 @@ func TestRejectUnexpectedServerIDV6(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	resp, stop := Handler6(req, stub)
+	resp, stop := p.Handler6(&handler.PropagateState{}, req, stub)
 	if resp != nil {
 		t.Error("server_id is sending a response message to a solicit with a ServerID")
 	}
@@ -75,7 +76,7 @@ func TestAddServerIDV6(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	v6ServerID = makeTestDUID("0000000000000000")
+	p := PluginState{v6ServerID: makeTestDUID("0000000000000000")}
 
 	req.MessageType = dhcpv6.MessageTypeRebind
 	dhcpv6.WithClientID(makeTestDUID("1000000000000000"))(req)
@@ -85,15 +86,15 @@ func TestAddServerIDV6(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	resp, _ := Handler6(req, stub)
+	resp, _ := p.Handler6(&handler.PropagateState{}, req, stub)
 	if resp == nil {
 		t.Fatal("plugin did not return an answer")
 	}
 
 	if opt := resp.(*dhcpv6.Message).Options.ServerID(); opt == nil {
 		t.Fatal("plugin did not add a ServerID option")
-	} else if !opt.Equal(v6ServerID) {
-		t.Fatalf("Got unexpected DUID: expected %v, got %v", v6ServerID, opt)
+	} else if !opt.Equal(p.v6ServerID) {
+		t.Fatalf("Got unexpected DUID: expected %v, got %v", p.v6ServerID, opt)
 	}
 }
 
@@ -102,7 +103,7 @@ func TestRejectInnerMessageServerID(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	v6ServerID = makeTestDUID("0000000000000000")
+	p := PluginState{v6ServerID: makeTestDUID("0000000000000000")}
 
 	req.MessageType = dhcpv6.MessageTypeSolicit
 	dhcpv6.WithClientID(makeTestDUID("1000000000000000"))(req)
@@ -118,7 +119,7 @@ func TestRejectInnerMessageServerID(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	resp, stop := Handler6(relayedRequest, stub)
+	resp, stop := p.Handler6(&handler.PropagateState{}, relayedRequest, stub)
 	if resp != nil {
 		t.Error("server_id is sending a response message to a relayed solicit with a ServerID")
 	}
@@ -0,0 +1,9 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package shared loads plugins built as Go plugins. Go plugins need cgo and
+// the dynamic linker, so loading them is only supported when the server is
+// built with the shared build tag, eg. `go build -tags shared`: otherwise
+// the server stays statically linked and Load always fails.
+package shared
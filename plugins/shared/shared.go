@@ -0,0 +1,50 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build shared
+
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"plugin"
+
+	"github.com/coredhcp/coredhcp/plugins"
+)
+
+// sharedSymbol is the symbol a Go plugin must export
+const sharedSymbol = "Plugin"
+
+// Load loads a plugin from a Go plugin file, built with
+// `go build -buildmode=plugin`. The file must export a variable named Plugin,
+// of type plugins.Plugin or *plugins.Plugin, as the builtin plugins do.
+//
+// Go only loads plugins built with the same toolchain and the same versions
+// of all the packages they share with the server, including coredhcp itself:
+// build them from the module generated by coredhcp-generator.
+// The plugin is not registered, see plugins.RegisterPlugin.
+func Load(path string) (*plugins.Plugin, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(sharedSymbol)
+	if err != nil {
+		return nil, err
+	}
+	var pl *plugins.Plugin
+	switch v := sym.(type) {
+	case *plugins.Plugin:
+		pl = v
+	case **plugins.Plugin:
+		pl = *v
+	default:
+		return nil, fmt.Errorf("%s: symbol %s has type %T, want plugins.Plugin", path, sharedSymbol, sym)
+	}
+	if pl == nil || pl.Name == "" {
+		return nil, errors.New(path + ": plugin has no name")
+	}
+	return pl, nil
+}
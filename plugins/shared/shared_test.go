@@ -0,0 +1,29 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package shared
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcp-shared")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = Load(filepath.Join(dir, "missing.so"))
+	assert.Error(t, err)
+
+	notPlugin := filepath.Join(dir, "garbage.so")
+	require.NoError(t, ioutil.WriteFile(notPlugin, []byte("not an ELF file"), 0644))
+	_, err = Load(notPlugin)
+	assert.Error(t, err)
+}
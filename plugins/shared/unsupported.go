@@ -0,0 +1,19 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build !shared
+
+package shared
+
+import (
+	"errors"
+
+	"github.com/coredhcp/coredhcp/plugins"
+)
+
+// Load fails: this server was built without support for Go plugins, see the
+// shared build tag
+func Load(path string) (*plugins.Plugin, error) {
+	return nil, errors.New(path + ": loading Go plugins is not supported by this build, rebuild coredhcp with `-tags shared`")
+}
@@ -42,6 +42,9 @@ var Plugin = plugins.Plugin{
 	Name:   pluginName,
 	Setup6: setup6,
 	Setup4: setup4,
+	Args: []plugins.Arg{
+		{Name: "delay", Type: "duration", Help: "delay before passing the request to the next plugin"},
+	},
 }
 
 func setup6(args ...string) (handler.Handler6, error) {
@@ -21,13 +21,31 @@ var log = logger.GetLogger("plugins/staticroute")
 var Plugin = plugins.Plugin{
 	Name:   "staticroute",
 	Setup4: setup4,
+	Args: []plugins.Arg{
+		{Name: "route", Type: "<destination CIDR>,<gateway IP>", Help: "classless static route", Repeated: true},
+	},
 }
 
-var routes dhcpv4.Routes
+// PluginState is the data held by an instance of the staticroute plugin
+type PluginState struct {
+	Routes dhcpv4.Routes
+}
 
 func setup4(args ...string) (handler.Handler4, error) {
 	log.Printf("loaded plugin for DHCPv4.")
-	routes = make(dhcpv4.Routes, 0)
+	routes, err := parseRoutes(args...)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("loaded %d static routes.", len(routes))
+
+	p := PluginState{Routes: routes}
+	return p.Handler4, nil
+}
+
+// parseRoutes parses routes in the "<destination>,<gateway>" format
+func parseRoutes(args ...string) (dhcpv4.Routes, error) {
+	routes := make(dhcpv4.Routes, 0)
 
 	if len(args) < 1 {
 		return nil, errors.New("need at least one static route")
@@ -37,35 +55,32 @@ func setup4(args ...string) (handler.Handler4, error) {
 	for _, arg := range args {
 		fields := strings.Split(arg, ",")
 		if len(fields) != 2 {
-			return Handler4, errors.New("expected a destination/gateway pair, got: " + arg)
+			return nil, errors.New("expected a destination/gateway pair, got: " + arg)
 		}
 
 		route := &dhcpv4.Route{}
 		_, route.Dest, err = net.ParseCIDR(fields[0])
 		if err != nil {
-			return Handler4, errors.New("expected a destination subnet, got: " + fields[0])
+			return nil, errors.New("expected a destination subnet, got: " + fields[0])
 		}
 
 		route.Router = net.ParseIP(fields[1])
 		if route.Router == nil {
-			return Handler4, errors.New("expected a gateway address, got: " + fields[1])
+			return nil, errors.New("expected a gateway address, got: " + fields[1])
 		}
 
 		routes = append(routes, route)
 		log.Debugf("adding static route %s", route)
 	}
-
-	log.Printf("loaded %d static routes.", len(routes))
-
-	return Handler4, nil
+	return routes, nil
 }
 
 // Handler4 handles DHCPv4 packets for the static routes plugin
-func Handler4(state *handler.PropagateState, eq, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
-	if len(routes) > 0 {
+func (p *PluginState) Handler4(state *handler.PropagateState, eq, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if len(p.Routes) > 0 {
 		resp.Options.Update(dhcpv4.Option{
 			Code:  dhcpv4.OptionCode(dhcpv4.OptionClasslessStaticRoute),
-			Value: routes,
+			Value: p.Routes,
 		})
 	}
 
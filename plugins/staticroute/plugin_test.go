@@ -11,8 +11,6 @@ import (
 )
 
 func TestSetup4(t *testing.T) {
-	assert.Empty(t, routes)
-
 	var err error
 	// no args
 	_, err = setup4()
@@ -39,7 +37,7 @@ func TestSetup4(t *testing.T) {
 	}
 
 	// valid route
-	_, err = setup4("10.0.0.0/8,192.168.1.1")
+	routes, err := parseRoutes("10.0.0.0/8,192.168.1.1")
 	if assert.NoError(t, err) {
 		if assert.Equal(t, 1, len(routes)) {
 			assert.Equal(t, "10.0.0.0/8", routes[0].Dest.String())
@@ -48,7 +46,7 @@ func TestSetup4(t *testing.T) {
 	}
 
 	// multiple valid routes
-	_, err = setup4("10.0.0.0/8,192.168.1.1", "192.168.2.0/24,192.168.1.100")
+	routes, err = parseRoutes("10.0.0.0/8,192.168.1.1", "192.168.2.0/24,192.168.1.100")
 	if assert.NoError(t, err) {
 		if assert.Equal(t, 2, len(routes)) {
 			assert.Equal(t, "10.0.0.0/8", routes[0].Dest.String())
@@ -57,4 +55,8 @@ func TestSetup4(t *testing.T) {
 			assert.Equal(t, "192.168.1.100", routes[1].Router.String())
 		}
 	}
+
+	h, err := setup4("10.0.0.0/8,192.168.1.1")
+	assert.NoError(t, err)
+	assert.NotNil(t, h)
 }
@@ -1,5 +1,9 @@
 /*
 This plugin supports assignment with  /30 addresses for small, segmented subnets
+
+The leases handed out can be recorded in a lease store, given with the store
+key of the map form, eg. "store: bolt:leases.db". Positional arguments are
+deprecated, and ignored.
 */
 package tiny_subnets
 
@@ -13,10 +17,14 @@ import (
 	"regexp"
 	"time"
 
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/events"
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/leasestore"
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/sirupsen/logrus"
 )
 
 var TEST_PREFIX = os.Getenv("TEST_PREFIX")
@@ -72,7 +80,7 @@ func requestIP(req DHCPRequest) (DHCPResponse, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		fmt.Println("[-] API HTTP DHCP Request error", resp.StatusCode)
-		return dhcp_resp, fmt.Errorf("failed to get API HTTP dhcp response", resp.StatusCode)
+		return dhcp_resp, fmt.Errorf("failed to get API HTTP dhcp response: %d", resp.StatusCode)
 	}
 
 	return dhcp_resp, nil
@@ -87,18 +95,39 @@ func logRequest(handler http.Handler) http.Handler {
 
 var log = logger.GetLogger("plugins/tiny_subnets")
 
+const pluginName = "tiny_subnets"
+
 // Plugin wraps plugin registration information
 var Plugin = plugins.Plugin{
-	Name:   "tiny_subnets",
-	Setup4: setupPoint,
+	Name:         pluginName,
+	Setup4:       setupPoint,
+	Setup4Config: setupConfig,
+	Args: []plugins.Arg{
+		{Name: "store", Type: "lease store", Help: "where the leases handed out are recorded, eg. leases.txt or bolt:leases.db (default: not recorded). Only in the map form", Optional: true},
+	},
+}
+
+// Config holds the configuration of the plugin, in its map form
+type Config struct {
+	// Store is where the leases handed out are recorded, see
+	// leasestore.Open. They aren't recorded when it is empty.
+	Store string `mapstructure:"store"`
 }
 
 // PluginState is the data held by an instance of the range plugin
 type PluginState struct {
+	// store records the leases handed out, if set
+	store leasestore.Store
 }
 
 // Handler4 handles DHCPv4 packets for the range plugin
 func (p *PluginState) Handler4(state *handler.PropagateState, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	// the addresses are managed by the API, which has nothing to release:
+	// don't ask it for one, or record a lease, on RELEASE and DECLINE
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeRelease, dhcpv4.MessageTypeDecline:
+		return resp, false
+	}
 
 	reg, err := regexp.Compile("[^A-Za-z0-9.-_]+")
 	if err != nil {
@@ -112,9 +141,14 @@ func (p *PluginState) Handler4(state *handler.PropagateState, req, resp *dhcpv4.
 	interfaceName := string(state.InterfaceName)
 	interfaceName = reg.ReplaceAllString(interfaceName, "")
 
+	clog := log.WithFields(logrus.Fields{
+		logger.FieldMAC:       req.ClientHWAddr.String(),
+		logger.FieldInterface: interfaceName,
+	})
+
 	wanif := os.Getenv("WANIF")
 	if wanif != "" && wanif == interfaceName {
-		log.Printf("Refusing to handle DHCP request from upstream WANIF for " + req.ClientHWAddr.String())
+		clog.Info("Refusing to handle DHCP request from upstream WANIF")
 		return nil, true
 	}
 
@@ -147,7 +181,7 @@ func (p *PluginState) Handler4(state *handler.PropagateState, req, resp *dhcpv4.
 			//update the server id to match the DNSIP
 			serverId = net.ParseIP(record.DNSIP)
 		} else {
-			log.Printf("[-] Failed to parse record DNS IP: %s", record.DNSIP)
+			clog.Warningf("Failed to parse record DNS IP: %s", record.DNSIP)
 		}
 	}
 
@@ -157,16 +191,79 @@ func (p *PluginState) Handler4(state *handler.PropagateState, req, resp *dhcpv4.
 	netmask := net.IPv4Mask(255, 255, 255, 252)
 	resp.Options.Update(dhcpv4.OptSubnetMask(netmask))
 
-	log.Printf("found IP address %s for ClientAddr %s", record.IP, req.ClientHWAddr.String())
+	clog.WithField(logger.FieldIP, record.IP).Info("found IP address")
+	if req.MessageType() == dhcpv4.MessageTypeRequest {
+		// only a REQUEST commits the lease, an OFFER is not recorded
+		if p.store != nil && lt > 0 {
+			err := p.store.Put(&leasestore.Lease{
+				Key:     req.ClientHWAddr.String(),
+				Address: resp.YourIPAddr.String(),
+				Expires: time.Now().Add(lt),
+			})
+			if err != nil {
+				clog.Errorf("Could not record lease: %v", err)
+			}
+		}
+		ev := events.Event{
+			Type:      events.TypeFromRequest4(req),
+			Plugin:    pluginName,
+			MAC:       req.ClientHWAddr.String(),
+			IP:        resp.YourIPAddr,
+			Hostname:  req.HostName(),
+			Interface: interfaceName,
+		}
+		if lt > 0 {
+			ev.Expiry = time.Now().Add(lt)
+		}
+		events.Publish(ev)
+	}
 	return resp, false
 }
 
+func setupConfig(pc *config.PluginConfig) (handler.Handler4, error) {
+	if !pc.IsMap() {
+		return setupPoint(pc.Args...)
+	}
+	var conf Config
+	if err := pc.Decode(&conf); err != nil {
+		return nil, err
+	}
+	return setupFromConfig(&conf)
+}
+
 func setupPoint(args ...string) (handler.Handler4, error) {
-	var (
-		p PluginState
-	)
+	/* config arguments were deprecated */
+	if len(args) > 0 {
+		log.Warningf("ignoring deprecated arguments %v, the lease store is set with the store key", args)
+	}
+	return setupFromConfig(&Config{})
+}
 
-	/* config arguments were deprecated  */
+func setupFromConfig(conf *Config) (handler.Handler4, error) {
+	p := &PluginState{}
+	if conf.Store == "" {
+		return p.Handler4, nil
+	}
+	if _, _, err := leasestore.ParseSpec(conf.Store); err != nil {
+		return nil, err
+	}
+	if plugins.DryRun() {
+		return p.Handler4, nil
+	}
+	store, err := leasestore.Open(conf.Store)
+	if err != nil {
+		return nil, err
+	}
+	p.store = store
+	plugins.Manage(p)
 
 	return p.Handler4, nil
 }
+
+// Close closes the lease store. It implements plugins.Closer.
+func (p *PluginState) Close() error {
+	if p.store == nil {
+		return nil
+	}
+	return p.store.Close()
+}
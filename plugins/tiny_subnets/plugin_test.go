@@ -0,0 +1,124 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package tiny_subnets
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/plugins/leasestore"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestState returns a plugin state recording its leases in a temporary
+// store, and served by a fake API counting its requests
+func newTestState(t *testing.T) (*PluginState, *int, func()) {
+	dir, err := ioutil.TempDir("", "test_plugin_tiny_subnets")
+	require.NoError(t, err)
+
+	calls := new(int)
+	ln, err := net.Listen("unix", filepath.Join(dir, "apisock"))
+	require.NoError(t, err)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		_ = json.NewEncoder(w).Encode(DHCPResponse{IP: "10.0.0.2", RouterIP: "10.0.0.1", LeaseTime: "1h"})
+	}))
+	srv.Listener = ln
+	srv.Start()
+	listener := UNIX_API_DHCP_LISTENER
+	UNIX_API_DHCP_LISTENER = ln.Addr().String()
+
+	store, err := leasestore.Open(filepath.Join(dir, "leases.txt"))
+	require.NoError(t, err)
+	p := &PluginState{store: store}
+	return p, calls, func() {
+		p.Close()
+		srv.Close()
+		UNIX_API_DHCP_LISTENER = listener
+		os.RemoveAll(dir)
+	}
+}
+
+func handle(t *testing.T, p *PluginState, mt dhcpv4.MessageType, mac net.HardwareAddr) *dhcpv4.DHCPv4 {
+	req, err := dhcpv4.New(dhcpv4.WithHwAddr(mac), dhcpv4.WithMessageType(mt))
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, _ = p.Handler4(&handler.PropagateState{InterfaceName: "eth0"}, req, resp)
+	return resp
+}
+
+func TestReleaseDecline(t *testing.T) {
+	p, calls, cleanup := newTestState(t)
+	defer cleanup()
+	mac := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+
+	for _, mt := range []dhcpv4.MessageType{dhcpv4.MessageTypeRelease, dhcpv4.MessageTypeDecline} {
+		assert.NotNil(t, handle(t, p, mt, mac), mt)
+		assert.Equal(t, 0, *calls, mt)
+		_, err := p.store.Get(mac.String())
+		assert.Equal(t, leasestore.ErrNotFound, err, mt)
+	}
+
+	resp := handle(t, p, dhcpv4.MessageTypeRequest, mac)
+	require.NotNil(t, resp)
+	assert.Equal(t, 1, *calls)
+	assert.Equal(t, "10.0.0.2", resp.YourIPAddr.String())
+	l, err := p.store.Get(mac.String())
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", l.Address)
+}
+
+func TestRecordOnRequest(t *testing.T) {
+	p, calls, cleanup := newTestState(t)
+	defer cleanup()
+	mac := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+
+	require.NotNil(t, handle(t, p, dhcpv4.MessageTypeDiscover, mac))
+	assert.Equal(t, 1, *calls)
+	_, err := p.store.Get(mac.String())
+	assert.Equal(t, leasestore.ErrNotFound, err)
+
+	require.NotNil(t, handle(t, p, dhcpv4.MessageTypeRequest, mac))
+	l, err := p.store.Get(mac.String())
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", l.Address)
+}
+
+func TestSetup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test_plugin_tiny_subnets")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "leases.txt")
+
+	// deprecated positional arguments are ignored
+	h, err := setupConfig(&config.PluginConfig{Name: pluginName, Args: []string{filename, "unused"}})
+	require.NoError(t, err)
+	assert.NotNil(t, h)
+	_, err = os.Stat(filename)
+	assert.True(t, os.IsNotExist(err))
+
+	// the lease store is set with the store key
+	h, err = setupConfig(&config.PluginConfig{Name: pluginName, Value: map[string]interface{}{"store": filename}})
+	require.NoError(t, err)
+	assert.NotNil(t, h)
+	_, err = os.Stat(filename)
+	assert.NoError(t, err)
+
+	_, err = setupConfig(&config.PluginConfig{Name: pluginName, Value: map[string]interface{}{"file": filename}})
+	assert.Error(t, err)
+	_, err = setupConfig(&config.PluginConfig{Name: pluginName, Value: map[string]interface{}{"store": "bolt:"}})
+	assert.Error(t, err)
+}
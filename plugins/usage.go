@@ -0,0 +1,71 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Arg describes an argument of a plugin. Plugins list their arguments in
+// Plugin.Args to document them in the help text printed by `coredhcp
+// --plugins`.
+type Arg struct {
+	Name string
+	// Type is a short description of the expected value, eg. "IP" or
+	// "duration"
+	Type string
+	Help string
+	// Optional is set for arguments that can be omitted
+	Optional bool
+	// Repeated is set for a last argument that can be given several times
+	Repeated bool
+}
+
+// String returns the argument as shown in a synopsis, eg. "[<timeout>]"
+func (a Arg) String() string {
+	s := "<" + a.Name + ">"
+	if a.Repeated {
+		s += " ..."
+	}
+	if a.Optional {
+		s = "[" + s + "]"
+	}
+	return s
+}
+
+// Usage returns a help text for the plugin: which protocols it supports, a
+// synopsis of its arguments, and their description
+func (p *Plugin) Usage() string {
+	var protocols []string
+	if p.Setup6 != nil || p.Setup6Config != nil {
+		protocols = append(protocols, "DHCPv6")
+	}
+	if p.Setup4 != nil || p.Setup4Config != nil {
+		protocols = append(protocols, "DHCPv4")
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%s)", p.Name, strings.Join(protocols, ", "))
+	if len(p.Args) == 0 {
+		return b.String()
+	}
+	b.WriteString(":")
+	for _, a := range p.Args {
+		b.WriteString(" " + a.String())
+	}
+	width := 0
+	for _, a := range p.Args {
+		if len(a.Name) > width {
+			width = len(a.Name)
+		}
+	}
+	for _, a := range p.Args {
+		fmt.Fprintf(&b, "\n    %-*s  %s", width, a.Name, a.Help)
+		if a.Type != "" {
+			fmt.Fprintf(&b, " (%s)", a.Type)
+		}
+	}
+	return b.String()
+}
@@ -5,6 +5,7 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"sync"
@@ -12,9 +13,12 @@ import (
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
 
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
-	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/sirupsen/logrus"
 )
 
 // HandleMsg6 runs for every received DHCPv6 packet. It will run every
@@ -47,6 +51,8 @@ func (l *listener6) HandleMsg6(buf []byte, oob *ipv6.ControlMessage, peer *net.U
 	case dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeConfirm, dhcpv6.MessageTypeRenew,
 		dhcpv6.MessageTypeRebind, dhcpv6.MessageTypeRelease, dhcpv6.MessageTypeInformationRequest:
 		resp, err = dhcpv6.NewReplyFromMessage(msg)
+	case dhcpv6.MessageTypeDecline:
+		resp, err = newDeclineReply(msg)
 	default:
 		err = fmt.Errorf("MainHandler6: message type %d not supported", msg.Type())
 	}
@@ -59,16 +65,25 @@ func (l *listener6) HandleMsg6(buf []byte, oob *ipv6.ControlMessage, peer *net.U
 	state := handler.PropagateState{InterfaceName: intf.Name}
 
 	var stop bool
-	for _, handler := range l.handlers {
-		resp, stop = handler(&state, d, resp)
+	for _, step := range l.handlers {
+		if step.Guard != nil && !step.Guard.Match6(&state, d) {
+			continue
+		}
+		resp, stop = step.Handler(&state, d, resp)
 		if stop {
 			break
 		}
 	}
 	if resp == nil {
-		log.Print("MainHandler6: dropping request because response is nil")
+		log.WithFields(logrus.Fields{
+			logger.FieldInterface: state.InterfaceName,
+			logger.FieldMsgType:   msg.Type().String(),
+		}).Info("MainHandler6: dropping request because response is nil")
 		return
 	}
+	if msg.Type() == dhcpv6.MessageTypeRelease || msg.Type() == dhcpv6.MessageTypeDecline {
+		completeRelease6(msg, resp)
+	}
 
 	// if the request was relayed, re-encapsulate the response
 	if d.IsRelay() {
@@ -102,6 +117,61 @@ func (l *listener6) HandleMsg6(buf []byte, oob *ipv6.ControlMessage, peer *net.U
 	}
 }
 
+// newDeclineReply builds the Reply to a Decline, which dhcpv6.NewReplyFromMessage
+// doesn't support
+func newDeclineReply(msg *dhcpv6.Message) (*dhcpv6.Message, error) {
+	cid := msg.GetOneOption(dhcpv6.OptionClientID)
+	if cid == nil {
+		return nil, errors.New("Client ID cannot be nil when building REPLY")
+	}
+	rep := &dhcpv6.Message{
+		MessageType:   dhcpv6.MessageTypeReply,
+		TransactionID: msg.TransactionID,
+	}
+	rep.AddOption(cid)
+	return rep, nil
+}
+
+// completeRelease6 finishes the Reply to a Release or Decline as described in
+// RFC 8415: the plugins answer the IAs they have a binding for, the other IAs
+// get a NoBinding status, and the Reply itself a Success status.
+func completeRelease6(msg *dhcpv6.Message, resp dhcpv6.DHCPv6) {
+	reply, ok := resp.(*dhcpv6.Message)
+	if !ok {
+		return
+	}
+	noBinding := func() *dhcpv6.OptStatusCode {
+		return &dhcpv6.OptStatusCode{StatusCode: iana.StatusNoBinding, StatusMessage: "no binding for this IA"}
+	}
+	answeredNA := make(map[[4]byte]bool)
+	for _, ia := range reply.Options.IANA() {
+		answeredNA[ia.IaId] = true
+	}
+	for _, ia := range msg.Options.IANA() {
+		if !answeredNA[ia.IaId] {
+			reply.AddOption(&dhcpv6.OptIANA{
+				IaId:    ia.IaId,
+				Options: dhcpv6.IdentityOptions{Options: dhcpv6.Options{noBinding()}},
+			})
+		}
+	}
+	answeredPD := make(map[[4]byte]bool)
+	for _, ia := range reply.Options.IAPD() {
+		answeredPD[ia.IaId] = true
+	}
+	for _, ia := range msg.Options.IAPD() {
+		if !answeredPD[ia.IaId] {
+			reply.AddOption(&dhcpv6.OptIAPD{
+				IaId:    ia.IaId,
+				Options: dhcpv6.PDOptions{Options: dhcpv6.Options{noBinding()}},
+			})
+		}
+	}
+	if reply.Options.Status() == nil {
+		reply.AddOption(&dhcpv6.OptStatusCode{StatusCode: iana.StatusSuccess})
+	}
+}
+
 func (l *listener4) HandleMsg4(buf []byte, oob *ipv4.ControlMessage, _peer net.Addr) {
 	var (
 		resp, tmp *dhcpv4.DHCPv4
@@ -125,11 +195,15 @@ func (l *listener4) HandleMsg4(buf []byte, oob *ipv4.ControlMessage, _peer net.A
 		log.Printf("MainHandler4: failed to build reply: %v", err)
 		return
 	}
+	// RELEASE and DECLINE are handled by the plugins, but never answered
+	noReply := false
 	switch mt := req.MessageType(); mt {
 	case dhcpv4.MessageTypeDiscover:
 		tmp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeOffer))
 	case dhcpv4.MessageTypeRequest:
 		tmp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeAck))
+	case dhcpv4.MessageTypeRelease, dhcpv4.MessageTypeDecline:
+		noReply = true
 	default:
 		log.Printf("plugins/server: Unhandled message type: %v", mt)
 		return
@@ -140,13 +214,25 @@ func (l *listener4) HandleMsg4(buf []byte, oob *ipv4.ControlMessage, _peer net.A
 	state := handler.PropagateState{InterfaceName: intf.Name}
 
 	resp = tmp
-	for _, handler := range l.handlers {
-		resp, stop = handler(&state, req, resp)
+	for _, step := range l.handlers {
+		if step.Guard != nil && !step.Guard.Match4(&state, req) {
+			continue
+		}
+		resp, stop = step.Handler(&state, req, resp)
 		if stop {
 			break
 		}
 	}
 
+	if noReply {
+		log.WithFields(logrus.Fields{
+			logger.FieldMAC:       req.ClientHWAddr.String(),
+			logger.FieldInterface: state.InterfaceName,
+			logger.FieldMsgType:   req.MessageType().String(),
+		}).Debug("MainHandler4: request handled, no response is sent")
+		return
+	}
+
 	if resp != nil {
 		useEthernet := false
 		var peer *net.UDPAddr
@@ -196,7 +282,11 @@ func (l *listener4) HandleMsg4(buf []byte, oob *ipv4.ControlMessage, _peer net.A
 			}
 		}
 	} else {
-		log.Print("MainHandler4: dropping request because response is nil")
+		log.WithFields(logrus.Fields{
+			logger.FieldMAC:       req.ClientHWAddr.String(),
+			logger.FieldInterface: state.InterfaceName,
+			logger.FieldMsgType:   req.MessageType().String(),
+		}).Info("MainHandler4: dropping request because response is nil")
 	}
 }
 
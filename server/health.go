@@ -0,0 +1,49 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// healthPath is the URL path of the health check endpoint
+const healthPath = "/health"
+
+// ServeHTTP answers health checks: it responds 200 when no plugin reports a
+// problem, and 503 with one problem per line otherwise.
+func (s *Servers) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != healthPath {
+		http.NotFound(w, r)
+		return
+	}
+	errs := s.Health()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(errs) == 0 {
+		fmt.Fprintln(w, "OK")
+		return
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	for _, err := range errs {
+		fmt.Fprintln(w, err)
+	}
+}
+
+// serveHealth starts the HTTP health check endpoint on addr
+func (s *Servers) serveHealth(addr string) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("health: cannot listen on %s: %w", addr, err)
+	}
+	hs := &http.Server{Handler: s}
+	go s.run(func() error {
+		if err := hs.Serve(l); err != http.ErrServerClosed {
+			return fmt.Errorf("health: %w", err)
+		}
+		return nil
+	})
+	return hs, nil
+}
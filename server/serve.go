@@ -8,12 +8,14 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"sync"
 
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
 
 	"github.com/coredhcp/coredhcp/config"
-	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/events"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
 	"github.com/insomniacslk/dhcp/dhcpv4/server4"
@@ -25,13 +27,13 @@ var log = logger.GetLogger("server")
 type listener6 struct {
 	*ipv6.PacketConn
 	net.Interface
-	handlers []handler.Handler6
+	handlers []plugins.Step6
 }
 
 type listener4 struct {
 	*ipv4.PacketConn
 	net.Interface
-	handlers []handler.Handler4
+	handlers []plugins.Step4
 }
 
 type listener interface {
@@ -42,6 +44,11 @@ type listener interface {
 type Servers struct {
 	listeners []listener
 	errors    chan error
+	health    *http.Server
+	closeOnce sync.Once
+	// closed is closed when Close starts, done when it has finished
+	closed chan struct{}
+	done   chan struct{}
 }
 
 func listen4(a *net.UDPAddr) (*listener4, error) {
@@ -119,6 +126,19 @@ func Start(config *config.Config) (*Servers, error) {
 	}
 	srv := Servers{
 		errors: make(chan error),
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	// lease event sinks
+	for _, sinkConf := range config.Events {
+		var sink events.Sink
+		sink, err = events.NewSink(sinkConf.Type, sinkConf.Args...)
+		if err != nil {
+			goto cleanup
+		}
+		log.Printf("Sending lease events to %s sink %v", sinkConf.Type, sinkConf.Args)
+		events.Subscribe(sinkConf.Type, sink)
 	}
 
 	// listen
@@ -132,9 +152,7 @@ func Start(config *config.Config) (*Servers, error) {
 			}
 			l6.handlers = handlers6
 			srv.listeners = append(srv.listeners, l6)
-			go func() {
-				srv.errors <- l6.Serve()
-			}()
+			go srv.run(l6.Serve)
 		}
 	}
 
@@ -148,9 +166,15 @@ func Start(config *config.Config) (*Servers, error) {
 			}
 			l4.handlers = handlers4
 			srv.listeners = append(srv.listeners, l4)
-			go func() {
-				srv.errors <- l4.Serve()
-			}()
+			go srv.run(l4.Serve)
+		}
+	}
+
+	if config.Health != "" {
+		log.Printf("Serving health checks on http://%s%s", config.Health, healthPath)
+		srv.health, err = srv.serveHealth(config.Health)
+		if err != nil {
+			goto cleanup
 		}
 	}
 
@@ -161,19 +185,66 @@ cleanup:
 	return nil, err
 }
 
-// Wait waits until the end of the execution of the server.
+// run runs serve, and reports its error to Wait unless the server is being
+// closed
+func (s *Servers) run(serve func() error) {
+	err := serve()
+	select {
+	case s.errors <- err:
+	case <-s.closed:
+	}
+}
+
+// Wait waits until the end of the execution of the server, once the plugins
+// and the lease event sinks are closed. It returns nil if the server was
+// stopped with Close.
 func (s *Servers) Wait() error {
 	log.Debug("Waiting")
-	err := <-s.errors
-	s.Close()
-	return err
+	select {
+	case err := <-s.errors:
+		select {
+		case <-s.closed:
+			// the listeners fail when Close closes them
+			<-s.done
+			return nil
+		default:
+		}
+		s.Close()
+		return err
+	case <-s.done:
+		return nil
+	}
 }
 
-// Close closes all listening connections
+// Close closes all listening connections, the plugins and the lease event
+// sinks. Only the first call has an effect.
 func (s *Servers) Close() {
-	for _, srv := range s.listeners {
-		if srv != nil {
-			srv.Close()
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		if s.health != nil {
+			s.health.Close()
 		}
-	}
+		for _, srv := range s.listeners {
+			if srv != nil {
+				srv.Close()
+			}
+		}
+		for _, err := range plugins.Close() {
+			log.Warningf("Failed to close plugin: %v", err)
+		}
+		events.Close()
+		close(s.done)
+	})
+}
+
+// Reload asks the plugins to reload their external data, like files of static
+// leases. Plugins failing to reload keep their previous state; their errors
+// are returned.
+func (s *Servers) Reload() []error {
+	return plugins.Reload()
+}
+
+// Health returns the problems reported by the plugins, if any
+func (s *Servers) Health() []error {
+	return plugins.Health()
 }